@@ -0,0 +1,318 @@
+package analytics
+
+import (
+	"hash/fnv"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxBuckets is the maximum number of histogram buckets retained per link; older buckets are discarded.
+	maxBuckets = 24 * 7
+	// maxReferrers is the maximum number of distinct referrers counted per link; further referrers are counted under
+	// otherReferrer.
+	maxReferrers = 50
+	// maxUserAgents is the maximum number of distinct user agents counted per link; further user agents are counted
+	// under otherUserAgent.
+	maxUserAgents = 50
+	// maxUserAgentLength is the length which user agents are truncated to, bounding the memory used per link.
+	maxUserAgentLength = 256
+	// maxClients is the maximum number of unique clients counted per link; further clients are not counted, so the
+	// unique client count of a link saturates at maxClients.
+	maxClients = 10000
+
+	directReferrer   = "direct"
+	otherReferrer    = "other"
+	unknownUserAgent = "unknown"
+	otherUserAgent   = "other"
+)
+
+// Click is a single redirect of a short link.
+type Click struct {
+	Hash      string
+	Time      time.Time
+	Referrer  string
+	UserAgent string
+	// ClientIP is the anonymised IP address of the client, see AnonymiseIP.
+	ClientIP string
+}
+
+// Bucket is the number of clicks which occurred within a time window starting at Start.
+type Bucket struct {
+	Start  time.Time `json:"start"`
+	Clicks uint64    `json:"clicks"`
+}
+
+// Stats are the aggregated clicks of a single short link.
+type Stats struct {
+	Hash          string            `json:"hash"`
+	Clicks        uint64            `json:"clicks"`
+	UniqueClients int               `json:"unique_clients"`
+	FirstClick    *time.Time        `json:"first_click,omitempty"`
+	LastClick     *time.Time        `json:"last_click,omitempty"`
+	Referrers     map[string]uint64 `json:"referrers"`
+	UserAgents    map[string]uint64 `json:"user_agents"`
+	BucketSeconds int64             `json:"bucket_seconds"`
+	Histogram     []Bucket          `json:"histogram"`
+}
+
+// linkStats accumulates the clicks of a single short link. Clients are stored as hashes of their addresses, which use
+// less memory than the addresses themselves.
+type linkStats struct {
+	clicks     uint64
+	clients    map[uint64]struct{}
+	firstClick time.Time
+	lastClick  time.Time
+	referrers  map[string]uint64
+	userAgents map[string]uint64
+	buckets    []Bucket
+}
+
+// Recorder asynchronously aggregates clicks into per-link counters and time-bucketed histograms. Clicks are buffered
+// so that recording them never blocks the caller; if the buffer is full, the click is dropped. Recording clicks and
+// reading stats is concurrency safe.
+type Recorder struct {
+	// dropped is accessed atomically so is kept first to guarantee 64-bit alignment
+	dropped    uint64
+	clicks     chan Click
+	bucketSize time.Duration
+
+	// closeMu guards closed and prevents clicks from being sent on a closed channel
+	closeMu *sync.RWMutex
+	closed  bool
+	done    chan struct{}
+
+	mu    *sync.RWMutex
+	stats map[string]*linkStats
+}
+
+// NewRecorder creates a Recorder which buffers up to bufferSize clicks and aggregates them into histogram buckets of
+// the given size. It starts a background goroutine which runs until Close is called.
+func NewRecorder(bufferSize int, bucketSize time.Duration) *Recorder {
+	r := &Recorder{
+		clicks:     make(chan Click, bufferSize),
+		bucketSize: bucketSize,
+		closeMu:    &sync.RWMutex{},
+		done:       make(chan struct{}),
+		mu:         &sync.RWMutex{},
+		stats:      make(map[string]*linkStats),
+	}
+	go r.run()
+	return r
+}
+
+// run aggregates buffered clicks until the click channel is closed.
+func (r *Recorder) run() {
+	defer close(r.done)
+	for click := range r.clicks {
+		r.aggregate(click)
+	}
+}
+
+// Record buffers a click for aggregation without blocking. It returns false if the click was dropped because the
+// buffer is full or the Recorder is closed.
+func (r *Recorder) Record(click Click) bool {
+	r.closeMu.RLock()
+	defer r.closeMu.RUnlock()
+
+	if r.closed {
+		return false
+	}
+
+	select {
+	case r.clicks <- click:
+		return true
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+		return false
+	}
+}
+
+// Dropped returns the number of clicks which were dropped because the buffer was full.
+func (r *Recorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Close stops accepting clicks and blocks until every buffered click has been aggregated.
+func (r *Recorder) Close() {
+	r.closeMu.Lock()
+	if r.closed {
+		r.closeMu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.clicks)
+	r.closeMu.Unlock()
+
+	<-r.done
+}
+
+// aggregate adds a click to the stats of its link.
+func (r *Recorder) aggregate(click Click) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.stats[click.Hash]
+	if !ok {
+		s = &linkStats{
+			clients:    make(map[uint64]struct{}),
+			referrers:  make(map[string]uint64),
+			userAgents: make(map[string]uint64),
+		}
+		r.stats[click.Hash] = s
+	}
+
+	s.clicks++
+	if click.ClientIP != "" && len(s.clients) < maxClients {
+		h := fnv.New64a()
+		h.Write([]byte(click.ClientIP))
+		s.clients[h.Sum64()] = struct{}{}
+	}
+	if s.firstClick.IsZero() || click.Time.Before(s.firstClick) {
+		s.firstClick = click.Time
+	}
+	if click.Time.After(s.lastClick) {
+		s.lastClick = click.Time
+	}
+
+	referrer := referrerHost(click.Referrer)
+	if _, ok := s.referrers[referrer]; !ok && len(s.referrers) >= maxReferrers {
+		referrer = otherReferrer
+	}
+	s.referrers[referrer]++
+
+	userAgent := click.UserAgent
+	if userAgent == "" {
+		userAgent = unknownUserAgent
+	}
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	if _, ok := s.userAgents[userAgent]; !ok && len(s.userAgents) >= maxUserAgents {
+		userAgent = otherUserAgent
+	}
+	s.userAgents[userAgent]++
+
+	s.addToBucket(click.Time.Truncate(r.bucketSize))
+}
+
+// addToBucket increments the bucket starting at the given time, creating it if required. Clicks can arrive slightly
+// out of order, so the buckets are searched from newest to oldest.
+func (s *linkStats) addToBucket(start time.Time) {
+	i := len(s.buckets) - 1
+	for ; i >= 0; i-- {
+		if s.buckets[i].Start.Equal(start) {
+			s.buckets[i].Clicks++
+			return
+		}
+		if s.buckets[i].Start.Before(start) {
+			break
+		}
+	}
+
+	// insert a new bucket after index i to keep the buckets ordered
+	s.buckets = append(s.buckets, Bucket{})
+	copy(s.buckets[i+2:], s.buckets[i+1:])
+	s.buckets[i+1] = Bucket{Start: start, Clicks: 1}
+
+	if len(s.buckets) > maxBuckets {
+		s.buckets = s.buckets[len(s.buckets)-maxBuckets:]
+	}
+}
+
+// Stats returns the aggregated stats of the given link. Links which have not been clicked have zero stats.
+func (r *Recorder) Stats(hash string) Stats {
+	stats := Stats{
+		Hash:          hash,
+		Referrers:     map[string]uint64{},
+		UserAgents:    map[string]uint64{},
+		BucketSeconds: int64(r.bucketSize / time.Second),
+		Histogram:     []Bucket{},
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.stats[hash]
+	if !ok {
+		return stats
+	}
+
+	firstClick, lastClick := s.firstClick, s.lastClick
+	stats.Clicks = s.clicks
+	stats.UniqueClients = len(s.clients)
+	stats.FirstClick = &firstClick
+	stats.LastClick = &lastClick
+	for referrer, clicks := range s.referrers {
+		stats.Referrers[referrer] = clicks
+	}
+	for userAgent, clicks := range s.userAgents {
+		stats.UserAgents[userAgent] = clicks
+	}
+	stats.Histogram = append(stats.Histogram, s.buckets...)
+	return stats
+}
+
+// Reset discards the aggregated stats of the given link, i.e. once it has been deleted, so that a link later created
+// with the same hash does not inherit its clicks. Clicks which are still buffered are aggregated afterwards.
+func (r *Recorder) Reset(hash string) {
+	r.mu.Lock()
+	delete(r.stats, hash)
+	r.mu.Unlock()
+}
+
+// Prune discards the aggregated stats of every link for which keep returns false, i.e. links which have been deleted
+// or have expired, returning the number of links discarded. keep is called without blocking the aggregation of clicks.
+func (r *Recorder) Prune(keep func(hash string) bool) int {
+	r.mu.RLock()
+	hashes := make([]string, 0, len(r.stats))
+	for hash := range r.stats {
+		hashes = append(hashes, hash)
+	}
+	r.mu.RUnlock()
+
+	var pruned int
+	for _, hash := range hashes {
+		if keep(hash) {
+			continue
+		}
+		r.mu.Lock()
+		delete(r.stats, hash)
+		r.mu.Unlock()
+		pruned++
+	}
+	return pruned
+}
+
+// referrerHost reduces a referrer URL to its host, so that referrers are counted per site rather than per page.
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return directReferrer
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || u.Host == "" {
+		return otherReferrer
+	}
+	return u.Hostname()
+}
+
+// AnonymiseIP masks the host portion of an IP address (given with or without a port) so that clients can be counted
+// without storing personally identifiable addresses. IPv4 addresses are truncated to their /24 network and IPv6
+// addresses to their /48 network. An empty string is returned if the address cannot be parsed.
+func AnonymiseIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
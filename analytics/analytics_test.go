@@ -0,0 +1,176 @@
+package analytics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecorder_Stats(t *testing.T) {
+	start := time.Date(2021, 12, 28, 21, 0, 0, 0, time.UTC)
+
+	recorder := NewRecorder(16, time.Hour)
+	clicks := []Click{
+		{Hash: "123456", Time: start.Add(time.Minute), Referrer: "https://news.ycombinator.com/item?id=1", UserAgent: "curl/8.0", ClientIP: "10.0.0.0"},
+		{Hash: "123456", Time: start.Add(time.Hour + time.Minute), ClientIP: "10.0.0.0"},
+		// arrives out of order but belongs in the first bucket
+		{Hash: "123456", Time: start.Add(time.Second), Referrer: "https://news.ycombinator.com/", UserAgent: "curl/8.0", ClientIP: "10.0.1.0"},
+		{Hash: "abcdef", Time: start, ClientIP: "10.0.0.0"},
+	}
+	for _, click := range clicks {
+		if !recorder.Record(click) {
+			t.Fatalf("failed to record click: %+v", click)
+		}
+	}
+	// close to ensure every buffered click has been aggregated
+	recorder.Close()
+
+	if recorder.Record(Click{Hash: "123456"}) {
+		t.Fatal("expected click to be dropped after close")
+	}
+
+	stats := recorder.Stats("123456")
+	if stats.Clicks != 3 {
+		t.Fatalf("unexpected clicks, expected 3, got %d", stats.Clicks)
+	}
+	if stats.UniqueClients != 2 {
+		t.Fatalf("unexpected unique clients, expected 2, got %d", stats.UniqueClients)
+	}
+	if !stats.FirstClick.Equal(start.Add(time.Second)) || !stats.LastClick.Equal(start.Add(time.Hour+time.Minute)) {
+		t.Fatalf("unexpected first/last click: %s, %s", stats.FirstClick, stats.LastClick)
+	}
+	if stats.Referrers["news.ycombinator.com"] != 2 || stats.Referrers[directReferrer] != 1 {
+		t.Fatalf("unexpected referrers: %v", stats.Referrers)
+	}
+	if len(stats.UserAgents) != 2 || stats.UserAgents["curl/8.0"] != 2 || stats.UserAgents[unknownUserAgent] != 1 {
+		t.Fatalf("unexpected user agents: %v", stats.UserAgents)
+	}
+
+	expectedHistogram := []Bucket{
+		{Start: start, Clicks: 2},
+		{Start: start.Add(time.Hour), Clicks: 1},
+	}
+	if len(stats.Histogram) != len(expectedHistogram) {
+		t.Fatalf("unexpected histogram, expected %v, got %v", expectedHistogram, stats.Histogram)
+	}
+	for i, bucket := range expectedHistogram {
+		if !stats.Histogram[i].Start.Equal(bucket.Start) || stats.Histogram[i].Clicks != bucket.Clicks {
+			t.Fatalf("unexpected histogram, expected %v, got %v", expectedHistogram, stats.Histogram)
+		}
+	}
+
+	// links which have never been clicked have zero stats
+	if stats := recorder.Stats("unknown"); stats.Clicks != 0 || stats.FirstClick != nil {
+		t.Fatalf("unexpected stats for unclicked link: %+v", stats)
+	}
+
+	// reset links have zero stats, while other links are unaffected
+	recorder.Reset("123456")
+	if stats := recorder.Stats("123456"); stats.Clicks != 0 || stats.FirstClick != nil || len(stats.UserAgents) != 0 {
+		t.Fatalf("unexpected stats for reset link: %+v", stats)
+	}
+	if stats := recorder.Stats("abcdef"); stats.Clicks != 1 {
+		t.Fatalf("unexpected clicks for other link, expected 1, got %d", stats.Clicks)
+	}
+}
+
+func TestRecorder_UserAgentLimit(t *testing.T) {
+	recorder := NewRecorder(maxUserAgents+1, time.Hour)
+	recorder.Record(Click{Hash: "123456", UserAgent: strings.Repeat("a", maxUserAgentLength*2)})
+	for i := 0; i < maxUserAgents; i++ {
+		recorder.Record(Click{Hash: "123456", UserAgent: fmt.Sprintf("agent/%d", i)})
+	}
+	recorder.Close()
+
+	stats := recorder.Stats("123456")
+	if len(stats.UserAgents) != maxUserAgents+1 {
+		t.Fatalf("unexpected number of user agents, expected %d, got %d", maxUserAgents+1, len(stats.UserAgents))
+	}
+	if stats.UserAgents[strings.Repeat("a", maxUserAgentLength)] != 1 {
+		t.Fatalf("expected long user agent to be truncated to %d characters: %v", maxUserAgentLength, stats.UserAgents)
+	}
+	if stats.UserAgents[otherUserAgent] != 1 {
+		t.Fatalf("unexpected clicks for other user agents, expected 1, got %d", stats.UserAgents[otherUserAgent])
+	}
+}
+
+func TestRecorder_ClientLimit(t *testing.T) {
+	recorder := NewRecorder(maxClients+2, time.Hour)
+	for i := 0; i < maxClients+1; i++ {
+		recorder.Record(Click{Hash: "123456", ClientIP: fmt.Sprintf("10.%d.%d.0", i/256, i%256)})
+	}
+	recorder.Record(Click{Hash: "123456", ClientIP: "10.0.0.0"})
+	recorder.Close()
+
+	stats := recorder.Stats("123456")
+	if stats.Clicks != maxClients+2 {
+		t.Fatalf("unexpected clicks, expected %d, got %d", maxClients+2, stats.Clicks)
+	}
+	if stats.UniqueClients != maxClients {
+		t.Fatalf("unexpected unique clients, expected %d, got %d", maxClients, stats.UniqueClients)
+	}
+}
+
+func TestRecorder_Prune(t *testing.T) {
+	recorder := NewRecorder(10, time.Hour)
+	for _, hash := range []string{"123456", "abcdef", "ghijkl"} {
+		recorder.Record(Click{Hash: hash})
+	}
+	recorder.Close()
+
+	pruned := recorder.Prune(func(hash string) bool {
+		return hash == "abcdef"
+	})
+	if pruned != 2 {
+		t.Fatalf("unexpected pruned links, expected 2, got %d", pruned)
+	}
+	for _, hash := range []string{"123456", "ghijkl"} {
+		if stats := recorder.Stats(hash); stats.Clicks != 0 {
+			t.Fatalf("unexpected clicks for pruned link %s, expected 0, got %d", hash, stats.Clicks)
+		}
+	}
+	if stats := recorder.Stats("abcdef"); stats.Clicks != 1 {
+		t.Fatalf("unexpected clicks for kept link, expected 1, got %d", stats.Clicks)
+	}
+}
+
+func TestRecorder_DropsWhenFull(t *testing.T) {
+	// construct a Recorder without its aggregation goroutine so that the buffer cannot drain
+	recorder := &Recorder{
+		clicks:  make(chan Click, 1),
+		closeMu: &sync.RWMutex{},
+	}
+
+	if !recorder.Record(Click{Hash: "123456"}) {
+		t.Fatal("expected first click to be buffered")
+	}
+	if recorder.Record(Click{Hash: "123456"}) {
+		t.Fatal("expected second click to be dropped")
+	}
+	if recorder.Dropped() != 1 {
+		t.Fatalf("unexpected dropped count, expected 1, got %d", recorder.Dropped())
+	}
+}
+
+func TestAnonymiseIP(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+		want string
+	}{
+		{name: "ipv4_with_port", addr: "192.168.1.123:54321", want: "192.168.1.0"},
+		{name: "ipv4", addr: "192.168.1.123", want: "192.168.1.0"},
+		{name: "ipv6_with_port", addr: "[2001:db8:85a3:8d3:1319:8a2e:370:7348]:443", want: "2001:db8:85a3::"},
+		{name: "invalid", addr: "not-an-ip", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AnonymiseIP(tt.addr); got != tt.want {
+				t.Fatalf("expected: %s, got: %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxAliasLength is the maximum permitted length of a custom alias.
+const maxAliasLength = 64
+
+// reservedAliases are aliases which cannot be claimed as they clash with (or may in future clash with) server routes.
+var reservedAliases = map[string]struct{}{
+	"api":         {},
+	"admin":       {},
+	"metrics":     {},
+	"health":      {},
+	"static":      {},
+	"favicon.ico": {},
+	"robots.txt":  {},
+}
+
+var errEmptyAlias = errors.New("alias must not be empty")
+
+// validateAlias ensures a custom alias only contains URL-safe characters (letters, digits, hyphens and underscores),
+// is within the permitted length and is not a reserved word.
+func validateAlias(alias string) error {
+	if alias == "" {
+		return errEmptyAlias
+	}
+	if len(alias) > maxAliasLength {
+		return fmt.Errorf("alias must not exceed %d characters", maxAliasLength)
+	}
+
+	for _, c := range alias {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return fmt.Errorf("alias contains invalid character %q", c)
+		}
+	}
+
+	if _, ok := reservedAliases[strings.ToLower(alias)]; ok {
+		return fmt.Errorf("alias %q is reserved", alias)
+	}
+	return nil
+}
@@ -1,19 +1,67 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/jemgunay/url-shortener/analytics"
 	"github.com/jemgunay/url-shortener/hash"
 	"github.com/jemgunay/url-shortener/store"
 )
 
+// shortenPath is the path of the shorten endpoint.
+const shortenPath = "/api/v1/shorten"
+
 // shortenPayload is the payload expected by the ShortenHandler.
 type shortenPayload struct {
 	OriginalURL string `json:"original_url"`
+	// CustomAlias is an optional vanity hash to use in place of a generated one.
+	CustomAlias string `json:"custom_alias,omitempty"`
+	// TTL is an optional duration (i.e. "36h") after which the link expires. It cannot be combined with ExpiresAt.
+	TTL string `json:"ttl,omitempty"`
+	// ExpiresAt is an optional RFC 3339 time at which the link expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RedirectStatus is an optional HTTP status code (301, 302, 307 or 308) to redirect with. The server's default is
+	// used if it is omitted.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// Domain is the optional domain to create the link on if multiple domains are enabled. The default domain is used
+	// if it is omitted.
+	Domain string `json:"domain,omitempty"`
+}
+
+// expiry resolves the time at which a link created at the given time should expire. A zero time is returned if the
+// payload requests no expiry.
+func (p shortenPayload) expiry(now time.Time) (time.Time, error) {
+	switch {
+	case p.TTL != "" && p.ExpiresAt != nil:
+		return time.Time{}, errors.New("ttl and expires_at are mutually exclusive")
+
+	case p.TTL != "":
+		ttl, err := time.ParseDuration(p.TTL)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid ttl: %s", err)
+		}
+		if ttl <= 0 {
+			return time.Time{}, errors.New("ttl must be positive")
+		}
+		return now.Add(ttl), nil
+
+	case p.ExpiresAt != nil:
+		if !p.ExpiresAt.After(now) {
+			return time.Time{}, errors.New("expires_at must be in the future")
+		}
+		return p.ExpiresAt.UTC(), nil
+	}
+	return time.Time{}, nil
 }
 
 // shortenResponse is the payload returned by the ShortenHandler. It is composed of the shortenPayload.
@@ -23,103 +71,420 @@ type shortenResponse struct {
 	shortenPayload
 }
 
-// API implements the URL shortener HTTP handlers. It also stores references to a Hasher and Storage for persisting
-// short URLs.
+// API implements the URL shortener HTTP handlers. It also stores references to a ContextHasher and ContextStorage for
+// persisting short URLs, which are passed the context of each request so that their operations are abandoned once the
+// client disconnects.
 type API struct {
-	hasher  hash.Hasher
-	storage store.Storage
+	hasher      hash.ContextHasher
+	storage     store.ContextStorage
+	recorder    *analytics.Recorder
+	idempotency *idempotencyCache
+	// dedupMu serialises deduplicated shortens; deduplication is disabled if it is nil
+	dedupMu *sync.Mutex
+	// defaultRedirectStatus is used to redirect to links which were not created with a redirect status
+	defaultRedirectStatus int
+	// keys authenticates requests; authentication is disabled if it is nil
+	keys *KeyStore
+	// trustedProxies are the networks whose X-Forwarded-For headers are trusted
+	trustedProxies []*net.IPNet
+	logger         *slog.Logger
+	// baseURL is the canonical base of short URLs; it is derived from each request if nil
+	baseURL          *url.URL
+	forwardedHeaders bool
+	// domains are the domains which links are namespaced by, the first being the default; links are not namespaced if
+	// it is empty
+	domains []string
+	// batchWorkers is the number of batch items shortened concurrently
+	batchWorkers int
+	// transferTimeout is the maximum duration of an export or import; it is unbounded if zero
+	transferTimeout time.Duration
+}
+
+// Option configures optional API behaviour.
+type Option func(*API)
+
+// WithRecorder records a click with the given Recorder for every successful redirect.
+func WithRecorder(recorder *analytics.Recorder) Option {
+	return func(a *API) {
+		a.recorder = recorder
+	}
+}
+
+// WithDedup enables deduplication, where shortening a URL which already has a non-expiring link returns the existing
+// link rather than creating a new one.
+func WithDedup() Option {
+	return func(a *API) {
+		a.dedupMu = &sync.Mutex{}
+	}
+}
+
+// WithDefaultRedirectStatus sets the HTTP status code used to redirect to links which were not created with a redirect
+// status. The status must satisfy ValidRedirectStatus. Defaults to 301 Moved Permanently.
+func WithDefaultRedirectStatus(status int) Option {
+	return func(a *API) {
+		a.defaultRedirectStatus = status
+	}
+}
+
+// WithKeyStore enables authentication, where requests to handlers wrapped with Authenticate must carry an API key from
+// the given KeyStore. Links are attributed to the owner of the key which created them, and only that owner (or an
+// admin) may manage them.
+func WithKeyStore(keys *KeyStore) Option {
+	return func(a *API) {
+		a.keys = keys
+	}
 }
 
-// New initialises a new API.
-func New(hasher hash.Hasher, storage store.Storage) API {
-	return API{
-		hasher:  hasher,
-		storage: storage,
+// New initialises a new API. A Hasher or Storage without context support is adapted with hash.WithContext or
+// store.WithContext.
+func New(hasher hash.ContextHasher, storage store.ContextStorage, opts ...Option) API {
+	a := API{
+		hasher:                hasher,
+		storage:               storage,
+		idempotency:           newIdempotencyCache(idempotencyTTL, maxIdempotencyKeys, maxIdempotencyCacheBytes, maxIdempotentResponseBytes),
+		defaultRedirectStatus: http.StatusMovedPermanently,
+		logger:                slog.Default(),
+		transferTimeout:       defaultTransferTimeout,
+	}
+	for _, opt := range opts {
+		opt(&a)
 	}
+	return a
 }
 
-// ShortenHandler takes an original URL payload and stores that URL against a hash. It returns the original URL, the
-// hash and the new redirect URL (which is composed of the hash).
+// ShortenHandler takes an original URL payload and stores that URL against a hash. The URL is validated and
+// normalised first; a 422 Unprocessable Entity describing the problem is returned if it is invalid. Generated hashes
+// never overwrite an existing link; a 503 Service Unavailable is returned if a unique hash cannot be generated. If a
+// custom alias is provided, it is used as the hash and a 409 Conflict is returned if the alias is already taken. It
+// returns the normalised original URL, the hash and the new redirect URL (which is composed of the hash).
+//
+// If deduplication is enabled, the existing link is returned for URLs which have already been shortened without an
+// alias or expiry. Requests made with an Idempotency-Key header are only processed once per key; retries are replayed
+// the original response.
 func (a API) ShortenHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		a.writeMethodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 
+	if r.Header.Get(idempotencyKeyHeader) != "" {
+		a.serveIdempotent(w, r, a.shorten)
+		return
+	}
+	a.shorten(w, r)
+}
+
+// shorten implements the ShortenHandler.
+func (a API) shorten(w http.ResponseWriter, r *http.Request) {
 	payload := shortenPayload{}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		log.Printf("failed to JSON unmarshal request payload: %s", err)
-		w.WriteHeader(http.StatusBadRequest)
+		a.log(r).Debug("failed to JSON unmarshal request payload", "error", err)
+		a.writeError(w, r, http.StatusBadRequest, codeBadRequest, "request payload must be valid JSON")
 		return
 	}
 
-	// generate hash for the given URL
-	hashID, err := a.hasher.Hash(payload.OriginalURL)
-	if err != nil {
-		log.Printf("failed to hash original URL: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	respBody, failure := a.createLink(r, payload)
+	if failure != nil {
+		a.writeStatusError(w, r, failure)
 		return
 	}
+	a.writeJSON(w, r, http.StatusOK, respBody)
+}
 
-	// store the new hash against the URL
-	if err := a.storage.Set(hashID, payload.OriginalURL); err != nil {
-		log.Printf("failed to store URL: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// createLink validates and normalises a shortenPayload, then stores it as a new link (or returns the existing link if
+// it is deduplicated). If the link cannot be created, the reason is returned rather than written so that batches can
+// report it per item.
+func (a API) createLink(r *http.Request, payload shortenPayload) (shortenResponse, *statusError) {
+	originalURL, invalid := normaliseURL("original_url", payload.OriginalURL)
+	if invalid != nil {
+		a.log(r).Debug("invalid original URL", "reason", invalid.String())
+		return shortenResponse{}, newValidationError(*invalid)
+	}
+	payload.OriginalURL = originalURL
+
+	domain, ok := a.resolveDomain(payload.Domain)
+	if !ok {
+		invalid := fieldError{Field: "domain", Reason: "is not an allowed domain"}
+		a.log(r).Debug("invalid domain", "reason", invalid.String(), "domain", payload.Domain)
+		return shortenResponse{}, newValidationError(invalid)
+	}
+	payload.Domain = domain
+
+	if invalid := validateRedirectStatus("redirect_status", payload.RedirectStatus); invalid != nil {
+		a.log(r).Debug("invalid redirect status", "reason", invalid.String())
+		return shortenResponse{}, newValidationError(*invalid)
+	}
+
+	now := time.Now().UTC()
+	expiresAt, err := payload.expiry(now)
+	if err != nil {
+		a.log(r).Debug("invalid expiry", "error", err)
+		return shortenResponse{}, newStatusError(http.StatusBadRequest, codeBadRequest, err.Error())
+	}
+	record := store.Record{
+		URL:            payload.OriginalURL,
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+		RedirectStatus: payload.RedirectStatus,
+		Owner:          owner(r),
+	}
+	if !expiresAt.IsZero() {
+		// report the resolved expiry time in the response
+		payload.ExpiresAt = &expiresAt
+	}
+
+	var hashID string
+	if payload.CustomAlias != "" {
+		// use the requested alias, refusing to overwrite any existing link
+		if err := validateAlias(payload.CustomAlias); err != nil {
+			a.log(r).Debug("invalid custom alias", "error", err)
+			return shortenResponse{}, newStatusError(http.StatusBadRequest, codeBadRequest, err.Error())
+		}
+		hashID = payload.CustomAlias
+
+		if err := a.storage.SetIfAbsentContext(r.Context(), storageKey(domain, hashID), record); err != nil {
+			if err == store.ErrKeyExists {
+				a.log(r).Debug("custom alias already exists", "hash", hashID)
+				return shortenResponse{}, newStatusError(http.StatusConflict, codeConflict, "custom alias "+hashID+" is already taken")
+			}
+			return shortenResponse{}, a.operationError(r, "failed to store URL", err)
+		}
+		a.resetStats(storageKey(domain, hashID))
+	} else {
+		// generate a hash for the given URL which does not collide with an existing link, reusing an existing link to
+		// the URL if deduplication is enabled
+		if a.dedupMu != nil && expiresAt.IsZero() {
+			hashID, err = a.storeDeduplicated(r, domain, record)
+		} else {
+			hashID, err = a.storeWithGeneratedHash(r, domain, record)
+		}
+		if err == errHashAttemptsExhausted {
+			a.log(r).Error("failed to store URL", "error", err)
+			return shortenResponse{}, newStatusError(http.StatusServiceUnavailable, codeUnavailable, "failed to generate a unique hash, try again")
+		}
+		if err != nil {
+			return shortenResponse{}, a.operationError(r, "failed to store URL", err)
+		}
 	}
 
-	respBody := shortenResponse{
+	return shortenResponse{
 		shortenPayload: payload,
+		ShortURL:       a.shortURL(r, domain, hashID),
 		ShortHash:      hashID,
+	}, nil
+}
+
+// maxHashAttempts is the maximum number of hashes generated for a single URL before giving up on finding one which does
+// not collide with an existing link.
+const maxHashAttempts = 5
+
+// errHashAttemptsExhausted indicates that every generated hash collided with an existing link.
+var errHashAttemptsExhausted = errors.New("exhausted attempts to generate a unique hash")
+
+// storeWithGeneratedHash generates a hash for the given record's URL and stores the record against it in the domain's
+// namespace. The hash is only stored if it does not already exist, so an existing link is never overwritten; on
+// collision, a fresh hash is generated up to maxHashAttempts times.
+func (a API) storeWithGeneratedHash(r *http.Request, domain string, record store.Record) (string, error) {
+	for attempt := 1; attempt <= maxHashAttempts; attempt++ {
+		hashID, err := a.hasher.HashContext(r.Context(), record.URL)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash original URL: %w", err)
+		}
+
+		err = a.storage.SetIfAbsentContext(r.Context(), storageKey(domain, hashID), record)
+		if err == nil {
+			a.resetStats(storageKey(domain, hashID))
+			return hashID, nil
+		}
+		if err != store.ErrKeyExists {
+			return "", err
+		}
+		a.log(r).Warn("generated hash collides with an existing link", "hash", hashID, "domain", domain, "attempt", attempt, "max_attempts", maxHashAttempts)
 	}
+	return "", errHashAttemptsExhausted
+}
 
-	// use the host if available, else extract the local address from the request context
-	if r.URL.Host != "" {
-		respBody.ShortURL = r.URL.Host + "/" + hashID
-	} else {
-		srvAddr := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
-		respBody.ShortURL = srvAddr.String() + "/" + hashID
+// storeDeduplicated returns the hash of an existing non-expiring link to the record's URL on the same domain with the
+// same redirect status and owner, otherwise it stores the record against a newly generated hash. Deduplicated shortens
+// are serialised so that concurrent requests for the same URL cannot both create a link.
+func (a API) storeDeduplicated(r *http.Request, domain string, record store.Record) (string, error) {
+	a.dedupMu.Lock()
+	defer a.dedupMu.Unlock()
+
+	// storage keys are namespaced by their domain
+	query := store.URLQuery{URL: record.URL, Owner: record.Owner, RedirectStatus: record.RedirectStatus, Namespace: domain}
+	key, _, err := a.storage.GetByURLContext(r.Context(), query)
+	if err == nil {
+		_, hashID := splitStorageKey(key)
+		return hashID, nil
+	}
+	if err != store.ErrKeyNotFound {
+		return "", fmt.Errorf("failed to look up existing link: %w", err)
+	}
+	return a.storeWithGeneratedHash(r, domain, record)
+}
+
+// RedirectHandler looks up the original URL of the hash in URLs of the form "/{hash}" and redirects to that URL. The
+// link's redirect status is used if it has one, else the API's default (301 unless configured otherwise). A 410 Gone
+// is returned for expired links. If multiple domains are enabled, the hash is looked up in the namespace of the
+// request's host. HEAD requests are answered in the same way but are not recorded as clicks. Reserved paths, such as
+// "/favicon.ico", are never resolved as hashes.
+func (a API) RedirectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		a.writeMethodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	hashID := r.PathValue("hash")
+	if _, ok := reservedAliases[strings.ToLower(hashID)]; ok || hashID == "" {
+		a.log(r).Debug("reserved path is not a hash", "path", r.URL.Path)
+		a.writeNotFound(w, r, "link not found")
+		return
+	}
+
+	domain, ok := a.hostDomain(r)
+	if !ok {
+		a.log(r).Debug("host is not an allowed domain", "host", r.Host)
+		a.writeNotFound(w, r, "link not found")
+		return
 	}
+	key := storageKey(domain, hashID)
 
-	respBytes, err := json.Marshal(respBody)
+	// lookup original URL associated with provided hash ID
+	record, err := a.storage.GetContext(r.Context(), key)
 	if err != nil {
-		log.Printf("failed to JDON marshal response payload: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		if err == store.ErrKeyNotFound {
+			a.log(r).Debug("URL not found", "hash", hashID)
+			a.writeNotFound(w, r, "link not found")
+			return
+		}
+		a.writeStatusError(w, r, a.operationError(r, "failed to perform store URL lookup", err))
+		return
+	}
+
+	// expired links may not have been reaped from the store yet
+	if record.Expired(time.Now()) {
+		a.log(r).Debug("URL expired", "hash", hashID, "expires_at", record.ExpiresAt)
+		a.writeError(w, r, http.StatusGone, codeGone, "link has expired")
 		return
 	}
 
-	w.Write(respBytes)
+	if r.Method == http.MethodGet {
+		a.recordClick(r, key)
+	}
+
+	redirectStatus := record.RedirectStatus
+	if redirectStatus == 0 {
+		redirectStatus = a.defaultRedirectStatus
+	}
+
+	// perform HTTP redirect to original URL
+	http.Redirect(w, r, record.URL, redirectStatus)
 }
 
-// RedirectHandler extracts the hash ID following the URL's final forward slash, does a store lookup for the
-// corresponding original URL and performs a 301 Redirect to that URL.
-func (a API) RedirectHandler(w http.ResponseWriter, r *http.Request) {
+// recordClick records a click of the link stored against the given key if analytics are enabled. Recording is
+// asynchronous so does not delay the redirect.
+func (a API) recordClick(r *http.Request, key string) {
+	if a.recorder == nil {
+		return
+	}
+
+	recorded := a.recorder.Record(analytics.Click{
+		Hash:      key,
+		Time:      time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		ClientIP:  analytics.AnonymiseIP(a.clientIP(r)),
+	})
+	if !recorded {
+		a.log(r).Warn("dropped click", "key", key)
+	}
+}
+
+// resetStats discards the click analytics recorded against the given key if analytics are enabled. Stats are keyed by
+// storage key rather than by link, so they are reset whenever a link is created or deleted to prevent a link from
+// inheriting the clicks of a deleted (or reaped) link with the same hash.
+func (a API) resetStats(key string) {
+	if a.recorder == nil {
+		return
+	}
+	a.recorder.Reset(key)
+}
+
+// StatsReaper wraps a storage Reaper so that, after expired links are reaped, the click analytics of every link which
+// no longer exists or has expired are discarded. It returns the given Reaper if analytics are not enabled.
+func (a API) StatsReaper(reaper store.Reaper) store.Reaper {
+	if a.recorder == nil {
+		return reaper
+	}
+	return statsReaper{api: a, reaper: reaper}
+}
+
+// statsReaper reaps storage, then prunes the click analytics of links which no longer exist.
+type statsReaper struct {
+	api    API
+	reaper store.Reaper
+}
+
+// Reap reaps the records of storage which expired before now, then prunes the click analytics of links which no longer
+// exist or have expired. It returns the number of records reaped from storage.
+func (s statsReaper) Reap(now time.Time) (int, error) {
+	reaped, err := s.reaper.Reap(now)
+
+	pruned := s.api.recorder.Prune(func(key string) bool {
+		record, err := s.api.storage.GetContext(context.Background(), key)
+		if err == store.ErrKeyNotFound {
+			return false
+		}
+		// stats are kept if the link cannot be looked up, so that they are not lost to a transient storage error
+		return err != nil || !record.Expired(now)
+	})
+	if pruned > 0 {
+		s.api.logger.Debug("pruned click analytics of removed links", "links", pruned)
+	}
+	return reaped, err
+}
+
+// StatsHandler returns the click analytics of the hash in URLs of the form "/api/v1/links/{hash}/stats". If multiple
+// domains are enabled, the "domain" query parameter selects the hash's domain.
+func (a API) StatsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		a.writeMethodNotAllowed(w, r, http.MethodGet)
 		return
 	}
 
-	// extract the hash ID from the end of the URL - this adds support for URLs such as "/{hashID}" and "/api/{hashID}"
-	urlComponents := strings.Split(r.URL.Path, "/")
-	if len(urlComponents) == 0 {
-		log.Print("expected at least one URL component")
-		w.WriteHeader(http.StatusBadRequest)
+	hashID := r.PathValue("hash")
+	domain, ok := a.queryDomain(w, r)
+	if !ok {
 		return
 	}
-	hashID := urlComponents[len(urlComponents)-1]
+	key := storageKey(domain, hashID)
 
-	// lookup original URL associated with provided hash ID
-	originalURL, err := a.storage.Get(hashID)
+	if a.recorder == nil {
+		a.log(r).Debug("analytics are not enabled")
+		a.writeNotFound(w, r, "analytics are not enabled")
+		return
+	}
+
+	// only report stats for links which exist and the caller may manage
+	record, err := a.storage.GetContext(r.Context(), key)
 	if err != nil {
 		if err == store.ErrKeyNotFound {
-			log.Printf("URL not found for hash %s: %s", hashID, err)
-			w.WriteHeader(http.StatusNotFound)
+			a.log(r).Debug("URL not found", "hash", hashID)
+			a.writeNotFound(w, r, "link not found")
 			return
 		}
-		log.Printf("failed perform store URL lookup: %s", err)
+		a.writeStatusError(w, r, a.operationError(r, "failed to perform store URL lookup", err))
+		return
+	}
+	if !a.canManage(r, record) {
+		a.log(r).Warn("caller does not own hash", "hash", hashID)
+		a.writeError(w, r, http.StatusForbidden, codeForbidden, "link is owned by another API key")
 		return
 	}
 
-	// perform HTTP redirect to original URL
-	http.Redirect(w, r, originalURL, http.StatusMovedPermanently)
+	// clicks are recorded against the storage key, which is namespaced by domain
+	stats := a.recorder.Stats(key)
+	stats.Hash = hashID
+	a.writeJSON(w, r, http.StatusOK, stats)
 }
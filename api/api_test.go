@@ -2,11 +2,17 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jemgunay/url-shortener/analytics"
 	hashstub "github.com/jemgunay/url-shortener/hash/stub"
 	"github.com/jemgunay/url-shortener/store"
 )
@@ -19,6 +25,7 @@ func TestAPI_ShortenHandler(t *testing.T) {
 		reqBody    string
 		hashVal    string
 		hashErr    error
+		storePairs map[string]string
 		respStatus int
 		respBody   string
 	}{
@@ -30,7 +37,7 @@ func TestAPI_ShortenHandler(t *testing.T) {
 			hashVal:    "123456",
 			hashErr:    nil,
 			respStatus: http.StatusOK,
-			respBody:   `{"short_url":"localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk"}`,
+			respBody:   `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk"}`,
 		},
 		{
 			name:       "invalid_method",
@@ -40,7 +47,7 @@ func TestAPI_ShortenHandler(t *testing.T) {
 			hashVal:    "",
 			hashErr:    nil,
 			respStatus: http.StatusMethodNotAllowed,
-			respBody:   "",
+			respBody:   `{"error":{"code":"method_not_allowed","message":"method GET is not allowed"}}`,
 		},
 		{
 			name:       "hash_error",
@@ -50,7 +57,113 @@ func TestAPI_ShortenHandler(t *testing.T) {
 			hashVal:    "",
 			hashErr:    errors.New("error creating hash"),
 			respStatus: http.StatusInternalServerError,
-			respBody:   "",
+			respBody:   `{"error":{"code":"internal_error","message":"internal server error"}}`,
+		},
+		{
+			name:       "success_normalised_url",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "HTTPS://JemGunay.co.uk:443/blog"}`,
+			hashVal:    "123456",
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk/blog"}`,
+		},
+		{
+			name:       "invalid_url",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "javascript:alert(1)"}`,
+			hashVal:    "123456",
+			respStatus: http.StatusUnprocessableEntity,
+			respBody:   `{"error":{"code":"validation_failed","message":"request payload failed validation","details":[{"field":"original_url","reason":"scheme \"javascript\" is not allowed"}]}}`,
+		},
+		{
+			name:       "success_redirect_status",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "redirect_status": 302}`,
+			hashVal:    "123456",
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk","redirect_status":302}`,
+		},
+		{
+			name:       "invalid_redirect_status",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "redirect_status": 200}`,
+			hashVal:    "123456",
+			respStatus: http.StatusUnprocessableEntity,
+			respBody:   `{"error":{"code":"validation_failed","message":"request payload failed validation","details":[{"field":"redirect_status","reason":"must be one of 301, 302, 307 or 308"}]}}`,
+		},
+		{
+			name:       "success_custom_alias",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "custom_alias": "q3-roadmap"}`,
+			hashErr:    errors.New("hasher should not be called"),
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://localhost:8080/q3-roadmap","short_hash":"q3-roadmap","original_url":"https://jemgunay.co.uk","custom_alias":"q3-roadmap"}`,
+		},
+		{
+			name:       "custom_alias_exists",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "custom_alias": "q3-roadmap"}`,
+			storePairs: map[string]string{"q3-roadmap": "https://jemgunay.co.uk/existing"},
+			respStatus: http.StatusConflict,
+			respBody:   `{"error":{"code":"conflict","message":"custom alias q3-roadmap is already taken"}}`,
+		},
+		{
+			name:       "custom_alias_reserved",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "custom_alias": "API"}`,
+			respStatus: http.StatusBadRequest,
+			respBody:   `{"error":{"code":"bad_request","message":"alias \"API\" is reserved"}}`,
+		},
+		{
+			name:       "success_expires_at",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "expires_at": "2999-01-01T00:00:00Z"}`,
+			hashVal:    "123456",
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk","expires_at":"2999-01-01T00:00:00Z"}`,
+		},
+		{
+			name:       "expires_at_in_past",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "expires_at": "2000-01-01T00:00:00Z"}`,
+			hashVal:    "123456",
+			respStatus: http.StatusBadRequest,
+			respBody:   `{"error":{"code":"bad_request","message":"expires_at must be in the future"}}`,
+		},
+		{
+			name:       "ttl_and_expires_at",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "ttl": "1h", "expires_at": "2999-01-01T00:00:00Z"}`,
+			hashVal:    "123456",
+			respStatus: http.StatusBadRequest,
+			respBody:   `{"error":{"code":"bad_request","message":"ttl and expires_at are mutually exclusive"}}`,
+		},
+		{
+			name:       "invalid_ttl",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "ttl": "-1h"}`,
+			hashVal:    "123456",
+			respStatus: http.StatusBadRequest,
+			respBody:   `{"error":{"code":"bad_request","message":"ttl must be positive"}}`,
+		},
+		{
+			name:       "custom_alias_invalid_characters",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/shorten",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "custom_alias": "q3/roadmap"}`,
+			respStatus: http.StatusBadRequest,
+			respBody:   `{"error":{"code":"bad_request","message":"alias contains invalid character '/'"}}`,
 		},
 	}
 
@@ -62,7 +175,10 @@ func TestAPI_ShortenHandler(t *testing.T) {
 				Err: tt.hashErr,
 			}
 			storeStub := store.New()
-			handlers := New(hashStub, storeStub)
+			for k, v := range tt.storePairs {
+				storeStub.Set(k, store.Record{URL: v})
+			}
+			handlers := New(hashStub, store.WithContext(storeStub))
 
 			// configure the request and response writer
 			w := httptest.NewRecorder()
@@ -75,6 +191,9 @@ func TestAPI_ShortenHandler(t *testing.T) {
 				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
 			}
 
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Fatalf("unexpected content type, expected application/json, got %s", contentType)
+			}
 			respBody := w.Body.String()
 			if respBody != tt.respBody {
 				t.Fatalf("unexpected body, expected %s, got %s", tt.respBody, respBody)
@@ -88,18 +207,62 @@ func TestAPI_RedirectHandler(t *testing.T) {
 		name         string
 		method       string
 		reqURL       string
-		storePairs   map[string]string
+		storePairs   map[string]store.Record
+		opts         []Option
 		respStatus   int
 		respLocation string
+		respAllow    string
 	}{
 		{
 			name:         "success_shorten",
 			method:       http.MethodGet,
 			reqURL:       "/123456",
-			storePairs:   map[string]string{"123456": "https://jemgunay.co.uk"},
+			storePairs:   map[string]store.Record{"123456": {URL: "https://jemgunay.co.uk"}},
+			respStatus:   http.StatusMovedPermanently,
+			respLocation: "https://jemgunay.co.uk",
+		},
+		{
+			name:         "success_head",
+			method:       http.MethodHead,
+			reqURL:       "/123456",
+			storePairs:   map[string]store.Record{"123456": {URL: "https://jemgunay.co.uk"}},
 			respStatus:   http.StatusMovedPermanently,
 			respLocation: "https://jemgunay.co.uk",
 		},
+		{
+			name:         "success_link_redirect_status",
+			method:       http.MethodGet,
+			reqURL:       "/123456",
+			storePairs:   map[string]store.Record{"123456": {URL: "https://jemgunay.co.uk", RedirectStatus: http.StatusTemporaryRedirect}},
+			opts:         []Option{WithDefaultRedirectStatus(http.StatusFound)},
+			respStatus:   http.StatusTemporaryRedirect,
+			respLocation: "https://jemgunay.co.uk",
+		},
+		{
+			name:         "success_default_redirect_status",
+			method:       http.MethodGet,
+			reqURL:       "/123456",
+			storePairs:   map[string]store.Record{"123456": {URL: "https://jemgunay.co.uk"}},
+			opts:         []Option{WithDefaultRedirectStatus(http.StatusFound)},
+			respStatus:   http.StatusFound,
+			respLocation: "https://jemgunay.co.uk",
+		},
+		{
+			name:         "success_not_yet_expired",
+			method:       http.MethodGet,
+			reqURL:       "/123456",
+			storePairs:   map[string]store.Record{"123456": {URL: "https://jemgunay.co.uk", ExpiresAt: time.Now().Add(time.Hour)}},
+			respStatus:   http.StatusMovedPermanently,
+			respLocation: "https://jemgunay.co.uk",
+		},
+		{
+			name:         "expired",
+			method:       http.MethodGet,
+			reqURL:       "/123456",
+			storePairs:   map[string]store.Record{"123456": {URL: "https://jemgunay.co.uk", ExpiresAt: time.Now().Add(-time.Hour)}},
+			respStatus:   http.StatusGone,
+			respLocation: "",
+		},
 		{
 			name:         "invalid_method",
 			method:       http.MethodPost,
@@ -107,6 +270,23 @@ func TestAPI_RedirectHandler(t *testing.T) {
 			storePairs:   nil,
 			respStatus:   http.StatusMethodNotAllowed,
 			respLocation: "",
+			respAllow:    "GET, HEAD",
+		},
+		{
+			name:         "reserved_path",
+			method:       http.MethodGet,
+			reqURL:       "/favicon.ico",
+			storePairs:   map[string]store.Record{"favicon.ico": {URL: "https://jemgunay.co.uk"}},
+			respStatus:   http.StatusNotFound,
+			respLocation: "",
+		},
+		{
+			name:         "nested_path",
+			method:       http.MethodGet,
+			reqURL:       "/api/v1/123456",
+			storePairs:   map[string]store.Record{"123456": {URL: "https://jemgunay.co.uk"}},
+			respStatus:   http.StatusNotFound,
+			respLocation: "",
 		},
 		{
 			name:         "hash_not_found",
@@ -125,13 +305,13 @@ func TestAPI_RedirectHandler(t *testing.T) {
 			for k, v := range tt.storePairs {
 				storeStub.Set(k, v)
 			}
-			handlers := New(nil, storeStub)
+			handlers := New(nil, store.WithContext(storeStub), tt.opts...)
 
 			// configure the request and response writer
 			w := httptest.NewRecorder()
 			r := httptest.NewRequest(tt.method, tt.reqURL, nil)
 
-			handlers.RedirectHandler(w, r)
+			serveRoute("/{hash}", handlers.RedirectHandler, w, r)
 
 			if w.Code != tt.respStatus {
 				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
@@ -141,6 +321,511 @@ func TestAPI_RedirectHandler(t *testing.T) {
 			if locationHeader != tt.respLocation {
 				t.Fatalf("unexpected location header, expected %s, got %s", tt.respLocation, locationHeader)
 			}
+			if allow := w.Header().Get("Allow"); allow != tt.respAllow {
+				t.Fatalf("unexpected allow header, expected %q, got %q", tt.respAllow, allow)
+			}
+		})
+	}
+}
+
+// serveRoute serves a request with a router on which the handler is registered against the given pattern, as in
+// cmd/server, so that the handler can read the pattern's wildcards.
+func serveRoute(pattern string, handler http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, handler)
+	mux.ServeHTTP(w, r)
+}
+
+// failingStorage is a store.Storage whose lookups fail.
+type failingStorage struct {
+	store.Storage
+}
+
+// Get returns an error.
+func (failingStorage) Get(string) (store.Record, error) {
+	return store.Record{}, errors.New("storage unavailable")
+}
+
+func TestAPI_RedirectHandler_StoreError(t *testing.T) {
+	handlers := New(nil, store.WithContext(failingStorage{store.New()}))
+	handler := handlers.RequestID(handlers.RedirectHandler)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/123456", nil)
+	r.Header.Set(requestIDHeader, "req-1")
+	serveRoute("/{hash}", handler, w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("unexpected content type, expected application/json, got %s", contentType)
+	}
+	const expected = `{"error":{"code":"internal_error","message":"internal server error","request_id":"req-1"}}`
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected body, expected %s, got %s", expected, w.Body.String())
+	}
+}
+
+// blockingStorage is a store.ContextStorage whose lookups block until their context is done, as a slow remote backend
+// would.
+type blockingStorage struct {
+	store.ContextStorage
+}
+
+// GetContext blocks until the context is done, then returns its error.
+func (blockingStorage) GetContext(ctx context.Context, _ string) (store.Record, error) {
+	<-ctx.Done()
+	return store.Record{}, ctx.Err()
+}
+
+func TestAPI_RedirectHandler_StoreContext(t *testing.T) {
+	tests := []struct {
+		name       string
+		cancel     bool
+		respStatus int
+		respBody   string
+	}{
+		{
+			name:       "storage_timeout",
+			respStatus: http.StatusServiceUnavailable,
+			respBody:   `{"error":{"code":"timeout","message":"request timed out, try again"}}`,
+		},
+		{
+			name:       "client_disconnected",
+			cancel:     true,
+			respStatus: statusClientClosedRequest,
+			respBody:   `{"error":{"code":"cancelled","message":"request was cancelled"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := blockingStorage{store.WithContext(store.New())}
+			timeout := time.Hour
+			if !tt.cancel {
+				timeout = time.Millisecond * 10
+			}
+			handlers := New(nil, store.WithTimeout(storage, timeout))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/123456", nil).WithContext(ctx)
+			serveRoute("/{hash}", handlers.RedirectHandler, w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+			if w.Body.String() != tt.respBody {
+				t.Fatalf("unexpected body, expected %s, got %s", tt.respBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPI_ShortenHandler_HashCollision(t *testing.T) {
+	const existingURL = "https://jemgunay.co.uk/existing"
+
+	tests := []struct {
+		name       string
+		hashVals   []string
+		respStatus int
+		respHash   string
+	}{
+		{
+			name:       "success_retry_after_collision",
+			hashVals:   []string{"123456", "123456", "abcdef"},
+			respStatus: http.StatusOK,
+			respHash:   "abcdef",
+		},
+		{
+			name:       "attempts_exhausted",
+			hashVals:   []string{"123456"},
+			respStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the stubbed hasher collides with an existing link before (possibly) producing a unique hash
+			storeStub := store.New()
+			storeStub.Set("123456", store.Record{URL: existingURL})
+			handlers := New(hashstub.NewSequence(tt.hashVals...), store.WithContext(storeStub))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/shorten", bytes.NewBufferString(`{"original_url": "https://jemgunay.co.uk"}`))
+			r.URL.Host = "localhost:8080"
+
+			handlers.ShortenHandler(w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+
+			// the existing link must never be overwritten
+			stored, err := storeStub.Get("123456")
+			if err != nil {
+				t.Fatalf("failed to get existing link: %s", err)
+			}
+			if stored.URL != existingURL {
+				t.Fatalf("existing link was overwritten, expected %s, got %s", existingURL, stored.URL)
+			}
+
+			if tt.respHash == "" {
+				return
+			}
+			stored, err = storeStub.Get(tt.respHash)
+			if err != nil {
+				t.Fatalf("failed to get new link: %s", err)
+			}
+			if stored.URL != "https://jemgunay.co.uk" {
+				t.Fatalf("unexpected new link, expected %s, got %s", "https://jemgunay.co.uk", stored.URL)
+			}
+		})
+	}
+}
+
+func TestAPI_StatsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		reqURL     string
+		clicks     int
+		respStatus int
+		respClicks uint64
+	}{
+		{
+			name:       "success_clicked",
+			method:     http.MethodGet,
+			reqURL:     "/api/v1/links/123456/stats",
+			clicks:     3,
+			respStatus: http.StatusOK,
+			respClicks: 3,
+		},
+		{
+			name:       "success_not_clicked",
+			method:     http.MethodGet,
+			reqURL:     "/api/v1/links/123456/stats",
+			respStatus: http.StatusOK,
+		},
+		{
+			name:       "hash_not_found",
+			method:     http.MethodGet,
+			reqURL:     "/api/v1/links/abcdef/stats",
+			respStatus: http.StatusNotFound,
+		},
+		{
+			name:       "invalid_method",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/links/123456/stats",
+			respStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeStub := store.New()
+			storeStub.Set("123456", store.Record{URL: "https://jemgunay.co.uk"})
+			recorder := analytics.NewRecorder(16, time.Hour)
+			handlers := New(nil, store.WithContext(storeStub), WithRecorder(recorder))
+
+			// each redirect should record a click
+			for i := 0; i < tt.clicks; i++ {
+				serveRoute("/{hash}", handlers.RedirectHandler, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/123456", nil))
+			}
+			// close the recorder to flush buffered clicks
+			recorder.Close()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.reqURL, nil)
+
+			serveRoute("/api/v1/links/{hash}/stats", handlers.StatsHandler, w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+			if tt.respStatus != http.StatusOK {
+				return
+			}
+
+			stats := analytics.Stats{}
+			if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+				t.Fatalf("failed to JSON unmarshal response body: %s", err)
+			}
+			if stats.Hash != "123456" || stats.Clicks != tt.respClicks {
+				t.Fatalf("unexpected stats: %+v", stats)
+			}
 		})
 	}
 }
+
+func TestAPI_StatsHandler_RecreatedLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(handlers API, storage store.Store) error
+	}{
+		{
+			name: "deleted",
+			remove: func(handlers API, storage store.Store) error {
+				w := httptest.NewRecorder()
+				serveRoute("/api/v1/links/{hash}", handlers.LinkHandler, w, httptest.NewRequest(http.MethodDelete, "/api/v1/links/blog", nil))
+				if w.Code != http.StatusNoContent {
+					return fmt.Errorf("unexpected delete status %d", w.Code)
+				}
+				return nil
+			},
+		},
+		{
+			// i.e. an expired link removed by the reaper, which does not reset the stats itself
+			name: "reaped",
+			remove: func(handlers API, storage store.Store) error {
+				return storage.Delete("blog")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := store.New()
+			recorder := analytics.NewRecorder(16, time.Hour)
+			defer recorder.Close()
+			handlers := New(nil, store.WithContext(storage), WithRecorder(recorder))
+
+			shorten := func() {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodPost, "/api/v1/shorten", bytes.NewBufferString(`{"original_url": "https://jemgunay.co.uk", "custom_alias": "blog"}`))
+				handlers.ShortenHandler(w, r)
+				if w.Code != http.StatusOK {
+					t.Fatalf("unexpected shorten status, expected %d, got %d", http.StatusOK, w.Code)
+				}
+			}
+
+			shorten()
+			for i := 0; i < 2; i++ {
+				serveRoute("/{hash}", handlers.RedirectHandler, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/blog", nil))
+			}
+			// clicks are aggregated asynchronously
+			for deadline := time.Now().Add(time.Second * 5); recorder.Stats("blog").Clicks != 2; time.Sleep(time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("timed out waiting for clicks to be recorded")
+				}
+			}
+
+			if err := tt.remove(handlers, storage); err != nil {
+				t.Fatalf("failed to remove link: %s", err)
+			}
+			shorten()
+
+			w := httptest.NewRecorder()
+			serveRoute("/api/v1/links/{hash}/stats", handlers.StatsHandler, w, httptest.NewRequest(http.MethodGet, "/api/v1/links/blog/stats", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, w.Code)
+			}
+			stats := analytics.Stats{}
+			if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+				t.Fatalf("failed to JSON unmarshal response body: %s", err)
+			}
+			if stats.Clicks != 0 || stats.FirstClick != nil {
+				t.Fatalf("expected recreated link to have no clicks, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestAPI_StatsReaper(t *testing.T) {
+	now := time.Now()
+	storage := store.New()
+	records := map[string]store.Record{
+		"live":    {URL: "https://jemgunay.co.uk"},
+		"expired": {URL: "https://jemgunay.co.uk", ExpiresAt: now.Add(-time.Minute)},
+	}
+	for key, record := range records {
+		if err := storage.Set(key, record); err != nil {
+			t.Fatalf("failed to store record: %s", err)
+		}
+	}
+
+	recorder := analytics.NewRecorder(16, time.Hour)
+	// "deleted" has clicks but no longer exists, i.e. it was deleted from another replica
+	for _, key := range []string{"live", "expired", "deleted"} {
+		recorder.Record(analytics.Click{Hash: key, Time: now})
+	}
+	recorder.Close()
+
+	reaper := New(nil, store.WithContext(storage), WithRecorder(recorder)).StatsReaper(storage)
+	reaped, err := reaper.Reap(now)
+	if err != nil {
+		t.Fatalf("failed to reap: %s", err)
+	}
+	if reaped != 1 {
+		t.Fatalf("unexpected reap count, expected 1, got %d", reaped)
+	}
+
+	expectedClicks := map[string]uint64{"live": 1, "expired": 0, "deleted": 0}
+	for key, clicks := range expectedClicks {
+		if stats := recorder.Stats(key); stats.Clicks != clicks {
+			t.Fatalf("unexpected clicks for %s, expected %d, got %d", key, clicks, stats.Clicks)
+		}
+	}
+}
+
+func TestAPI_ShortenHandler_Dedup(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		reqBodies []string
+		// owners are the owners of the callers of each request, which are anonymous if unset
+		owners     []string
+		respHashes []string
+	}{
+		{
+			name:       "dedup_disabled",
+			reqBodies:  []string{`{"original_url": "https://jemgunay.co.uk"}`, `{"original_url": "https://jemgunay.co.uk"}`},
+			respHashes: []string{"123456", "abcdef"},
+		},
+		{
+			name:       "dedup_enabled",
+			opts:       []Option{WithDedup()},
+			reqBodies:  []string{`{"original_url": "https://jemgunay.co.uk"}`, `{"original_url": "https://jemgunay.co.uk"}`},
+			respHashes: []string{"123456", "123456"},
+		},
+		{
+			name:       "dedup_enabled_distinct_urls",
+			opts:       []Option{WithDedup()},
+			reqBodies:  []string{`{"original_url": "https://jemgunay.co.uk"}`, `{"original_url": "https://jemgunay.co.uk/blog"}`},
+			respHashes: []string{"123456", "abcdef"},
+		},
+		{
+			name:       "dedup_enabled_ignores_expiring_links",
+			opts:       []Option{WithDedup()},
+			reqBodies:  []string{`{"original_url": "https://jemgunay.co.uk", "ttl": "1h"}`, `{"original_url": "https://jemgunay.co.uk"}`},
+			respHashes: []string{"123456", "abcdef"},
+		},
+		{
+			name: "dedup_enabled_alternating_owners",
+			opts: []Option{WithDedup()},
+			reqBodies: []string{
+				`{"original_url": "https://jemgunay.co.uk"}`,
+				`{"original_url": "https://jemgunay.co.uk"}`,
+				`{"original_url": "https://jemgunay.co.uk"}`,
+				`{"original_url": "https://jemgunay.co.uk"}`,
+				`{"original_url": "https://jemgunay.co.uk"}`,
+			},
+			owners:     []string{"alice", "bob", "alice", "bob", "alice"},
+			respHashes: []string{"123456", "abcdef", "123456", "abcdef", "123456"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers := New(hashstub.NewSequence("123456", "abcdef"), store.WithContext(store.New()), tt.opts...)
+
+			for i, reqBody := range tt.reqBodies {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodPost, "/api/v1/shorten", bytes.NewBufferString(reqBody))
+				r.URL.Host = "localhost:8080"
+				if tt.owners != nil {
+					caller := APIKey{ID: tt.owners[i], Owner: tt.owners[i]}
+					r = r.WithContext(context.WithValue(r.Context(), callerContextKey{}, caller))
+				}
+
+				handlers.ShortenHandler(w, r)
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, w.Code)
+				}
+				resp := shortenResponse{}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to JSON unmarshal response body: %s", err)
+				}
+				if resp.ShortHash != tt.respHashes[i] {
+					t.Fatalf("unexpected hash for request %d, expected %s, got %s", i, tt.respHashes[i], resp.ShortHash)
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_ShortenHandler_IdempotencyKey(t *testing.T) {
+	storeStub := store.New()
+	handlers := New(hashstub.NewSequence("123456", "abcdef"), store.WithContext(storeStub))
+
+	shorten := func(key, reqBody string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/shorten", bytes.NewBufferString(reqBody))
+		r.URL.Host = "localhost:8080"
+		r.Header.Set(idempotencyKeyHeader, key)
+		handlers.ShortenHandler(w, r)
+		return w
+	}
+
+	first := shorten("retry-key", `{"original_url": "https://jemgunay.co.uk"}`)
+	if first.Code != http.StatusOK {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, first.Code)
+	}
+
+	// a retry with the same key must replay the original response rather than create a second link
+	retry := shorten("retry-key", `{"original_url": "https://jemgunay.co.uk"}`)
+	if retry.Code != http.StatusOK {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, retry.Code)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Fatalf("unexpected replayed body, expected %s, got %s", first.Body.String(), retry.Body.String())
+	}
+	if retry.Header().Get(idempotencyReplayedHeader) != "true" {
+		t.Fatal("expected replayed response to be marked as replayed")
+	}
+	if _, err := storeStub.Get("abcdef"); err != store.ErrKeyNotFound {
+		t.Fatalf("expected retry not to create a link, got %v", err)
+	}
+
+	// reusing the key for a different request is rejected
+	mismatch := shorten("retry-key", `{"original_url": "https://jemgunay.co.uk/blog"}`)
+	if mismatch.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusUnprocessableEntity, mismatch.Code)
+	}
+
+	// a different key creates a new link
+	other := shorten("other-key", `{"original_url": "https://jemgunay.co.uk"}`)
+	if other.Code != http.StatusOK || other.Body.String() == first.Body.String() {
+		t.Fatalf("expected a new link for a different key, got %d: %s", other.Code, other.Body.String())
+	}
+
+	// bodies which are too large to fingerprint are rejected
+	tooLarge := shorten("large-key", `{"original_url": "https://jemgunay.co.uk", "alias": "`+strings.Repeat("a", maxIdempotentRequestBytes)+`"}`)
+	if tooLarge.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusRequestEntityTooLarge, tooLarge.Code)
+	}
+}
+
+func TestAPI_ShortenHandler_IdempotencyKeyCancelled(t *testing.T) {
+	handlers := New(hashstub.NewSequence("123456"), store.WithContext(store.New()))
+
+	shorten := func(ctx context.Context) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/shorten", bytes.NewBufferString(`{"original_url": "https://jemgunay.co.uk"}`))
+		r = r.WithContext(ctx)
+		r.Header.Set(idempotencyKeyHeader, "retry-key")
+		handlers.ShortenHandler(w, r)
+		return w
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if first := shorten(cancelled); first.Code != statusClientClosedRequest {
+		t.Fatalf("unexpected status, expected %d, got %d", statusClientClosedRequest, first.Code)
+	}
+
+	// the cancelled attempt is not replayed, so retrying with the same key succeeds
+	retry := shorten(context.Background())
+	if retry.Code != http.StatusOK {
+		t.Fatalf("unexpected status, expected %d, got %d: %s", http.StatusOK, retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get(idempotencyReplayedHeader) != "" {
+		t.Fatal("expected retry not to be replayed")
+	}
+}
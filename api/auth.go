@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/jemgunay/url-shortener/store"
+)
+
+// callerContextKey is the request context key of the authenticated APIKey.
+type callerContextKey struct{}
+
+// callerFromContext returns the authenticated APIKey of a request, and false if the request was not authenticated.
+func callerFromContext(ctx context.Context) (APIKey, bool) {
+	caller, ok := ctx.Value(callerContextKey{}).(APIKey)
+	return caller, ok
+}
+
+// bearerToken extracts the token from a request's "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) string {
+	const prefix = "bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// Authenticate requires requests to carry a valid API key as a bearer token, responding with a 401 Unauthorized
+// otherwise. The authenticated key is made available to the wrapped handler. If authentication is not enabled, requests
+// are passed through unchanged.
+func (a API) Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	if a.keys == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			a.log(r).Debug("request is missing a bearer token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="url-shortener"`)
+			a.writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "an API key is required")
+			return
+		}
+
+		caller, ok := a.keys.Authenticate(token)
+		if !ok {
+			a.log(r).Warn("request has an invalid bearer token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="url-shortener", error="invalid_token"`)
+			a.writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "invalid API key")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), callerContextKey{}, caller)))
+	}
+}
+
+// AuthenticateAdmin requires requests to carry a valid admin API key, responding with a 401 Unauthorized if the key is
+// missing or invalid and a 403 Forbidden if it is not an admin key. If authentication is not enabled, requests are
+// rejected with a 404 Not Found as there are no keys to administer.
+func (a API) AuthenticateAdmin(next http.HandlerFunc) http.HandlerFunc {
+	if a.keys == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			a.writeNotFound(w, r, "API key authentication is not enabled")
+		}
+	}
+
+	return a.Authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if caller, _ := callerFromContext(r.Context()); !caller.Admin {
+			a.log(r).Warn("API key is not an admin key", "key_id", caller.ID)
+			a.writeError(w, r, http.StatusForbidden, codeForbidden, "an admin API key is required")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireAdmin requires requests to carry a valid admin API key, as AuthenticateAdmin does. Unlike AuthenticateAdmin,
+// requests are passed through unchanged if authentication is not enabled, as every request may then manage every link.
+func (a API) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	if a.keys == nil {
+		return next
+	}
+	return a.AuthenticateAdmin(next)
+}
+
+// owner returns the owner to attribute links created by a request to. It is empty if authentication is not enabled.
+func owner(r *http.Request) string {
+	caller, _ := callerFromContext(r.Context())
+	return caller.Owner
+}
+
+// canManage determines if the request's caller may view or modify the given link. Admins may manage every link, while
+// other keys may only manage the links they own. Every request may manage every link if authentication is not enabled.
+func (a API) canManage(r *http.Request, record store.Record) bool {
+	if a.keys == nil {
+		return true
+	}
+	caller, ok := callerFromContext(r.Context())
+	return ok && (caller.Admin || caller.Owner == record.Owner)
+}
+
+// createKeyPayload is the payload expected when creating an API key.
+type createKeyPayload struct {
+	Owner string `json:"owner"`
+	Admin bool   `json:"admin"`
+}
+
+// createKeyResponse is the payload returned when creating an API key. It is the only time the plaintext key is
+// revealed.
+type createKeyResponse struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	Admin bool   `json:"admin"`
+	Key   string `json:"key"`
+}
+
+// keyResponse is the representation of a stored API key; it never includes the key or its hash.
+type keyResponse struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	Admin bool   `json:"admin"`
+}
+
+// KeysHandler manages API keys. POST "/api/v1/admin/keys" creates a key and returns it in plaintext, GET
+// "/api/v1/admin/keys" lists keys and DELETE "/api/v1/admin/keys/{id}" revokes a key. It must be wrapped with
+// AuthenticateAdmin.
+func (a API) KeysHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		a.createKey(w, r)
+	case id == "" && r.Method == http.MethodGet:
+		keys := a.keys.List()
+		respBody := make([]keyResponse, 0, len(keys))
+		for _, key := range keys {
+			respBody = append(respBody, keyResponse{ID: key.ID, Owner: key.Owner, Admin: key.Admin})
+		}
+		a.writeJSON(w, r, http.StatusOK, respBody)
+	case id != "" && r.Method == http.MethodDelete:
+		if err := a.keys.Revoke(id); err != nil {
+			if err == ErrAPIKeyNotFound {
+				a.log(r).Debug("API key not found", "key_id", id)
+				a.writeNotFound(w, r, "API key not found")
+				return
+			}
+			a.log(r).Error("failed to revoke API key", "error", err)
+			a.writeInternalError(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case id == "":
+		a.writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	default:
+		a.writeMethodNotAllowed(w, r, http.MethodDelete)
+	}
+}
+
+// createKey creates an API key from a createKeyPayload.
+func (a API) createKey(w http.ResponseWriter, r *http.Request) {
+	payload := createKeyPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		a.log(r).Debug("failed to JSON unmarshal request payload", "error", err)
+		a.writeError(w, r, http.StatusBadRequest, codeBadRequest, "request payload must be valid JSON")
+		return
+	}
+	if strings.TrimSpace(payload.Owner) == "" {
+		a.writeValidationError(w, r, fieldError{Field: "owner", Reason: "must not be empty"})
+		return
+	}
+
+	key, plaintext, err := a.keys.Create(payload.Owner, payload.Admin)
+	if err != nil {
+		a.log(r).Error("failed to create API key", "error", err)
+		a.writeInternalError(w, r)
+		return
+	}
+
+	a.writeJSON(w, r, http.StatusCreated, createKeyResponse{
+		ID:    key.ID,
+		Owner: key.Owner,
+		Admin: key.Admin,
+		Key:   plaintext,
+	})
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	hashstub "github.com/jemgunay/url-shortener/hash/stub"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestAPI_Authenticate(t *testing.T) {
+	keys := NewKeyStore()
+	_, aliceKey, err := keys.Create("alice", false)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	_, bobKey, err := keys.Create("bob", false)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	_, adminKey, err := keys.Create("admin", true)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+
+	storage := store.New()
+	handlers := New(hashstub.NewSequence("123456", "abcdef"), store.WithContext(storage), WithKeyStore(keys))
+	mux := http.NewServeMux()
+	handlers.Routes(mux, RouteConfig{})
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		r.URL.Host = "localhost:8080"
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	// unauthenticated requests are rejected
+	if w := do(http.MethodPost, "/api/v1/shorten", "", `{"original_url": "https://jemgunay.co.uk"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status for missing key, expected %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := do(http.MethodPost, "/api/v1/shorten", "us_invalid", `{"original_url": "https://jemgunay.co.uk"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status for invalid key, expected %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	// links are attributed to the key's owner
+	if w := do(http.MethodPost, "/api/v1/shorten", aliceKey, `{"original_url": "https://jemgunay.co.uk"}`); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status for shorten, expected %d, got %d", http.StatusOK, w.Code)
+	}
+	if w := do(http.MethodPost, "/api/v1/shorten", bobKey, `{"original_url": "https://jemgunay.co.uk/blog"}`); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status for shorten, expected %d, got %d", http.StatusOK, w.Code)
+	}
+	record, err := storage.Get("123456")
+	if err != nil {
+		t.Fatalf("failed to get stored record: %s", err)
+	}
+	if record.Owner != "alice" {
+		t.Fatalf("unexpected owner, expected alice, got %s", record.Owner)
+	}
+
+	// only the owner or an admin may manage a link
+	tests := []struct {
+		name       string
+		method     string
+		key        string
+		respStatus int
+	}{
+		{name: "owner_get", method: http.MethodGet, key: aliceKey, respStatus: http.StatusOK},
+		{name: "other_get", method: http.MethodGet, key: bobKey, respStatus: http.StatusForbidden},
+		{name: "admin_get", method: http.MethodGet, key: adminKey, respStatus: http.StatusOK},
+		{name: "other_delete", method: http.MethodDelete, key: bobKey, respStatus: http.StatusForbidden},
+		{name: "owner_delete", method: http.MethodDelete, key: aliceKey, respStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(tt.method, "/api/v1/links/123456", tt.key, ""); w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+		})
+	}
+
+	// listing is filtered to the caller's links
+	listTests := []struct {
+		name   string
+		key    string
+		hashes []string
+	}{
+		{name: "owner_list", key: bobKey, hashes: []string{"abcdef"}},
+		{name: "other_list", key: aliceKey, hashes: []string{}},
+		{name: "admin_list", key: adminKey, hashes: []string{"abcdef"}},
+	}
+	for _, tt := range listTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(http.MethodGet, "/api/v1/links?limit=1", tt.key, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, w.Code)
+			}
+			resp := listResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to JSON unmarshal response body: %s", err)
+			}
+			if len(resp.Links) != len(tt.hashes) {
+				t.Fatalf("unexpected link count, expected %d, got %d", len(tt.hashes), len(resp.Links))
+			}
+			for i, hash := range tt.hashes {
+				if resp.Links[i].ShortHash != hash {
+					t.Fatalf("unexpected hash, expected %s, got %s", hash, resp.Links[i].ShortHash)
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_KeysHandler(t *testing.T) {
+	keys := NewKeyStore()
+	_, adminKey, err := keys.Create("admin", true)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	_, userKey, err := keys.Create("alice", false)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+
+	handlers := New(hashstub.Stub{}, store.WithContext(store.New()), WithKeyStore(keys))
+	mux := http.NewServeMux()
+	handlers.Routes(mux, RouteConfig{})
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		r.Header.Set("Authorization", "Bearer "+key)
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do(http.MethodPost, "/api/v1/admin/keys", userKey, `{"owner": "bob"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status for non-admin key, expected %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := do(http.MethodPost, "/api/v1/admin/keys", adminKey, `{"owner": ""}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected status for empty owner, expected %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	w := do(http.MethodPost, "/api/v1/admin/keys", adminKey, `{"owner": "bob"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status for create, expected %d, got %d", http.StatusCreated, w.Code)
+	}
+	created := createKeyResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to JSON unmarshal response body: %s", err)
+	}
+	if key, ok := keys.Authenticate(created.Key); !ok || key.Owner != "bob" {
+		t.Fatalf("created key does not authenticate as bob")
+	}
+
+	if w := do(http.MethodDelete, "/api/v1/admin/keys/"+created.ID, adminKey, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status for revoke, expected %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if _, ok := keys.Authenticate(created.Key); ok {
+		t.Fatalf("revoked key still authenticates")
+	}
+	if w := do(http.MethodDelete, "/api/v1/admin/keys/"+created.ID, adminKey, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status for repeated revoke, expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestLoadKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.jsonl")
+
+	keys, err := LoadKeyStore(path)
+	if err != nil {
+		t.Fatalf("failed to load missing keys file: %s", err)
+	}
+	adminKey, err := keys.EnsureAdmin()
+	if err != nil || adminKey == "" {
+		t.Fatalf("failed to create admin key: %s", err)
+	}
+	if again, err := keys.EnsureAdmin(); err != nil || again != "" {
+		t.Fatalf("unexpected admin key creation when one exists")
+	}
+
+	// keys are persisted hashed and reloaded
+	reloaded, err := LoadKeyStore(path)
+	if err != nil {
+		t.Fatalf("failed to reload keys file: %s", err)
+	}
+	key, ok := reloaded.Authenticate(adminKey)
+	if !ok || !key.Admin {
+		t.Fatalf("admin key not found after reload")
+	}
+	if key.Hash == adminKey {
+		t.Fatalf("key stored in plaintext")
+	}
+}
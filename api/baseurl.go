@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ParseBaseURL parses the public base URL which short URLs are composed from, i.e. "https://sho.rt" or
+// "https://example.com/s". It must be an absolute http or https URL and may include a path prefix, but not
+// credentials, a query or a fragment.
+func ParseBaseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("base URL scheme must be http or https")
+	}
+	if u.Host == "" {
+		return nil, errors.New("base URL must include a host")
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("base URL must not include credentials, a query or a fragment")
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+// WithBaseURL sets the canonical base URL which short URLs are composed from, as parsed by ParseBaseURL. If it is not
+// set, short URLs are composed from the scheme and host of each request.
+func WithBaseURL(baseURL *url.URL) Option {
+	return func(a *API) {
+		a.baseURL = baseURL
+	}
+}
+
+// WithForwardedHeaders trusts the X-Forwarded-Proto and X-Forwarded-Host headers of requests from trusted proxies (see
+// WithTrustedProxies) when composing short URLs without a configured base URL.
+func WithForwardedHeaders() Option {
+	return func(a *API) {
+		a.forwardedHeaders = true
+	}
+}
+
+// shortURL composes the fully-qualified short URL of a hash on the given domain. If multiple domains are enabled, the
+// domain replaces the host of the base URL.
+func (a API) shortURL(r *http.Request, domain, hashID string) string {
+	base := a.requestBaseURL(r)
+	if domain != "" {
+		withDomain := *base
+		withDomain.Host = domain
+		base = &withDomain
+	}
+	return base.String() + "/" + url.PathEscape(hashID)
+}
+
+// requestBaseURL returns the base URL of short URLs for a request. The configured base URL is used if there is one,
+// otherwise it is the request's origin.
+func (a API) requestBaseURL(r *http.Request) *url.URL {
+	if a.baseURL != nil {
+		return a.baseURL
+	}
+	return a.requestOrigin(r)
+}
+
+// requestOrigin composes the scheme and host which a request was made to. They are taken from the X-Forwarded-Proto
+// and X-Forwarded-Host headers if the request was made by a trusted proxy and forwarded headers are enabled.
+func (a API) requestOrigin(r *http.Request) *url.URL {
+	origin := &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		origin.Scheme = "https"
+	}
+	if r.URL.Host != "" {
+		origin.Host = r.URL.Host
+	}
+	if origin.Host == "" {
+		if srvAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			origin.Host = srvAddr.String()
+		}
+	}
+
+	if a.forwardedHeaders && a.fromTrustedProxy(r) {
+		if proto := strings.ToLower(firstForwardedValue(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+			origin.Scheme = proto
+		}
+		if host := firstForwardedValue(r.Header.Get("X-Forwarded-Host")); validForwardedHost(host) {
+			origin.Host = host
+		}
+	}
+	return origin
+}
+
+// fromTrustedProxy determines if the request was made directly by a trusted proxy.
+func (a API) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && a.trustedProxy(ip)
+}
+
+// firstForwardedValue returns the first of a comma separated list of forwarded header values, which was set by the
+// proxy nearest to the client.
+func firstForwardedValue(header string) string {
+	if i := strings.IndexByte(header, ','); i >= 0 {
+		header = header[:i]
+	}
+	return strings.TrimSpace(header)
+}
+
+// validForwardedHost determines if a forwarded host is a plausible host with an optional port, so that it cannot
+// inject a path, credentials or whitespace into short URLs.
+func validForwardedHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	u, err := url.Parse("http://" + host)
+	return err == nil && u.Host == host && u.User == nil && u.Path == ""
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	// maxBatchItems is the maximum number of items shortened by a single batch request.
+	maxBatchItems = 10000
+	// maxBatchLineBytes is the maximum size of a single line of an NDJSON batch.
+	maxBatchLineBytes = 64 * 1024
+	// defaultBatchWorkers is the default number of batch items shortened concurrently.
+	defaultBatchWorkers = 8
+)
+
+// ndjsonContentTypes are the media types of newline delimited JSON batches. Batches of any other type are read as a
+// JSON array.
+var ndjsonContentTypes = map[string]struct{}{
+	"application/x-ndjson": {},
+	"application/ndjson":   {},
+	"application/jsonl":    {},
+}
+
+// WithBatchWorkers sets the number of items of a batch request which are shortened concurrently. Defaults to 8.
+func WithBatchWorkers(workers int) Option {
+	return func(a *API) {
+		a.batchWorkers = workers
+	}
+}
+
+// batchItem is a single shortenPayload of a batch, yet to be decoded.
+type batchItem struct {
+	index   int
+	payload json.RawMessage
+}
+
+// batchResult is the outcome of shortening a single item of a batch. Exactly one of Link and Error is set.
+type batchResult struct {
+	// Index is the position of the item in the batch, starting from zero. Blank NDJSON lines are not counted.
+	Index int `json:"index"`
+	// Status is the HTTP status code which the item would have been responded with by the ShortenHandler.
+	Status int              `json:"status"`
+	Link   *shortenResponse `json:"link,omitempty"`
+	Error  *apiError        `json:"error,omitempty"`
+}
+
+// batchResponse is the payload returned by the BatchShortenHandler.
+type batchResponse struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []batchResult `json:"results"`
+}
+
+// BatchShortenHandler shortens each shortenPayload of a batch, which is either a JSON array or, if the request's
+// Content-Type is application/x-ndjson, one payload per line. Items are streamed from the request body to a bounded
+// pool of workers, and each is created exactly as the ShortenHandler would. A 200 OK is returned with a result per
+// item, ordered by index, which carries the item's own status and either its link or its error, so that invalid items
+// do not fail the rest of the batch.
+//
+// A batch takes a single token from the caller's rate limit if the handler is wrapped with RateLimit, and is bounded
+// by the transfer timeout rather than the server's read and write timeouts. If the body stops being readable part way
+// through (i.e. it is malformed or exceeds maxBatchItems), the items already read are still shortened and a final
+// result describes why reading stopped. Requests made with an Idempotency-Key header are only processed once per key.
+func (a API) BatchShortenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeMethodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	a.extendDeadlines(w, r, true)
+
+	if r.Header.Get(idempotencyKeyHeader) != "" {
+		a.serveIdempotent(w, r, a.shortenBatch)
+		return
+	}
+	a.shortenBatch(w, r)
+}
+
+// shortenBatch implements the BatchShortenHandler.
+func (a API) shortenBatch(w http.ResponseWriter, r *http.Request) {
+	next, failure := newBatchReader(r)
+	if failure != nil {
+		a.log(r).Debug("failed to read batch", "error", failure.Message)
+		a.writeStatusError(w, r, failure)
+		return
+	}
+
+	var (
+		results   []batchResult
+		resultsMu sync.Mutex
+		wg        sync.WaitGroup
+	)
+	items := make(chan batchItem)
+	workers := a.batchWorkers
+	if workers < 1 {
+		workers = defaultBatchWorkers
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				result := a.shortenBatchItem(r, item)
+				resultsMu.Lock()
+				results = append(results, result)
+				resultsMu.Unlock()
+			}
+		}()
+	}
+
+	// stream items to the workers until the batch ends or can no longer be read
+	var stopped *batchResult
+	for index := 0; ; index++ {
+		payload, failure := next()
+		if failure == nil && payload == nil {
+			break
+		}
+		if failure == nil && index == maxBatchItems {
+			failure = newStatusError(http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("batch exceeds %d items", maxBatchItems))
+		}
+		if failure != nil {
+			a.log(r).Debug("stopped reading batch", "index", index, "error", failure.Message)
+			stopped = &batchResult{Index: index, Status: failure.status, Error: &failure.apiError}
+			break
+		}
+		items <- batchItem{index: index, payload: payload}
+	}
+	close(items)
+	wg.Wait()
+
+	if stopped != nil {
+		results = append(results, *stopped)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Index < results[j].Index
+	})
+
+	respBody := batchResponse{
+		Results: make([]batchResult, 0, len(results)),
+	}
+	for _, result := range results {
+		if result.Error != nil {
+			respBody.Failed++
+		} else {
+			respBody.Succeeded++
+		}
+		respBody.Results = append(respBody.Results, result)
+	}
+	a.log(r).Debug("shortened batch", "succeeded", respBody.Succeeded, "failed", respBody.Failed)
+
+	a.writeJSON(w, r, http.StatusOK, respBody)
+}
+
+// shortenBatchItem decodes and creates the link of a single batch item.
+func (a API) shortenBatchItem(r *http.Request, item batchItem) batchResult {
+	payload := shortenPayload{}
+	if err := json.Unmarshal(item.payload, &payload); err != nil {
+		a.log(r).Debug("failed to JSON unmarshal batch item", "index", item.index, "error", err)
+		return batchResult{
+			Index:  item.index,
+			Status: http.StatusBadRequest,
+			Error:  &apiError{Code: codeBadRequest, Message: "item must be a JSON object"},
+		}
+	}
+
+	link, failure := a.createLink(r, payload)
+	if failure != nil {
+		return batchResult{Index: item.index, Status: failure.status, Error: &failure.apiError}
+	}
+	return batchResult{Index: item.index, Status: http.StatusOK, Link: &link}
+}
+
+// newBatchReader returns a func which reads the next item of a batch request body each time it is called. It returns
+// a nil payload and failure once the batch has been read, or the reason that the body stopped being readable. An error
+// is returned if the body is not a batch at all.
+func newBatchReader(r *http.Request) (func() (json.RawMessage, *statusError), *statusError) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if _, ok := ndjsonContentTypes[mediaType]; ok {
+		return newNDJSONReader(r.Body), nil
+	}
+
+	dec := json.NewDecoder(r.Body)
+	if token, err := dec.Token(); err != nil || token != json.Delim('[') {
+		return nil, newStatusError(http.StatusBadRequest, codeBadRequest, "request payload must be a JSON array")
+	}
+	return func() (json.RawMessage, *statusError) {
+		if !dec.More() {
+			// consume the closing bracket to ensure the array was terminated
+			if _, err := dec.Token(); err != nil {
+				return nil, newStatusError(http.StatusBadRequest, codeBadRequest, "request payload must be a valid JSON array")
+			}
+			return nil, nil
+		}
+		var payload json.RawMessage
+		if err := dec.Decode(&payload); err != nil {
+			return nil, newStatusError(http.StatusBadRequest, codeBadRequest, "request payload must be a valid JSON array")
+		}
+		return payload, nil
+	}, nil
+}
+
+// newNDJSONReader returns a func which reads the next non-blank line of a newline delimited JSON body each time it is
+// called. Lines are not decoded, so a malformed line only fails its own item.
+func newNDJSONReader(body io.Reader) func() (json.RawMessage, *statusError) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 4096), maxBatchLineBytes)
+
+	return func() (json.RawMessage, *statusError) {
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			// copy the line as the scanner reuses its buffer
+			return append(json.RawMessage(nil), line...), nil
+		}
+		if err := scanner.Err(); err != nil {
+			if err == bufio.ErrTooLong {
+				return nil, newStatusError(http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("batch lines must not exceed %d bytes", maxBatchLineBytes))
+			}
+			return nil, newStatusError(http.StatusBadRequest, codeBadRequest, "failed to read request payload")
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/url-shortener/hash"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestAPI_BatchShortenHandler(t *testing.T) {
+	type itemResult struct {
+		status int
+		code   string
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		reqBody     string
+		respStatus  int
+		results     []itemResult
+	}{
+		{
+			name:       "success_json_array",
+			method:     http.MethodPost,
+			reqBody:    `[{"original_url": "https://jemgunay.co.uk"}, {"original_url": "https://jemgunay.co.uk/blog", "custom_alias": "blog"}]`,
+			respStatus: http.StatusOK,
+			results:    []itemResult{{status: http.StatusOK}, {status: http.StatusOK}},
+		},
+		{
+			name:       "partial_failure_json_array",
+			method:     http.MethodPost,
+			reqBody:    `[{"original_url": "javascript:alert(1)"}, {"original_url": "https://jemgunay.co.uk"}, "not an object", {"original_url": "https://jemgunay.co.uk", "custom_alias": "taken"}]`,
+			respStatus: http.StatusOK,
+			results: []itemResult{
+				{status: http.StatusUnprocessableEntity, code: codeValidationFailed},
+				{status: http.StatusOK},
+				{status: http.StatusBadRequest, code: codeBadRequest},
+				{status: http.StatusConflict, code: codeConflict},
+			},
+		},
+		{
+			name:        "partial_failure_ndjson",
+			method:      http.MethodPost,
+			contentType: "application/x-ndjson; charset=utf-8",
+			reqBody:     "{\"original_url\": \"https://jemgunay.co.uk\"}\n\n{\"original_url\": \n{\"original_url\": \"https://jemgunay.co.uk\", \"ttl\": \"-1h\"}\n",
+			respStatus:  http.StatusOK,
+			results: []itemResult{
+				{status: http.StatusOK},
+				{status: http.StatusBadRequest, code: codeBadRequest},
+				{status: http.StatusBadRequest, code: codeBadRequest},
+			},
+		},
+		{
+			name:       "truncated_json_array",
+			method:     http.MethodPost,
+			reqBody:    `[{"original_url": "https://jemgunay.co.uk"}, {"original_url": `,
+			respStatus: http.StatusOK,
+			results:    []itemResult{{status: http.StatusOK}, {status: http.StatusBadRequest, code: codeBadRequest}},
+		},
+		{
+			name:       "empty_batch",
+			method:     http.MethodPost,
+			reqBody:    `[]`,
+			respStatus: http.StatusOK,
+			results:    []itemResult{},
+		},
+		{
+			name:       "not_an_array",
+			method:     http.MethodPost,
+			reqBody:    `{"original_url": "https://jemgunay.co.uk"}`,
+			respStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid_method",
+			method:     http.MethodGet,
+			respStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher, err := hash.NewRandom(8)
+			if err != nil {
+				t.Fatalf("failed to create hasher: %s", err)
+			}
+			storeStub := store.New()
+			storeStub.Set("taken", store.Record{URL: "https://jemgunay.co.uk/taken"})
+			handlers := New(hash.WithContext(hasher), store.WithContext(storeStub), WithBatchWorkers(2))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/api/v1/shorten/batch", strings.NewReader(tt.reqBody))
+			r.URL.Host = "localhost:8080"
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			handlers.BatchShortenHandler(w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d: %s", tt.respStatus, w.Code, w.Body)
+			}
+			if tt.results == nil {
+				return
+			}
+
+			resp := batchResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to JSON unmarshal response body: %s", err)
+			}
+			if len(resp.Results) != len(tt.results) {
+				t.Fatalf("unexpected result count, expected %d, got %d: %s", len(tt.results), len(resp.Results), w.Body)
+			}
+
+			var succeeded int
+			for i, expected := range tt.results {
+				result := resp.Results[i]
+				if result.Index != i || result.Status != expected.status {
+					t.Fatalf("unexpected result %d, expected status %d, got %+v", i, expected.status, result)
+				}
+				if expected.code == "" {
+					succeeded++
+					if result.Link == nil || result.Error != nil {
+						t.Fatalf("expected result %d to be a link, got %+v", i, result)
+					}
+					// each link is stored
+					if _, err := storeStub.Get(result.Link.ShortHash); err != nil {
+						t.Fatalf("failed to get stored link of result %d: %s", i, err)
+					}
+					continue
+				}
+				if result.Error == nil || result.Error.Code != expected.code || result.Link != nil {
+					t.Fatalf("expected result %d to be a %s error, got %+v", i, expected.code, result)
+				}
+			}
+			if resp.Succeeded != succeeded || resp.Failed != len(tt.results)-succeeded {
+				t.Fatalf("unexpected counts, expected %d succeeded, got %d succeeded and %d failed", succeeded, resp.Succeeded, resp.Failed)
+			}
+		})
+	}
+}
+
+func TestAPI_BatchShortenHandler_TooManyItems(t *testing.T) {
+	hasher, err := hash.NewRandom(8)
+	if err != nil {
+		t.Fatalf("failed to create hasher: %s", err)
+	}
+	handlers := New(hash.WithContext(hasher), store.WithContext(store.New()))
+
+	reqBody := strings.Repeat("{\"original_url\": \"https://jemgunay.co.uk\"}\n", maxBatchItems+5)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/shorten/batch", strings.NewReader(reqBody))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+
+	handlers.BatchShortenHandler(w, r)
+
+	resp := batchResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to JSON unmarshal response body: %s", err)
+	}
+	// items up to the limit are shortened, followed by a result describing why the rest were not
+	if resp.Succeeded != maxBatchItems || resp.Failed != 1 || len(resp.Results) != maxBatchItems+1 {
+		t.Fatalf("unexpected counts, got %d succeeded and %d failed", resp.Succeeded, resp.Failed)
+	}
+	if last := resp.Results[maxBatchItems]; last.Status != http.StatusRequestEntityTooLarge || last.Error.Code != codeTooLarge {
+		t.Fatalf("unexpected final result: %+v", last)
+	}
+}
+
+func TestAPI_BatchShortenHandler_RateLimit(t *testing.T) {
+	hasher, err := hash.NewRandom(8)
+	if err != nil {
+		t.Fatalf("failed to create hasher: %s", err)
+	}
+	storage := store.New()
+	handlers := New(hash.WithContext(hasher), store.WithContext(storage))
+	limiter := NewRateLimiter(RateLimit{Rate: 0.001, Burst: 2}, time.Minute)
+	handler := handlers.RateLimit(limiter, handlers.BatchShortenHandler)
+
+	reqBody := strings.Repeat("{\"original_url\": \"https://jemgunay.co.uk\"}\n", 5)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/shorten/batch", strings.NewReader(reqBody))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	handler(w, r)
+
+	resp := batchResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to JSON unmarshal response body: %s", err)
+	}
+	// the batch takes a single token, so every item is shortened despite the batch exceeding the burst
+	if resp.Succeeded != 5 || resp.Failed != 0 {
+		t.Fatalf("unexpected counts, got %d succeeded and %d failed", resp.Succeeded, resp.Failed)
+	}
+	if size, _ := storage.Len(); size != 5 {
+		t.Fatalf("unexpected number of stored links, expected 5, got %d", size)
+	}
+
+	// the second batch takes the remaining token
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/shorten/batch", strings.NewReader(reqBody))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// the exhausted limit rejects the next batch outright
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/shorten/batch", strings.NewReader(reqBody))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	handler(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+}
+
+func TestAPI_BatchShortenHandler_ServerTimeouts(t *testing.T) {
+	hasher, err := hash.NewRandom(8)
+	if err != nil {
+		t.Fatalf("failed to create hasher: %s", err)
+	}
+	mux := http.NewServeMux()
+	New(hash.WithContext(hasher), store.WithContext(store.New())).Routes(mux, RouteConfig{})
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.ReadTimeout = time.Millisecond * 150
+	server.Config.WriteTimeout = time.Millisecond * 150
+	server.Start()
+	defer server.Close()
+
+	// stream the batch body slower than the read timeout
+	reqBody, reqWriter := io.Pipe()
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(time.Millisecond * 100)
+			fmt.Fprintln(reqWriter, `{"original_url": "https://jemgunay.co.uk"}`)
+		}
+		reqWriter.Close()
+	}()
+	resp, err := http.Post(server.URL+"/api/v1/shorten/batch", "application/x-ndjson", reqBody)
+	if err != nil {
+		t.Fatalf("failed to shorten batch: %s", err)
+	}
+	defer resp.Body.Close()
+	batchResp := batchResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&batchResp); err != nil {
+		t.Fatalf("failed to JSON decode batch response: %s", err)
+	}
+	if batchResp.Succeeded != 3 || batchResp.Failed != 0 {
+		t.Fatalf("unexpected counts, got %d succeeded and %d failed", batchResp.Succeeded, batchResp.Failed)
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseDomains parses a comma separated list of the domains which short links are served from, i.e.
+// "go.example, mk.example". Domains are hosts with an optional port and are lower cased. The first domain is the
+// default for shortens which do not request one.
+func ParseDomains(raw string) ([]string, error) {
+	var domains []string
+	seen := make(map[string]struct{})
+	for _, domain := range strings.Split(raw, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if !validForwardedHost(domain) {
+			return nil, fmt.Errorf("invalid domain %q", domain)
+		}
+		if _, ok := seen[domain]; ok {
+			return nil, fmt.Errorf("duplicate domain %q", domain)
+		}
+		seen[domain] = struct{}{}
+		domains = append(domains, domain)
+	}
+	if len(domains) == 0 {
+		return nil, errors.New("at least one domain is required")
+	}
+	return domains, nil
+}
+
+// WithDomains serves links from multiple domains, as parsed by ParseDomains. Links are namespaced per domain so that
+// the same hash can exist on each of them: shortens target one of the domains (the first by default) and redirects
+// resolve hashes within the namespace of the request's host. Requests for any other host are not redirected.
+func WithDomains(domains []string) Option {
+	return func(a *API) {
+		a.domains = domains
+	}
+}
+
+// storageKey returns the key which the link for a hash is stored against in the given domain's namespace. Domains
+// cannot contain a forward slash, so the domain and hash can always be recovered with splitStorageKey. Hashes are
+// stored as is if multiple domains are not enabled.
+func storageKey(domain, hashID string) string {
+	if domain == "" {
+		return hashID
+	}
+	return domain + "/" + hashID
+}
+
+// splitStorageKey returns the domain and hash of a storage key created by storageKey.
+func splitStorageKey(key string) (domain, hashID string) {
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// defaultDomain returns the domain which links are created on if a shorten does not request one. It is empty if
+// multiple domains are not enabled.
+func (a API) defaultDomain() string {
+	if len(a.domains) == 0 {
+		return ""
+	}
+	return a.domains[0]
+}
+
+// resolveDomain returns the allowed domain matching the requested one, or the default domain if none was requested.
+func (a API) resolveDomain(requested string) (string, bool) {
+	if requested == "" {
+		return a.defaultDomain(), true
+	}
+	requested = strings.ToLower(requested)
+	for _, domain := range a.domains {
+		if domain == requested {
+			return domain, true
+		}
+	}
+	return "", false
+}
+
+// hostDomain returns the domain namespace of the host which a request was made to. A host with a port matches a
+// domain configured without one. False is returned if the host is not one of the allowed domains.
+func (a API) hostDomain(r *http.Request) (string, bool) {
+	if len(a.domains) == 0 {
+		return "", true
+	}
+
+	host := a.requestOrigin(r).Host
+	if domain, ok := a.resolveDomain(host); ok && host != "" {
+		return domain, true
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil && hostname != "" {
+		return a.resolveDomain(hostname)
+	}
+	return "", false
+}
+
+// queryDomain returns the domain namespace selected by the "domain" query parameter of a link management request,
+// defaulting to the default domain. Otherwise, an error response is written and false is returned.
+func (a API) queryDomain(w http.ResponseWriter, r *http.Request) (string, bool) {
+	requested := r.URL.Query().Get("domain")
+	domain, ok := a.resolveDomain(requested)
+	if !ok {
+		a.log(r).Debug("domain is not allowed", "domain", requested)
+		a.writeError(w, r, http.StatusBadRequest, codeBadRequest, "domain "+requested+" is not allowed")
+		return "", false
+	}
+	return domain, true
+}
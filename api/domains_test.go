@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	hashstub "github.com/jemgunay/url-shortener/hash/stub"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestParseDomains(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected []string
+		wantErr  bool
+	}{
+		{name: "single", raw: "go.example", expected: []string{"go.example"}},
+		{name: "multiple", raw: "Go.Example, mk.example,localhost:8080", expected: []string{"go.example", "mk.example", "localhost:8080"}},
+		{name: "empty", raw: " , ", wantErr: true},
+		{name: "duplicate", raw: "go.example,GO.example", wantErr: true},
+		{name: "path", raw: "go.example/s", wantErr: true},
+		{name: "credentials", raw: "user@go.example", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domains, err := ParseDomains(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for %q, got %v", tt.raw, domains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse domains: %s", err)
+			}
+			if !reflect.DeepEqual(domains, tt.expected) {
+				t.Fatalf("unexpected domains, expected %v, got %v", tt.expected, domains)
+			}
+		})
+	}
+}
+
+func TestAPI_Domains(t *testing.T) {
+	storage := store.New()
+	handlers := New(hashstub.Stub{}, store.WithContext(storage), WithDomains([]string{"go.example", "mk.example"}))
+
+	do := func(pattern string, handler http.HandlerFunc, method, host, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		r.Host = host
+		serveRoute(pattern, handler, w, r)
+		return w
+	}
+
+	// the same alias can be claimed on each domain
+	shortenTests := []struct {
+		name       string
+		reqBody    string
+		respStatus int
+		respBody   string
+	}{
+		{
+			name:       "default_domain",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk/eng", "custom_alias": "roadmap"}`,
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://go.example/roadmap","short_hash":"roadmap","original_url":"https://jemgunay.co.uk/eng","custom_alias":"roadmap","domain":"go.example"}`,
+		},
+		{
+			name:       "requested_domain",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk/marketing", "custom_alias": "roadmap", "domain": "MK.example"}`,
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://mk.example/roadmap","short_hash":"roadmap","original_url":"https://jemgunay.co.uk/marketing","custom_alias":"roadmap","domain":"mk.example"}`,
+		},
+		{
+			name:       "alias_taken_on_domain",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "custom_alias": "roadmap", "domain": "mk.example"}`,
+			respStatus: http.StatusConflict,
+		},
+		{
+			name:       "domain_not_allowed",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk", "domain": "evil.example"}`,
+			respStatus: http.StatusUnprocessableEntity,
+			respBody:   `{"error":{"code":"validation_failed","message":"request payload failed validation","details":[{"field":"domain","reason":"is not an allowed domain"}]}}`,
+		},
+	}
+	for _, tt := range shortenTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do("/api/v1/shorten", handlers.ShortenHandler, http.MethodPost, "api.example", "/api/v1/shorten", tt.reqBody)
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d: %s", tt.respStatus, w.Code, w.Body)
+			}
+			if tt.respBody != "" && w.Body.String() != tt.respBody {
+				t.Fatalf("unexpected response body, expected %s, got %s", tt.respBody, w.Body)
+			}
+		})
+	}
+
+	// storage keys incorporate the domain
+	if _, err := storage.Get("mk.example/roadmap"); err != nil {
+		t.Fatalf("failed to get namespaced record: %s", err)
+	}
+
+	// hashes are resolved within the namespace of the request's host
+	redirectTests := []struct {
+		name       string
+		host       string
+		respStatus int
+		location   string
+	}{
+		{name: "go_domain", host: "go.example", respStatus: http.StatusMovedPermanently, location: "https://jemgunay.co.uk/eng"},
+		{name: "mk_domain_with_port", host: "MK.example:8080", respStatus: http.StatusMovedPermanently, location: "https://jemgunay.co.uk/marketing"},
+		{name: "unknown_domain", host: "evil.example", respStatus: http.StatusNotFound},
+	}
+	for _, tt := range redirectTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do("/{hash}", handlers.RedirectHandler, http.MethodGet, tt.host, "/roadmap", "")
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Fatalf("unexpected location, expected %q, got %q", tt.location, location)
+			}
+		})
+	}
+
+	// links are managed within the domain selected by the query
+	linkTests := []struct {
+		name       string
+		target     string
+		respStatus int
+		respBody   string
+	}{
+		{
+			name:       "default_domain",
+			target:     "/api/v1/links/roadmap",
+			respStatus: http.StatusOK,
+			respBody:   `"short_url":"http://go.example/roadmap","short_hash":"roadmap","domain":"go.example","original_url":"https://jemgunay.co.uk/eng"`,
+		},
+		{
+			name:       "requested_domain",
+			target:     "/api/v1/links/roadmap?domain=mk.example",
+			respStatus: http.StatusOK,
+			respBody:   `"short_url":"http://mk.example/roadmap","short_hash":"roadmap","domain":"mk.example","original_url":"https://jemgunay.co.uk/marketing"`,
+		},
+		{
+			name:       "domain_not_allowed",
+			target:     "/api/v1/links/roadmap?domain=evil.example",
+			respStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range linkTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do("/api/v1/links/{hash}", handlers.LinkHandler, http.MethodGet, "api.example", tt.target, "")
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+			if !bytes.Contains(w.Body.Bytes(), []byte(tt.respBody)) {
+				t.Fatalf("unexpected response body, expected it to contain %s, got %s", tt.respBody, w.Body)
+			}
+		})
+	}
+
+	// listing can be restricted to a single domain
+	listTests := []struct {
+		name    string
+		target  string
+		domains []string
+	}{
+		{name: "all_domains", target: "/api/v1/links", domains: []string{"go.example", "mk.example"}},
+		{name: "single_domain", target: "/api/v1/links?domain=go.example", domains: []string{"go.example"}},
+		{name: "last_domain", target: "/api/v1/links?domain=mk.example", domains: []string{"mk.example"}},
+	}
+	for _, tt := range listTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do("/api/v1/links", handlers.ListHandler, http.MethodGet, "api.example", tt.target, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, w.Code)
+			}
+			resp := listResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to JSON unmarshal response body: %s", err)
+			}
+			var domains []string
+			for _, link := range resp.Links {
+				domains = append(domains, link.Domain)
+			}
+			if !reflect.DeepEqual(domains, tt.domains) {
+				t.Fatalf("unexpected link domains, expected %v, got %v", tt.domains, domains)
+			}
+			if resp.NextCursor != "" {
+				t.Fatalf("unexpected next cursor %q", resp.NextCursor)
+			}
+		})
+	}
+}
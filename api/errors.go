@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Error codes identify the kind of failure in an errorResponse, so that clients need not parse messages.
+const (
+	codeBadRequest       = "bad_request"
+	codeValidationFailed = "validation_failed"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeIdempotencyReuse = "idempotency_key_reused"
+	codeGone             = "gone"
+	codeTooLarge         = "payload_too_large"
+	codeRateLimited      = "rate_limited"
+	codeInternal         = "internal_error"
+	codeUnavailable      = "unavailable"
+	codeTimeout          = "timeout"
+	codeCancelled        = "cancelled"
+)
+
+// statusClientClosedRequest is the non-standard status recorded for requests which were abandoned because the client
+// disconnected, so that they are not mistaken for server failures in access logs and metrics.
+const statusClientClosedRequest = 499
+
+// apiError describes why a request failed.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID correlates the error with the server's logs.
+	RequestID string `json:"request_id,omitempty"`
+	// Details describes each invalid field of a request payload which failed validation.
+	Details []fieldError `json:"details,omitempty"`
+}
+
+// errorResponse is the payload returned by every handler when a request fails.
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// statusError is a request failure which has not been written yet, i.e. so that a batch can report it per item.
+type statusError struct {
+	status int
+	apiError
+}
+
+// newStatusError creates a statusError with the given status, error code and message.
+func newStatusError(status int, code, message string, details ...fieldError) *statusError {
+	return &statusError{
+		status: status,
+		apiError: apiError{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	}
+}
+
+// newValidationError creates a 422 Unprocessable Entity statusError describing each invalid field.
+func newValidationError(errs ...fieldError) *statusError {
+	return newStatusError(http.StatusUnprocessableEntity, codeValidationFailed, "request payload failed validation", errs...)
+}
+
+// newInternalError creates a 500 Internal Server Error statusError. The cause should be logged rather than exposed to
+// clients.
+func newInternalError() *statusError {
+	return newStatusError(http.StatusInternalServerError, codeInternal, "internal server error")
+}
+
+// operationError logs a failed storage or hash operation and creates the statusError to respond with. An operation
+// which exceeded its deadline is a 503 Service Unavailable, as it may succeed if retried, and one which was cancelled
+// because the client disconnected is only logged at debug level. Any other failure is a 500 Internal Server Error.
+func (a API) operationError(r *http.Request, msg string, err error, args ...interface{}) *statusError {
+	args = append(args, "error", err)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		a.log(r).Error(msg, args...)
+		return newStatusError(http.StatusServiceUnavailable, codeTimeout, "request timed out, try again")
+	case errors.Is(err, context.Canceled):
+		a.log(r).Debug(msg, args...)
+		return newStatusError(statusClientClosedRequest, codeCancelled, "request was cancelled")
+	}
+	a.log(r).Error(msg, args...)
+	return newInternalError()
+}
+
+// writeStatusError writes the errorResponse of a statusError.
+func (a API) writeStatusError(w http.ResponseWriter, r *http.Request, err *statusError) {
+	a.writeError(w, r, err.status, err.Code, err.Message, err.Details...)
+}
+
+// internalErrorBody is written if a response cannot be encoded, so must not itself require encoding.
+const internalErrorBody = `{"error":{"code":"internal_error","message":"failed to encode response"}}`
+
+// writeJSON writes the JSON encoded body with the given status.
+func (a API) writeJSON(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	respBytes, err := json.Marshal(body)
+	if err != nil {
+		a.log(r).Error("failed to JSON marshal response payload", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(internalErrorBody))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(respBytes)
+}
+
+// writeError writes an errorResponse with the given status, error code and message.
+func (a API) writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details ...fieldError) {
+	a.writeJSON(w, r, status, errorResponse{
+		Error: apiError{
+			Code:      code,
+			Message:   message,
+			RequestID: requestIDFromContext(r.Context()),
+			Details:   details,
+		},
+	})
+}
+
+// writeInternalError writes a 500 Internal Server Error response. The cause is logged rather than exposed to clients.
+func (a API) writeInternalError(w http.ResponseWriter, r *http.Request) {
+	a.writeError(w, r, http.StatusInternalServerError, codeInternal, "internal server error")
+}
+
+// writeMethodNotAllowed writes a 405 Method Not Allowed response with an Allow header listing the allowed methods.
+func (a API) writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	a.writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method "+r.Method+" is not allowed")
+}
+
+// writeNotFound writes a 404 Not Found response.
+func (a API) writeNotFound(w http.ResponseWriter, r *http.Request, message string) {
+	a.writeError(w, r, http.StatusNotFound, codeNotFound, message)
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// idempotencyKeyHeader is the request header clients set to safely retry a request without repeating its effect.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyReplayedHeader is set on responses which have been replayed from the idempotency cache.
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+
+	idempotencyTTL          = time.Hour * 24
+	maxIdempotencyKeys      = 10000
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentRequestBytes is the maximum size of the body of a request made with an idempotency key, which is
+	// buffered in order to be fingerprinted.
+	maxIdempotentRequestBytes = 4 << 20
+	// maxIdempotentResponseBytes is the maximum size of a captured response; larger responses are not retained.
+	maxIdempotentResponseBytes = 4 << 20
+	// maxIdempotencyCacheBytes bounds the total size of the captured responses.
+	maxIdempotencyCacheBytes = 64 << 20
+)
+
+// idempotentResponse is a response captured for an idempotency key so that it can be replayed for retried requests.
+type idempotentResponse struct {
+	key         string
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+	// done is closed once the response has been captured
+	done chan struct{}
+
+	status int
+	header http.Header
+	body   []byte
+	// size approximates the memory retained by the captured response
+	size int
+}
+
+// idempotencyCache stores the responses of requests made with an idempotency key. Keys expire after a fixed TTL, and
+// the oldest keys are evicted once the cache holds too many keys or its responses are too large in total. It is
+// concurrency safe.
+type idempotencyCache struct {
+	mu      *sync.Mutex
+	entries map[string]*idempotentResponse
+	// order holds entries in insertion order, which is also expiry order as every entry has the same TTL
+	order []*idempotentResponse
+	// size is the total size of the captured responses of entries
+	size          int
+	ttl           time.Duration
+	maxKeys       int
+	maxBytes      int
+	maxEntryBytes int
+}
+
+// newIdempotencyCache creates an idempotencyCache which retains up to maxKeys keys for the given TTL. Responses larger
+// than maxEntryBytes are not retained, and the oldest keys are evicted once the responses exceed maxBytes in total.
+func newIdempotencyCache(ttl time.Duration, maxKeys, maxBytes, maxEntryBytes int) *idempotencyCache {
+	return &idempotencyCache{
+		mu:            &sync.Mutex{},
+		entries:       make(map[string]*idempotentResponse),
+		ttl:           ttl,
+		maxKeys:       maxKeys,
+		maxBytes:      maxBytes,
+		maxEntryBytes: maxEntryBytes,
+	}
+}
+
+// serveIdempotent serves the request with next exactly once per idempotency key and caller. The first request for a
+// key is served and its response captured; subsequent requests with the same key and body are replayed the captured
+// response. A request reusing a key with a different body is rejected with a 422 Unprocessable Entity, and a request
+// for a key which is still being served is rejected with a 409 Conflict. Server errors, cancelled requests and
+// responses too large to retain are not captured so that they can be retried. Request bodies larger than
+// maxIdempotentRequestBytes are rejected with a 413 Request Entity Too Large.
+func (a API) serveIdempotent(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		a.log(r).Debug("idempotency key is too long", "max_length", maxIdempotencyKeyLength)
+		a.writeError(w, r, http.StatusBadRequest, codeBadRequest,
+			fmt.Sprintf("%s header must not exceed %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+		return
+	}
+
+	// the body is fingerprinted to detect keys being reused for different requests, then restored for next
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			a.log(r).Debug("idempotent request body is too large", "max_bytes", tooLarge.Limit)
+			a.writeError(w, r, http.StatusRequestEntityTooLarge, codeTooLarge,
+				fmt.Sprintf("request bodies sent with an %s header must not exceed %d bytes", idempotencyKeyHeader, tooLarge.Limit))
+			return
+		}
+		a.log(r).Debug("failed to read request body", "error", err)
+		a.writeError(w, r, http.StatusBadRequest, codeBadRequest, "failed to read request body")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	fingerprint := sha256.Sum256(body)
+
+	// keys are scoped to the caller so that clients cannot replay each other's responses
+	entry, created := a.idempotency.begin(owner(r)+":"+key, fingerprint, time.Now())
+	if !created {
+		if entry.fingerprint != fingerprint {
+			a.log(r).Debug("idempotency key reused with a different request body", "idempotency_key", key)
+			a.writeError(w, r, http.StatusUnprocessableEntity, codeIdempotencyReuse,
+				idempotencyKeyHeader+" has already been used with a different request body")
+			return
+		}
+
+		select {
+		case <-entry.done:
+			entry.replay(w)
+		default:
+			a.log(r).Debug("request with idempotency key is still in progress", "idempotency_key", key)
+			a.writeError(w, r, http.StatusConflict, codeConflict,
+				"a request with this "+idempotencyKeyHeader+" is still in progress")
+		}
+		return
+	}
+
+	capture := &responseCapture{
+		header: make(http.Header),
+		status: http.StatusOK,
+	}
+	next(capture, r)
+
+	a.idempotency.complete(entry, capture)
+	capture.writeTo(w)
+}
+
+// begin returns the entry for the given key. If there is no unexpired entry, a new in-progress entry is created and
+// created is true.
+func (c *idempotencyCache) begin(key string, fingerprint [sha256.Size]byte, now time.Time) (entry *idempotentResponse, created bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prune(now)
+
+	if entry, ok := c.entries[key]; ok {
+		return entry, false
+	}
+
+	// evict the oldest entries to make space
+	for len(c.order) > 0 && len(c.entries) >= c.maxKeys {
+		c.evict(c.order[0])
+		c.order = c.order[1:]
+	}
+
+	entry = &idempotentResponse{
+		key:         key,
+		fingerprint: fingerprint,
+		expires:     now.Add(c.ttl),
+		done:        make(chan struct{}),
+	}
+	c.entries[key] = entry
+	c.order = append(c.order, entry)
+	return entry, true
+}
+
+// complete stores the captured response of an entry. Server error responses, including timeouts, the responses of
+// requests abandoned by the client and responses larger than maxEntryBytes are discarded so the request can be retried
+// with the same key. The oldest entries are then evicted until the cache is within maxBytes.
+func (c *idempotencyCache) complete(entry *idempotentResponse, capture *responseCapture) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.status = capture.status
+	entry.header = capture.header.Clone()
+	entry.body = capture.body.Bytes()
+	close(entry.done)
+
+	if capture.status >= http.StatusInternalServerError || capture.status == statusClientClosedRequest {
+		c.evict(entry)
+		return
+	}
+
+	size := len(entry.key) + len(entry.body)
+	for k, v := range entry.header {
+		size += len(k)
+		for _, value := range v {
+			size += len(value)
+		}
+	}
+	if size > c.maxEntryBytes {
+		c.evict(entry)
+		return
+	}
+	if c.entries[entry.key] != entry {
+		// the entry was evicted while its request was being served
+		return
+	}
+	entry.size = size
+	c.size += size
+
+	for len(c.order) > 0 && c.size > c.maxBytes {
+		c.evict(c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// prune removes expired entries. The caller must hold mu.
+func (c *idempotencyCache) prune(now time.Time) {
+	for len(c.order) > 0 && !now.Before(c.order[0].expires) {
+		c.evict(c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// evict removes an entry from the lookup if it has not since been replaced. The caller must hold mu.
+func (c *idempotencyCache) evict(entry *idempotentResponse) {
+	if c.entries[entry.key] == entry {
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+	}
+}
+
+// replay writes the captured response.
+func (e *idempotentResponse) replay(w http.ResponseWriter) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// responseCapture is a http.ResponseWriter which buffers the response so that it can be inspected before being
+// written to the client.
+type responseCapture struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header returns the captured response headers.
+func (c *responseCapture) Header() http.Header {
+	return c.header
+}
+
+// WriteHeader captures the response status.
+func (c *responseCapture) WriteHeader(status int) {
+	c.status = status
+}
+
+// Write captures the response body.
+func (c *responseCapture) Write(b []byte) (int, error) {
+	return c.body.Write(b)
+}
+
+// writeTo writes the captured response.
+func (c *responseCapture) writeTo(w http.ResponseWriter) {
+	for k, v := range c.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(c.status)
+	w.Write(c.body.Bytes())
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyCache_Limits(t *testing.T) {
+	now := time.Now()
+	capture := func(body string) *responseCapture {
+		c := &responseCapture{header: make(http.Header), status: http.StatusOK}
+		c.body.WriteString(body)
+		return c
+	}
+	serve := func(c *idempotencyCache, key, body string) {
+		entry, created := c.begin(key, sha256.Sum256([]byte(key)), now)
+		if !created {
+			t.Fatalf("expected an entry to be created for key %s", key)
+		}
+		c.complete(entry, capture(body))
+	}
+	cached := func(c *idempotencyCache, key string) bool {
+		_, ok := c.entries[key]
+		return ok
+	}
+
+	tests := []struct {
+		name string
+		// maxKeys, maxBytes and maxEntryBytes configure the cache
+		maxKeys       int
+		maxBytes      int
+		maxEntryBytes int
+		// bodies are the response bodies captured for keys "a", "b", "c", etc.; the size of each entry is its body
+		// plus its 1 byte key
+		bodies    []string
+		cached    []string
+		evicted   []string
+		totalSize int
+	}{
+		{
+			name:          "within_limits",
+			maxKeys:       10,
+			maxBytes:      100,
+			maxEntryBytes: 100,
+			bodies:        []string{strings.Repeat("a", 10), strings.Repeat("b", 10)},
+			cached:        []string{"a", "b"},
+			totalSize:     22,
+		},
+		{
+			name:          "too_many_keys",
+			maxKeys:       2,
+			maxBytes:      100,
+			maxEntryBytes: 100,
+			bodies:        []string{strings.Repeat("a", 10), strings.Repeat("b", 10), strings.Repeat("c", 10)},
+			cached:        []string{"b", "c"},
+			evicted:       []string{"a"},
+			totalSize:     22,
+		},
+		{
+			name:          "too_many_bytes",
+			maxKeys:       10,
+			maxBytes:      25,
+			maxEntryBytes: 25,
+			bodies:        []string{strings.Repeat("a", 10), strings.Repeat("b", 10), strings.Repeat("c", 10)},
+			cached:        []string{"b", "c"},
+			evicted:       []string{"a"},
+			totalSize:     22,
+		},
+		{
+			name:          "response_too_large",
+			maxKeys:       10,
+			maxBytes:      100,
+			maxEntryBytes: 15,
+			bodies:        []string{strings.Repeat("a", 10), strings.Repeat("b", 20), strings.Repeat("c", 10)},
+			cached:        []string{"a", "c"},
+			evicted:       []string{"b"},
+			totalSize:     22,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newIdempotencyCache(time.Hour, tt.maxKeys, tt.maxBytes, tt.maxEntryBytes)
+			for i, body := range tt.bodies {
+				serve(c, string(rune('a'+i)), body)
+			}
+
+			for _, key := range tt.cached {
+				if !cached(c, key) {
+					t.Fatalf("expected key %s to be cached", key)
+				}
+			}
+			for _, key := range tt.evicted {
+				if cached(c, key) {
+					t.Fatalf("expected key %s to be evicted", key)
+				}
+			}
+			if c.size != tt.totalSize {
+				t.Fatalf("unexpected cache size, expected %d, got %d", tt.totalSize, c.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// apiKeyPrefix prefixes every generated API key so that leaked keys are easy to identify.
+const apiKeyPrefix = "us_"
+
+// APIKey is the stored representation of an API key. Only a SHA-256 hash of the key is stored, never the key itself.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Admin     bool      `json:"admin"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KeyStore holds the API keys which may access the API. If it was loaded from a file, changes are persisted to that
+// file. It is concurrency safe.
+type KeyStore struct {
+	path   string
+	mu     *sync.RWMutex
+	byHash map[string]APIKey
+}
+
+// NewKeyStore creates an empty KeyStore which is held in memory only.
+func NewKeyStore() *KeyStore {
+	return &KeyStore{
+		mu:     &sync.RWMutex{},
+		byHash: make(map[string]APIKey),
+	}
+}
+
+// LoadKeyStore creates a KeyStore from the given file, which contains one JSON encoded APIKey per line. A missing file
+// is treated as empty and is created once the first key is added.
+func LoadKeyStore(path string) (*KeyStore, error) {
+	ks := NewKeyStore()
+	ks.path = path
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ks, nil
+		}
+		return nil, fmt.Errorf("failed to open keys file: %s", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		key := APIKey{}
+		if err := json.Unmarshal(scanner.Bytes(), &key); err != nil {
+			return nil, fmt.Errorf("invalid key on line %d of keys file: %s", line, err)
+		}
+		ks.byHash[key.Hash] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read keys file: %s", err)
+	}
+	return ks, nil
+}
+
+// hashAPIKey returns the hex encoded SHA-256 hash of an API key. Keys are generated with 256 bits of entropy, so a fast
+// unsalted hash is sufficient to protect them at rest.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes encoded with the given encoding function.
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %s", err)
+	}
+	return encode(b), nil
+}
+
+// Create generates a new API key for the given owner. The plaintext key is returned once and cannot be recovered.
+func (ks *KeyStore) Create(owner string, admin bool) (APIKey, string, error) {
+	plaintext, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	plaintext = apiKeyPrefix + plaintext
+
+	id, err := randomString(6, hex.EncodeToString)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	key := APIKey{
+		ID:        id,
+		Owner:     owner,
+		Admin:     admin,
+		Hash:      hashAPIKey(plaintext),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.byHash[key.Hash] = key
+	if err := ks.persist(); err != nil {
+		delete(ks.byHash, key.Hash)
+		return APIKey{}, "", err
+	}
+	return key, plaintext, nil
+}
+
+// ErrAPIKeyNotFound indicates that no API key exists with the provided ID.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// Revoke deletes the API key with the given ID. If the key is not found, ErrAPIKeyNotFound is returned.
+func (ks *KeyStore) Revoke(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for hash, key := range ks.byHash {
+		if key.ID != id {
+			continue
+		}
+		delete(ks.byHash, hash)
+		if err := ks.persist(); err != nil {
+			ks.byHash[hash] = key
+			return err
+		}
+		return nil
+	}
+	return ErrAPIKeyNotFound
+}
+
+// Authenticate returns the API key matching the given plaintext key, and false if there is no such key.
+func (ks *KeyStore) Authenticate(plaintext string) (APIKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.byHash[hashAPIKey(plaintext)]
+	return key, ok
+}
+
+// List returns every API key ordered by creation time.
+func (ks *KeyStore) List() []APIKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]APIKey, 0, len(ks.byHash))
+	for _, key := range ks.byHash {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// EnsureAdmin creates an admin API key if none exist, so that further keys can be managed through the API. The
+// plaintext key is returned if one was created, otherwise an empty string is returned.
+func (ks *KeyStore) EnsureAdmin() (string, error) {
+	for _, key := range ks.List() {
+		if key.Admin {
+			return "", nil
+		}
+	}
+
+	_, plaintext, err := ks.Create("admin", true)
+	return plaintext, err
+}
+
+// persist atomically rewrites the keys file, if there is one. The caller must hold the write lock.
+func (ks *KeyStore) persist() error {
+	if ks.path == "" {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), filepath.Base(ks.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary keys file: %s", err)
+	}
+	// clean up the temporary file if it was not renamed
+	defer os.Remove(tmp.Name())
+
+	encoder := json.NewEncoder(tmp)
+	for _, key := range ks.byHash {
+		if err := encoder.Encode(key); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write keys file: %s", err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync keys file: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close keys file: %s", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return fmt.Errorf("failed to set keys file permissions: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), ks.path); err != nil {
+		return fmt.Errorf("failed to replace keys file: %s", err)
+	}
+	return nil
+}
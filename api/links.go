@@ -0,0 +1,311 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jemgunay/url-shortener/store"
+)
+
+const (
+	// linksPath is the path of the link management endpoints.
+	linksPath = "/api/v1/links"
+
+	defaultListLimit = 50
+	maxListLimit     = 1000
+	// maxListScan is the maximum number of stored links read from storage to fill a single page, so that callers who
+	// own few links cannot make each request read the entire store. A short page is returned once it is reached.
+	maxListScan = 5000
+)
+
+// linkResponse is the representation of a stored link returned by the link management handlers.
+type linkResponse struct {
+	ShortURL  string `json:"short_url"`
+	ShortHash string `json:"short_hash"`
+	// Domain is omitted if multiple domains are not enabled.
+	Domain      string     `json:"domain,omitempty"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// RedirectStatus is omitted if the link uses the server's default redirect status.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+}
+
+// newLinkResponse creates a linkResponse from a record and the key it is stored against.
+func (a API) newLinkResponse(r *http.Request, key string, record store.Record) linkResponse {
+	domain, hashID := splitStorageKey(key)
+	resp := linkResponse{
+		ShortURL:       a.shortURL(r, domain, hashID),
+		ShortHash:      hashID,
+		Domain:         domain,
+		OriginalURL:    record.URL,
+		CreatedAt:      record.CreatedAt,
+		RedirectStatus: record.RedirectStatus,
+	}
+	if !record.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &record.ExpiresAt
+	}
+	return resp
+}
+
+// listResponse is the payload returned by the ListHandler.
+type listResponse struct {
+	Links []linkResponse `json:"links"`
+	// NextCursor is the cursor to request the next page with. It is omitted on the final page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// updatePayload is the payload expected when updating a link. Omitted fields are left unchanged.
+type updatePayload struct {
+	OriginalURL *string `json:"original_url"`
+	// TTL and ExpiresAt replace the link's expiry, as per the shortenPayload. An explicit null ExpiresAt removes the
+	// link's expiry.
+	TTL       string       `json:"ttl"`
+	ExpiresAt nullableTime `json:"expires_at"`
+	// RedirectStatus replaces the link's redirect status. Zero reverts the link to the server's default.
+	RedirectStatus *int `json:"redirect_status"`
+}
+
+// nullableTime is a JSON time which distinguishes an explicit null from an omitted field.
+type nullableTime struct {
+	// Set is true if the field was present. Time is nil if it was null.
+	Set  bool
+	Time *time.Time
+}
+
+// UnmarshalJSON decodes a JSON time or null, marking the field as present.
+func (n *nullableTime) UnmarshalJSON(b []byte) error {
+	n.Set = true
+	return json.Unmarshal(b, &n.Time)
+}
+
+// ListHandler returns a page of stored links ordered by hash. The page size is set with the "limit" query parameter and
+// subsequent pages are requested by setting the "cursor" query parameter to the previous page's next_cursor. Only the
+// links which the caller may manage are listed; at most maxListScan links are read per page, so a page may be short (or
+// empty) with a next_cursor to continue from. If multiple domains are enabled, links are ordered by domain then hash,
+// and the "domain" query parameter restricts the page to a single domain.
+func (a API) ListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	limit := defaultListLimit
+	if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			a.log(r).Debug("invalid list limit", "limit", rawLimit)
+			a.writeError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+			return
+		}
+	}
+
+	cursor := r.URL.Query().Get("cursor")
+
+	// keys are prefixed by their domain, so a single domain's links are listed from the start of its prefix until a key
+	// without the prefix is reached
+	var prefix string
+	if r.URL.Query().Get("domain") != "" {
+		domain, ok := a.queryDomain(w, r)
+		if !ok {
+			return
+		}
+		prefix = storageKey(domain, "")
+		if cursor < prefix {
+			cursor = prefix
+		}
+	}
+
+	respBody := listResponse{
+		Links: make([]linkResponse, 0, limit),
+	}
+
+	// callers only see the links they may manage, so keep reading from storage until the page is full or the scan
+	// limit is reached, resuming after the last link read
+	var scanned int
+	for full := false; !full; {
+		entries, next, err := a.storage.ListContext(r.Context(), cursor, min(maxListLimit, maxListScan-scanned))
+		if err != nil {
+			a.writeStatusError(w, r, a.operationError(r, "failed to list links", err))
+			return
+		}
+		for i, entry := range entries {
+			if !strings.HasPrefix(entry.Key, prefix) {
+				next = ""
+				break
+			}
+			scanned++
+			if a.canManage(r, entry.Record) {
+				respBody.Links = append(respBody.Links, a.newLinkResponse(r, entry.Key, entry.Record))
+			}
+			if len(respBody.Links) == limit || scanned == maxListScan {
+				if i < len(entries)-1 {
+					next = entry.Key
+				}
+				full = true
+				break
+			}
+		}
+
+		respBody.NextCursor = next
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	a.writeJSON(w, r, http.StatusOK, respBody)
+}
+
+// LinkHandler manages the link identified by the hash in URLs of the form "/api/v1/links/{hash}". GET returns the
+// link, PATCH updates its original URL, expiry and/or redirect status, and DELETE removes it. A 403 Forbidden is
+// returned if the caller does not own the link. If multiple domains are enabled, the "domain" query parameter selects
+// the hash's domain, defaulting to the default domain.
+func (a API) LinkHandler(w http.ResponseWriter, r *http.Request) {
+	hashID := r.PathValue("hash")
+	domain, ok := a.queryDomain(w, r)
+	if !ok {
+		return
+	}
+	key := storageKey(domain, hashID)
+
+	switch r.Method {
+	case http.MethodGet:
+		a.getLink(w, r, key)
+	case http.MethodPatch:
+		a.updateLink(w, r, key)
+	case http.MethodDelete:
+		a.deleteLink(w, r, key)
+	default:
+		a.writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+// managedRecord returns the record stored against the given key if the caller may manage it. Otherwise, an error
+// response is written and false is returned.
+func (a API) managedRecord(w http.ResponseWriter, r *http.Request, key string) (store.Record, bool) {
+	record, err := a.storage.GetContext(r.Context(), key)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			a.log(r).Debug("URL not found", "key", key)
+			a.writeNotFound(w, r, "link not found")
+			return store.Record{}, false
+		}
+		a.writeStatusError(w, r, a.operationError(r, "failed to perform store URL lookup", err))
+		return store.Record{}, false
+	}
+
+	if !a.canManage(r, record) {
+		a.log(r).Warn("caller does not own link", "key", key)
+		a.writeError(w, r, http.StatusForbidden, codeForbidden, "link is owned by another API key")
+		return store.Record{}, false
+	}
+	return record, true
+}
+
+// getLink writes the link stored against the given key.
+func (a API) getLink(w http.ResponseWriter, r *http.Request, key string) {
+	record, ok := a.managedRecord(w, r, key)
+	if !ok {
+		return
+	}
+
+	a.writeJSON(w, r, http.StatusOK, a.newLinkResponse(r, key, record))
+}
+
+// updateLink applies an updatePayload to the link stored against the given key and writes the updated link. An
+// "expires_at" of null makes the link permanent.
+func (a API) updateLink(w http.ResponseWriter, r *http.Request, key string) {
+	payload := updatePayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		a.log(r).Debug("failed to JSON unmarshal request payload", "error", err)
+		a.writeError(w, r, http.StatusBadRequest, codeBadRequest, "request payload must be valid JSON")
+		return
+	}
+	if payload.OriginalURL == nil && payload.TTL == "" && !payload.ExpiresAt.Set && payload.RedirectStatus == nil {
+		a.log(r).Debug("update payload contains no changes")
+		a.writeError(w, r, http.StatusBadRequest, codeBadRequest, "update payload contains no changes")
+		return
+	}
+
+	record, ok := a.managedRecord(w, r, key)
+	if !ok {
+		return
+	}
+
+	if payload.OriginalURL != nil {
+		originalURL, invalid := normaliseURL("original_url", *payload.OriginalURL)
+		if invalid != nil {
+			a.log(r).Debug("invalid original URL", "reason", invalid.String())
+			a.writeValidationError(w, r, *invalid)
+			return
+		}
+		record.URL = originalURL
+	}
+	if payload.RedirectStatus != nil {
+		if invalid := validateRedirectStatus("redirect_status", *payload.RedirectStatus); invalid != nil {
+			a.log(r).Debug("invalid redirect status", "reason", invalid.String())
+			a.writeValidationError(w, r, *invalid)
+			return
+		}
+		record.RedirectStatus = *payload.RedirectStatus
+	}
+	switch {
+	case payload.ExpiresAt.Set && payload.ExpiresAt.Time == nil:
+		// an explicit null removes the expiry
+		if payload.TTL != "" {
+			a.log(r).Debug("invalid expiry", "error", "ttl and expires_at are mutually exclusive")
+			a.writeError(w, r, http.StatusBadRequest, codeBadRequest, "ttl and expires_at are mutually exclusive")
+			return
+		}
+		record.ExpiresAt = time.Time{}
+
+	case payload.TTL != "" || payload.ExpiresAt.Set:
+		expiry := shortenPayload{TTL: payload.TTL, ExpiresAt: payload.ExpiresAt.Time}
+		var err error
+		record.ExpiresAt, err = expiry.expiry(time.Now().UTC())
+		if err != nil {
+			a.log(r).Debug("invalid expiry", "error", err)
+			a.writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := a.storage.UpdateContext(r.Context(), key, record); err != nil {
+		// the link may have been deleted since it was read
+		if err == store.ErrKeyNotFound {
+			a.log(r).Debug("URL not found", "key", key)
+			a.writeNotFound(w, r, "link not found")
+			return
+		}
+		a.writeStatusError(w, r, a.operationError(r, "failed to update URL", err))
+		return
+	}
+
+	a.writeJSON(w, r, http.StatusOK, a.newLinkResponse(r, key, record))
+}
+
+// deleteLink removes the link stored against the given key, along with its click analytics.
+func (a API) deleteLink(w http.ResponseWriter, r *http.Request, key string) {
+	if _, ok := a.managedRecord(w, r, key); !ok {
+		return
+	}
+
+	if err := a.storage.DeleteContext(r.Context(), key); err != nil {
+		if err == store.ErrKeyNotFound {
+			a.log(r).Debug("URL not found", "key", key)
+			a.writeNotFound(w, r, "link not found")
+			return
+		}
+		a.writeStatusError(w, r, a.operationError(r, "failed to delete URL", err))
+		return
+	}
+	a.resetStats(key)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,329 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestAPI_LinkHandler(t *testing.T) {
+	createdAt := time.Date(2021, 12, 28, 21, 25, 48, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		method     string
+		reqURL     string
+		reqBody    string
+		respStatus int
+		respBody   string
+		storedURL  string
+	}{
+		{
+			name:       "success_get",
+			method:     http.MethodGet,
+			reqURL:     "/api/v1/links/123456",
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk","created_at":"2021-12-28T21:25:48Z"}`,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+		{
+			name:       "get_not_found",
+			method:     http.MethodGet,
+			reqURL:     "/api/v1/links/abcdef",
+			respStatus: http.StatusNotFound,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+		{
+			name:       "success_update_url",
+			method:     http.MethodPatch,
+			reqURL:     "/api/v1/links/123456",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk/fixed"}`,
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk/fixed","created_at":"2021-12-28T21:25:48Z"}`,
+			storedURL:  "https://jemgunay.co.uk/fixed",
+		},
+		{
+			name:       "success_update_expiry",
+			method:     http.MethodPatch,
+			reqURL:     "/api/v1/links/123456",
+			reqBody:    `{"expires_at": "2999-01-01T00:00:00Z"}`,
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk","created_at":"2021-12-28T21:25:48Z","expires_at":"2999-01-01T00:00:00Z"}`,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+		{
+			name:       "success_update_redirect_status",
+			method:     http.MethodPatch,
+			reqURL:     "/api/v1/links/123456",
+			reqBody:    `{"redirect_status": 308}`,
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk","created_at":"2021-12-28T21:25:48Z","redirect_status":308}`,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+		{
+			name:       "update_invalid_redirect_status",
+			method:     http.MethodPatch,
+			reqURL:     "/api/v1/links/123456",
+			reqBody:    `{"redirect_status": 304}`,
+			respStatus: http.StatusUnprocessableEntity,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+		{
+			name:       "update_no_changes",
+			method:     http.MethodPatch,
+			reqURL:     "/api/v1/links/123456",
+			reqBody:    `{}`,
+			respStatus: http.StatusBadRequest,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+		{
+			name:       "update_not_found",
+			method:     http.MethodPatch,
+			reqURL:     "/api/v1/links/abcdef",
+			reqBody:    `{"original_url": "https://jemgunay.co.uk/fixed"}`,
+			respStatus: http.StatusNotFound,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+		{
+			name:       "success_delete",
+			method:     http.MethodDelete,
+			reqURL:     "/api/v1/links/123456",
+			respStatus: http.StatusNoContent,
+		},
+		{
+			name:       "delete_not_found",
+			method:     http.MethodDelete,
+			reqURL:     "/api/v1/links/abcdef",
+			respStatus: http.StatusNotFound,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+		{
+			name:       "invalid_method",
+			method:     http.MethodPost,
+			reqURL:     "/api/v1/links/123456",
+			respStatus: http.StatusMethodNotAllowed,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+		{
+			name:       "nested_path",
+			method:     http.MethodGet,
+			reqURL:     "/api/v1/links/123456/other",
+			respStatus: http.StatusNotFound,
+			storedURL:  "https://jemgunay.co.uk",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeStub := store.New()
+			storeStub.Set("123456", store.Record{URL: "https://jemgunay.co.uk", CreatedAt: createdAt})
+			handlers := New(nil, store.WithContext(storeStub))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.reqURL, bytes.NewBufferString(tt.reqBody))
+			r.URL.Host = "localhost:8080"
+
+			serveRoute("/api/v1/links/{hash}", handlers.LinkHandler, w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+			if tt.respBody != "" && w.Body.String() != tt.respBody {
+				t.Fatalf("unexpected body, expected %s, got %s", tt.respBody, w.Body.String())
+			}
+
+			// check the effect on the stored link
+			record, err := storeStub.Get("123456")
+			if tt.storedURL == "" {
+				if err != store.ErrKeyNotFound {
+					t.Fatalf("expected link to be deleted, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to get stored link: %s", err)
+			}
+			if record.URL != tt.storedURL {
+				t.Fatalf("unexpected stored URL, expected %s, got %s", tt.storedURL, record.URL)
+			}
+		})
+	}
+}
+
+func TestAPI_LinkHandler_UpdateExpiry(t *testing.T) {
+	createdAt := time.Date(2021, 12, 28, 21, 25, 48, 0, time.UTC)
+	expiresAt := time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		reqBody       string
+		respStatus    int
+		respBody      string
+		storedExpires time.Time
+	}{
+		{
+			name:          "clear_expiry",
+			reqBody:       `{"expires_at": null}`,
+			respStatus:    http.StatusOK,
+			respBody:      `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk","created_at":"2021-12-28T21:25:48Z"}`,
+			storedExpires: time.Time{},
+		},
+		{
+			name:          "omitted_expiry_unchanged",
+			reqBody:       `{"original_url": "https://jemgunay.co.uk"}`,
+			respStatus:    http.StatusOK,
+			respBody:      `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk","created_at":"2021-12-28T21:25:48Z","expires_at":"2999-01-01T00:00:00Z"}`,
+			storedExpires: expiresAt,
+		},
+		{
+			name:          "replace_expiry",
+			reqBody:       `{"expires_at": "2998-01-01T00:00:00Z"}`,
+			respStatus:    http.StatusOK,
+			respBody:      `{"short_url":"http://localhost:8080/123456","short_hash":"123456","original_url":"https://jemgunay.co.uk","created_at":"2021-12-28T21:25:48Z","expires_at":"2998-01-01T00:00:00Z"}`,
+			storedExpires: time.Date(2998, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "clear_expiry_with_ttl",
+			reqBody:       `{"expires_at": null, "ttl": "1h"}`,
+			respStatus:    http.StatusBadRequest,
+			respBody:      `{"error":{"code":"bad_request","message":"ttl and expires_at are mutually exclusive"}}`,
+			storedExpires: expiresAt,
+		},
+		{
+			name:          "invalid_expiry",
+			reqBody:       `{"expires_at": "tomorrow"}`,
+			respStatus:    http.StatusBadRequest,
+			storedExpires: expiresAt,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeStub := store.New()
+			storeStub.Set("123456", store.Record{URL: "https://jemgunay.co.uk", CreatedAt: createdAt, ExpiresAt: expiresAt})
+			handlers := New(nil, store.WithContext(storeStub))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPatch, "/api/v1/links/123456", bytes.NewBufferString(tt.reqBody))
+			r.URL.Host = "localhost:8080"
+
+			serveRoute("/api/v1/links/{hash}", handlers.LinkHandler, w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+			if tt.respBody != "" && w.Body.String() != tt.respBody {
+				t.Fatalf("unexpected body, expected %s, got %s", tt.respBody, w.Body.String())
+			}
+
+			record, err := storeStub.Get("123456")
+			if err != nil {
+				t.Fatalf("failed to get stored link: %s", err)
+			}
+			if !record.ExpiresAt.Equal(tt.storedExpires) {
+				t.Fatalf("unexpected stored expiry, expected %s, got %s", tt.storedExpires, record.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestAPI_ListHandler(t *testing.T) {
+	storeStub := store.New()
+	for _, hashID := range []string{"c", "a", "e", "b", "d"} {
+		storeStub.Set(hashID, store.Record{URL: "https://jemgunay.co.uk/" + hashID})
+	}
+	handlers := New(nil, store.WithContext(storeStub))
+
+	// page through every link two at a time
+	var (
+		hashes []string
+		cursor string
+		pages  int
+	)
+	for {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/links?limit=2&cursor="+cursor, nil)
+		r.URL.Host = "localhost:8080"
+
+		handlers.ListHandler(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, w.Code)
+		}
+		resp := listResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to JSON unmarshal response body: %s", err)
+		}
+		for _, link := range resp.Links {
+			hashes = append(hashes, link.ShortHash)
+		}
+
+		pages++
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+
+	if pages != 3 {
+		t.Fatalf("unexpected page count, expected 3, got %d", pages)
+	}
+	expected := []string{"a", "b", "c", "d", "e"}
+	if len(hashes) != len(expected) {
+		t.Fatalf("unexpected hashes, expected %v, got %v", expected, hashes)
+	}
+	for i := range expected {
+		if hashes[i] != expected[i] {
+			t.Fatalf("unexpected hashes, expected %v, got %v", expected, hashes)
+		}
+	}
+
+	// invalid limits are rejected
+	w := httptest.NewRecorder()
+	handlers.ListHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/links?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAPI_ListHandler_ScanLimit(t *testing.T) {
+	// the caller owns a single link, stored after every other owner's links
+	storeStub := store.New()
+	for i := 0; i < maxListScan+10; i++ {
+		storeStub.Set(fmt.Sprintf("a%05d", i), store.Record{URL: "https://jemgunay.co.uk", Owner: "bob"})
+	}
+	storeStub.Set("z", store.Record{URL: "https://jemgunay.co.uk/alice", Owner: "alice"})
+	handlers := New(nil, store.WithContext(storeStub), WithKeyStore(NewKeyStore()))
+
+	list := func(cursor string) listResponse {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/links?cursor="+cursor, nil)
+		r = r.WithContext(context.WithValue(r.Context(), callerContextKey{}, APIKey{ID: "alice", Owner: "alice"}))
+		handlers.ListHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, w.Code)
+		}
+		resp := listResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to JSON unmarshal response body: %s", err)
+		}
+		return resp
+	}
+
+	// the first page stops at the scan limit with a cursor to continue from
+	first := list("")
+	if len(first.Links) != 0 || first.NextCursor != fmt.Sprintf("a%05d", maxListScan-1) {
+		t.Fatalf("unexpected first page: %d links, next cursor %q", len(first.Links), first.NextCursor)
+	}
+	second := list(first.NextCursor)
+	if len(second.Links) != 1 || second.Links[0].ShortHash != "z" || second.NextCursor != "" {
+		t.Fatalf("unexpected second page: %+v", second)
+	}
+}
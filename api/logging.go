@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// requestIDHeader is the header which carries the ID used to correlate the logs of a request.
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// WithLogger sets the logger which handlers log to. Defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *API) {
+		a.logger = logger
+	}
+}
+
+// requestIDContextKey is the request context key of the request ID.
+type requestIDContextKey struct{}
+
+// requestIDFromContext returns the ID assigned to a request by the RequestID middleware, if any.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// validRequestID determines if a client provided request ID is safe to propagate, i.e. is short and only contains
+// visible ASCII characters so that it cannot forge log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID assigns an ID to every request, which is included in the handlers' logs and echoed in the X-Request-ID
+// response header. A valid X-Request-ID request header is propagated, i.e. from an upstream proxy, otherwise a random
+// ID is generated.
+func (a API) RequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			var err error
+			id, err = randomString(16, hex.EncodeToString)
+			if err != nil {
+				a.logger.Error("failed to generate request ID", "error", err)
+			}
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id)))
+	}
+}
+
+// log returns the logger for a request, which annotates every line with the request's ID.
+func (a API) log(r *http.Request) *slog.Logger {
+	if id := requestIDFromContext(r.Context()); id != "" {
+		return a.logger.With("request_id", id)
+	}
+	return a.logger
+}
+
+// AccessLog logs the method, path, status, response size and duration of every request served by the wrapped handler.
+// It should be wrapped by RequestID so that access logs can be correlated with the handlers' logs.
+func (a API) AccessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next(recorder, r)
+
+		a.log(r).Info("request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration", time.Since(start),
+			"client_ip", a.clientIP(r),
+		)
+	}
+}
+
+// accessRecorder is a http.ResponseWriter which records the response status and size.
+type accessRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+// WriteHeader records the response status and writes it.
+func (a *accessRecorder) WriteHeader(status int) {
+	if !a.wroteHeader {
+		a.status = status
+		a.wroteHeader = true
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the response body and records its size.
+func (a *accessRecorder) Write(b []byte) (int, error) {
+	a.wroteHeader = true
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += n
+	return n, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, so that a http.ResponseController can flush streamed responses.
+func (a *accessRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
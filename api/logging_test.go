@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	hashstub "github.com/jemgunay/url-shortener/hash/stub"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestAPI_RequestID(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		propagate bool
+	}{
+		{name: "generated", requestID: ""},
+		{name: "propagated", requestID: "upstream-1234", propagate: true},
+		{name: "invalid_replaced", requestID: "forged\nline"},
+		{name: "too_long_replaced", requestID: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers := New(hashstub.Stub{}, store.WithContext(store.New()))
+
+			var contextID string
+			handler := handlers.RequestID(func(w http.ResponseWriter, r *http.Request) {
+				contextID = requestIDFromContext(r.Context())
+			})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/123456", nil)
+			if tt.requestID != "" {
+				r.Header.Set(requestIDHeader, tt.requestID)
+			}
+			handler(w, r)
+
+			respID := w.Header().Get(requestIDHeader)
+			if respID == "" || respID != contextID {
+				t.Fatalf("unexpected request IDs, header %q, context %q", respID, contextID)
+			}
+			if tt.propagate != (respID == tt.requestID) {
+				t.Fatalf("unexpected request ID %q for request ID %q", respID, tt.requestID)
+			}
+		})
+	}
+}
+
+func TestAPI_AccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	handlers := New(hashstub.Stub{}, store.WithContext(store.New()), WithLogger(logger))
+
+	handler := handlers.RequestID(handlers.AccessLog(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/teapot", nil)
+	r.Header.Set(requestIDHeader, "req-1")
+	handler(httptest.NewRecorder(), r)
+
+	line := struct {
+		Level     string `json:"level"`
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Method    string `json:"method"`
+		Path      string `json:"path"`
+		Status    int    `json:"status"`
+		Bytes     int    `json:"bytes"`
+		Duration  *int64 `json:"duration"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("failed to JSON unmarshal access log %q: %s", buf.String(), err)
+	}
+
+	if line.Level != "INFO" || line.Msg != "request served" || line.RequestID != "req-1" || line.Method != http.MethodGet ||
+		line.Path != "/teapot" || line.Status != http.StatusTeapot || line.Bytes != len("short and stout") || line.Duration == nil {
+		t.Fatalf("unexpected access log: %s", buf.String())
+	}
+}
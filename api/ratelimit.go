@@ -0,0 +1,231 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit describes a token bucket: clients may make Burst requests at once, and regain Rate requests per second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// bucket is the token bucket of a single client.
+type bucket struct {
+	tokens float64
+	// updated is when tokens was last refilled
+	updated time.Time
+}
+
+// RateLimiter enforces a RateLimit per client. Clients which have been idle for long enough to have refilled their
+// bucket are evicted, so memory is only held for active clients. It is concurrency safe.
+type RateLimiter struct {
+	limit RateLimit
+	// sweepInterval is how often idle clients are evicted
+	sweepInterval time.Duration
+
+	mu        *sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a RateLimiter which enforces the given limit, evicting idle clients every sweepInterval. The
+// limit's Rate and Burst must be positive.
+func NewRateLimiter(limit RateLimit, sweepInterval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:         limit,
+		sweepInterval: sweepInterval,
+		mu:            &sync.Mutex{},
+		buckets:       make(map[string]*bucket),
+	}
+}
+
+// rateLimitResult is the outcome of taking a token from a client's bucket.
+type rateLimitResult struct {
+	allowed   bool
+	remaining int
+	// reset is the time until the bucket is full again
+	reset time.Duration
+	// retryAfter is the time until a token is available; it is zero if the request was allowed
+	retryAfter time.Duration
+}
+
+// take attempts to take a token from the client's bucket at the given time.
+func (l *RateLimiter) take(client string, now time.Time) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= l.sweepInterval {
+		l.sweep(now)
+		l.lastSweep = now
+	}
+
+	burst := float64(l.limit.Burst)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[client] = b
+	}
+
+	// refill the tokens accrued since the last request
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*l.limit.Rate)
+		b.updated = now
+	}
+
+	result := rateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = l.duration(1 - b.tokens)
+	}
+	result.remaining = int(b.tokens)
+	result.reset = l.duration(burst - b.tokens)
+	return result
+}
+
+// duration returns the time taken to accrue the given number of tokens.
+func (l *RateLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
+
+// sweep evicts clients whose buckets have refilled, as they are indistinguishable from new clients. The caller must
+// hold mu.
+func (l *RateLimiter) sweep(now time.Time) {
+	full := l.duration(float64(l.limit.Burst))
+	for client, b := range l.buckets {
+		if now.Sub(b.updated) >= full {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clients returns the number of clients currently tracked.
+func (l *RateLimiter) clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// RateLimit limits the rate of requests to the wrapped handler per client, responding with a 429 Too Many Requests and
+// a Retry-After header once a client exceeds the limiter's limit. Clients are identified by their API key if the
+// request has been authenticated, otherwise by their IP address. RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers are set on every response. Each request takes one token, including batches which create
+// several links. If the limiter is nil, requests are passed through unchanged.
+func (a API) RateLimit(limiter *RateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := "ip:" + a.clientIP(r)
+		if caller, ok := callerFromContext(r.Context()); ok {
+			client = "key:" + caller.ID
+		}
+
+		result := limiter.take(client, time.Now())
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limiter.limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+
+		if !result.allowed {
+			a.log(r).Warn("rate limit exceeded", "client", client)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+			a.writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// ceilSeconds rounds a duration up to whole seconds.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR ranges, i.e. "10.0.0.0/8,192.168.1.1".
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy IP: %s", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR: %s", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// WithTrustedProxies trusts the X-Forwarded-For header of requests from the given networks when determining a client's
+// IP address for rate limiting and analytics. It is ignored for requests from any other address, as it can be forged.
+func WithTrustedProxies(proxies []*net.IPNet) Option {
+	return func(a *API) {
+		a.trustedProxies = proxies
+	}
+}
+
+// trustedProxy determines if the given IP belongs to a trusted proxy.
+func (a API) trustedProxy(ip net.IP) bool {
+	for _, proxy := range a.trustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client which made the request. If the request was made by a trusted proxy,
+// the X-Forwarded-For header is walked from the nearest hop and the first untrusted address is the client.
+func (a API) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !a.trustedProxy(ip) {
+		return host
+	}
+
+	// each proxy appends the address it received the request from, so the rightmost entries are the nearest hops
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// the remaining entries cannot be trusted
+			break
+		}
+		ip = hop
+		if !a.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
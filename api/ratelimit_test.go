@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	hashstub "github.com/jemgunay/url-shortener/hash/stub"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestRateLimiter_Take(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{Rate: 1, Burst: 2}, time.Minute)
+	now := time.Date(2021, 12, 28, 21, 25, 48, 0, time.UTC)
+
+	steps := []struct {
+		name       string
+		client     string
+		after      time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{name: "first_burst", client: "a", allowed: true, remaining: 1},
+		{name: "second_burst", client: "a", allowed: true, remaining: 0},
+		{name: "exhausted", client: "a", allowed: false, remaining: 0, retryAfter: time.Second},
+		{name: "other_client", client: "b", allowed: true, remaining: 1},
+		{name: "partial_refill", client: "a", after: time.Millisecond * 500, allowed: false, retryAfter: time.Millisecond * 500},
+		{name: "refilled", client: "a", after: time.Millisecond * 500, allowed: true, remaining: 0},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.after)
+		result := limiter.take(step.client, now)
+		if result.allowed != step.allowed {
+			t.Fatalf("%s: unexpected allowed, expected %t, got %t", step.name, step.allowed, result.allowed)
+		}
+		if result.remaining != step.remaining {
+			t.Fatalf("%s: unexpected remaining, expected %d, got %d", step.name, step.remaining, result.remaining)
+		}
+		if result.retryAfter != step.retryAfter {
+			t.Fatalf("%s: unexpected retry after, expected %s, got %s", step.name, step.retryAfter, result.retryAfter)
+		}
+	}
+
+	// idle clients are evicted once their buckets have refilled
+	limiter.take("c", now.Add(time.Minute))
+	if clients := limiter.clients(); clients != 1 {
+		t.Fatalf("unexpected client count after sweep, expected 1, got %d", clients)
+	}
+}
+
+func TestAPI_RateLimit(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %s", err)
+	}
+	handlers := New(hashstub.Stub{}, store.WithContext(store.New()), WithTrustedProxies(proxies))
+	limiter := NewRateLimiter(RateLimit{Rate: 0.1, Burst: 1}, time.Minute)
+	handler := handlers.RateLimit(limiter, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  string
+		respStatus    int
+		retryAfter    string
+		rateRemaining string
+	}{
+		{name: "direct_client", remoteAddr: "203.0.113.1:1234", respStatus: http.StatusOK, rateRemaining: "0"},
+		{name: "direct_client_limited", remoteAddr: "203.0.113.1:1234", respStatus: http.StatusTooManyRequests, retryAfter: "10", rateRemaining: "0"},
+		// untrusted peers cannot spoof another client
+		{name: "untrusted_forwarded_for", remoteAddr: "203.0.113.1:1234", forwardedFor: "198.51.100.1", respStatus: http.StatusTooManyRequests, retryAfter: "10", rateRemaining: "0"},
+		{name: "trusted_proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: "198.51.100.1", respStatus: http.StatusOK, rateRemaining: "0"},
+		{name: "trusted_proxy_chain", remoteAddr: "10.0.0.1:1234", forwardedFor: "198.51.100.1, 192.168.1.1", respStatus: http.StatusTooManyRequests, retryAfter: "10", rateRemaining: "0"},
+		// only the hops appended by trusted proxies are believed
+		{name: "trusted_proxy_spoofed_hop", remoteAddr: "10.0.0.1:1234", forwardedFor: "203.0.113.9, 198.51.100.2", respStatus: http.StatusOK, rateRemaining: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/123456", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			handler(w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != tt.retryAfter {
+				t.Fatalf("unexpected Retry-After, expected %q, got %q", tt.retryAfter, retryAfter)
+			}
+			if limit := w.Header().Get("RateLimit-Limit"); limit != "1" {
+				t.Fatalf("unexpected RateLimit-Limit, expected 1, got %s", limit)
+			}
+			if remaining := w.Header().Get("RateLimit-Remaining"); remaining != tt.rateRemaining {
+				t.Fatalf("unexpected RateLimit-Remaining, expected %s, got %s", tt.rateRemaining, remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import "net/http"
+
+// rootResponse is the payload returned by the RootHandler.
+type rootResponse struct {
+	Service    string `json:"service"`
+	ShortenURL string `json:"shorten_url"`
+	LinksURL   string `json:"links_url"`
+}
+
+// RootHandler serves the landing response for "/", which describes where to shorten and manage links.
+func (a API) RootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		a.writeMethodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	origin := a.requestOrigin(r).String()
+	a.writeJSON(w, r, http.StatusOK, rootResponse{
+		Service:    "url-shortener",
+		ShortenURL: origin + shortenPath,
+		LinksURL:   origin + linksPath,
+	})
+}
+
+// NotFoundHandler writes a 404 Not Found response for requests which match no route.
+func (a API) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	a.log(r).Debug("no route matches path", "path", r.URL.Path)
+	a.writeNotFound(w, r, "route not found")
+}
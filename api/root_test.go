@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestAPI_RootHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		respStatus int
+		respBody   string
+		respAllow  string
+	}{
+		{
+			name:       "success_get",
+			method:     http.MethodGet,
+			respStatus: http.StatusOK,
+			respBody:   `{"service":"url-shortener","shorten_url":"http://sho.rt/api/v1/shorten","links_url":"http://sho.rt/api/v1/links"}`,
+		},
+		{
+			name:       "success_head",
+			method:     http.MethodHead,
+			respStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid_method",
+			method:     http.MethodPost,
+			respStatus: http.StatusMethodNotAllowed,
+			respBody:   `{"error":{"code":"method_not_allowed","message":"method POST is not allowed"}}`,
+			respAllow:  "GET, HEAD",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers := New(nil, store.WithContext(store.New()))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/", nil)
+			r.Host = "sho.rt"
+
+			serveRoute("/{$}", handlers.RootHandler, w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+			if tt.respBody != "" && w.Body.String() != tt.respBody {
+				t.Fatalf("unexpected body, expected %s, got %s", tt.respBody, w.Body.String())
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.respAllow {
+				t.Fatalf("unexpected allow header, expected %q, got %q", tt.respAllow, allow)
+			}
+		})
+	}
+}
+
+func TestAPI_NotFoundHandler(t *testing.T) {
+	handlers := New(nil, store.WithContext(store.New()))
+
+	w := httptest.NewRecorder()
+	handlers.NotFoundHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/unknown", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+	const expected = `{"error":{"code":"not_found","message":"route not found"}}`
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected body, expected %s, got %s", expected, w.Body.String())
+	}
+}
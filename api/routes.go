@@ -0,0 +1,47 @@
+package api
+
+import "net/http"
+
+// RouteConfig configures the middleware applied by Routes. The zero value registers every route without rate limiting
+// or instrumentation.
+type RouteConfig struct {
+	// ShortenLimiter and RedirectLimiter rate limit the shorten and redirect endpoints, which are not rate limited if
+	// they are nil.
+	ShortenLimiter  *RateLimiter
+	RedirectLimiter *RateLimiter
+	// Instrument wraps the handler of the named endpoint, i.e. to record metrics. Handlers are not wrapped if it is
+	// nil.
+	Instrument func(handler string, next http.HandlerFunc) http.HandlerFunc
+	// Metrics serves "/metrics"; the path is treated as a hash if it is nil.
+	Metrics http.HandlerFunc
+}
+
+// instrument wraps the handler of the named endpoint with the configured Instrument func.
+func (c RouteConfig) instrument(handler string, next http.HandlerFunc) http.HandlerFunc {
+	if c.Instrument == nil {
+		return next
+	}
+	return c.Instrument(handler, next)
+}
+
+// Routes registers every API route with the given mux. "/{$}" serves the RootHandler and "/{hash}" redirects, while
+// any other path (including unknown "/api/v1/..." paths) is served a JSON 404 by the NotFoundHandler.
+func (a API) Routes(mux *http.ServeMux, cfg RouteConfig) {
+	mux.HandleFunc(shortenPath, cfg.instrument("shorten",
+		a.Authenticate(a.RateLimit(cfg.ShortenLimiter, a.ShortenHandler))))
+	mux.HandleFunc(shortenPath+"/batch", cfg.instrument("shorten_batch",
+		a.Authenticate(a.RateLimit(cfg.ShortenLimiter, a.BatchShortenHandler))))
+	mux.HandleFunc(linksPath, a.Authenticate(a.ListHandler))
+	mux.HandleFunc(linksPath+"/{hash}", a.Authenticate(a.LinkHandler))
+	mux.HandleFunc(linksPath+"/{hash}/stats", a.Authenticate(a.StatsHandler))
+	mux.HandleFunc("/api/v1/admin/keys", a.AuthenticateAdmin(a.KeysHandler))
+	mux.HandleFunc("/api/v1/admin/keys/{id}", a.AuthenticateAdmin(a.KeysHandler))
+	mux.HandleFunc("/api/v1/admin/export", cfg.instrument("export", a.RequireAdmin(a.ExportHandler)))
+	mux.HandleFunc("/api/v1/admin/import", cfg.instrument("import", a.RequireAdmin(a.ImportHandler)))
+	if cfg.Metrics != nil {
+		mux.HandleFunc("/metrics", cfg.Metrics)
+	}
+	mux.HandleFunc("/{$}", a.RootHandler)
+	mux.HandleFunc("/{hash}", cfg.instrument("redirect", a.RateLimit(cfg.RedirectLimiter, a.RedirectHandler)))
+	mux.HandleFunc("/", a.NotFoundHandler)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestAPI_Routes(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		metrics      bool
+		respStatus   int
+		respBody     string
+		respLocation string
+	}{
+		{
+			name:       "root",
+			method:     http.MethodGet,
+			path:       "/",
+			respStatus: http.StatusOK,
+			respBody:   `{"service":"url-shortener","shorten_url":"http://sho.rt/api/v1/shorten","links_url":"http://sho.rt/api/v1/links"}`,
+		},
+		{
+			name:         "redirect",
+			method:       http.MethodGet,
+			path:         "/abc123",
+			respStatus:   http.StatusMovedPermanently,
+			respLocation: "https://jemgunay.co.uk",
+		},
+		{
+			name:       "redirect_unknown_hash",
+			method:     http.MethodGet,
+			path:       "/missing",
+			respStatus: http.StatusNotFound,
+			respBody:   `{"error":{"code":"not_found","message":"link not found"}}`,
+		},
+		{
+			name:       "link",
+			method:     http.MethodGet,
+			path:       "/api/v1/links/abc123",
+			respStatus: http.StatusOK,
+			respBody:   `{"short_url":"http://sho.rt/abc123","short_hash":"abc123","original_url":"https://jemgunay.co.uk","created_at":"2024-01-02T03:04:05Z"}`,
+		},
+		{
+			name:       "link_method_not_allowed",
+			method:     http.MethodPost,
+			path:       "/api/v1/links/abc123",
+			respStatus: http.StatusMethodNotAllowed,
+			respBody:   `{"error":{"code":"method_not_allowed","message":"method POST is not allowed"}}`,
+		},
+		{
+			name:       "unknown_api_path",
+			method:     http.MethodGet,
+			path:       "/api/v1/unknown",
+			respStatus: http.StatusNotFound,
+			respBody:   `{"error":{"code":"not_found","message":"route not found"}}`,
+		},
+		{
+			name:       "unknown_nested_api_path",
+			method:     http.MethodGet,
+			path:       "/api/v1/links/abc123/unknown",
+			respStatus: http.StatusNotFound,
+			respBody:   `{"error":{"code":"not_found","message":"route not found"}}`,
+		},
+		{
+			name:       "api_version_path",
+			method:     http.MethodGet,
+			path:       "/api/v1",
+			respStatus: http.StatusNotFound,
+			respBody:   `{"error":{"code":"not_found","message":"route not found"}}`,
+		},
+		{
+			name:       "metrics",
+			method:     http.MethodGet,
+			path:       "/metrics",
+			metrics:    true,
+			respStatus: http.StatusOK,
+			respBody:   "metrics",
+		},
+		{
+			name:       "metrics_disabled",
+			method:     http.MethodGet,
+			path:       "/metrics",
+			respStatus: http.StatusNotFound,
+			respBody:   `{"error":{"code":"not_found","message":"link not found"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := store.New()
+			record := store.Record{URL: "https://jemgunay.co.uk", CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+			if err := storage.Set("abc123", record); err != nil {
+				t.Fatalf("failed to store record: %s", err)
+			}
+
+			var cfg RouteConfig
+			if tt.metrics {
+				cfg.Metrics = func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte("metrics"))
+				}
+			}
+			mux := http.NewServeMux()
+			New(nil, store.WithContext(storage)).Routes(mux, cfg)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Host = "sho.rt"
+			mux.ServeHTTP(w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d", tt.respStatus, w.Code)
+			}
+			if tt.respBody != "" && w.Body.String() != tt.respBody {
+				t.Fatalf("unexpected body, expected %s, got %s", tt.respBody, w.Body.String())
+			}
+			if location := w.Header().Get("Location"); location != tt.respLocation {
+				t.Fatalf("unexpected location, expected %q, got %q", tt.respLocation, location)
+			}
+		})
+	}
+}
@@ -0,0 +1,465 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jemgunay/url-shortener/store"
+)
+
+const (
+	// exportPageSize is the number of records read from storage at a time when exporting.
+	exportPageSize = 500
+	// exportCountTrailer is the trailer which carries the number of exported links once an export is complete.
+	exportCountTrailer = "Export-Count"
+	// maxImportErrors is the maximum number of line errors reported by an import. Further errors are only counted.
+	maxImportErrors = 100
+	// defaultTransferTimeout is the default maximum duration of an export or import.
+	defaultTransferTimeout = time.Minute * 10
+)
+
+// WithTransferTimeout sets the maximum duration of an export, import or batch shorten, which replaces the server's read
+// and write timeouts for those requests since they are sized for requests of a single link. A timeout of zero or less
+// removes the deadlines. Defaults to 10 minutes.
+func WithTransferTimeout(timeout time.Duration) Option {
+	return func(a *API) {
+		a.transferTimeout = timeout
+	}
+}
+
+// extendDeadlines replaces the connection's write deadline, and read deadline if read is set, with the transfer
+// timeout so that a large transfer or batch is not cut off by the server's timeouts. Writers which do not support
+// deadlines (i.e. a httptest.ResponseRecorder) are left unchanged.
+func (a API) extendDeadlines(w http.ResponseWriter, r *http.Request, read bool) {
+	var deadline time.Time
+	if a.transferTimeout > 0 {
+		deadline = time.Now().Add(a.transferTimeout)
+	}
+	controller := http.NewResponseController(w)
+	if read {
+		if err := controller.SetReadDeadline(deadline); err != nil {
+			a.log(r).Debug("failed to extend read deadline", "error", err)
+		}
+	}
+	if err := controller.SetWriteDeadline(deadline); err != nil {
+		a.log(r).Debug("failed to extend write deadline", "error", err)
+	}
+}
+
+// Transfer formats are the formats which links can be exported and imported in.
+const (
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// Conflict policies determine how an import treats links whose hash already exists.
+const (
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictFail      = "fail"
+)
+
+// transferColumns are the CSV columns of exported links, in order. Imported CSV files may order them differently.
+var transferColumns = []string{"hash", "domain", "url", "created_at", "expires_at", "redirect_status", "owner"}
+
+// transferRecord is the representation of a link in exports and imports.
+type transferRecord struct {
+	Hash string `json:"hash"`
+	// Domain is omitted if multiple domains are not enabled.
+	Domain    string     `json:"domain,omitempty"`
+	URL       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RedirectStatus is omitted if the link uses the server's default redirect status.
+	RedirectStatus int    `json:"redirect_status,omitempty"`
+	Owner          string `json:"owner,omitempty"`
+}
+
+// newTransferRecord creates a transferRecord from a record and the key it is stored against.
+func newTransferRecord(key string, record store.Record) transferRecord {
+	domain, hashID := splitStorageKey(key)
+	transfer := transferRecord{
+		Hash:           hashID,
+		Domain:         domain,
+		URL:            record.URL,
+		CreatedAt:      record.CreatedAt,
+		RedirectStatus: record.RedirectStatus,
+		Owner:          record.Owner,
+	}
+	if !record.ExpiresAt.IsZero() {
+		transfer.ExpiresAt = &record.ExpiresAt
+	}
+	return transfer
+}
+
+// csvRow returns the record's fields in the order of transferColumns.
+func (t transferRecord) csvRow() []string {
+	var expiresAt, redirectStatus string
+	if t.ExpiresAt != nil {
+		expiresAt = t.ExpiresAt.Format(time.RFC3339Nano)
+	}
+	if t.RedirectStatus != 0 {
+		redirectStatus = strconv.Itoa(t.RedirectStatus)
+	}
+	return []string{t.Hash, t.Domain, t.URL, t.CreatedAt.Format(time.RFC3339Nano), expiresAt, redirectStatus, t.Owner}
+}
+
+// transferFormat returns the format selected by the "format" query parameter, defaulting to NDJSON. Otherwise, an error
+// response is written and false is returned.
+func (a API) transferFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		return formatNDJSON, true
+	case formatNDJSON, formatCSV:
+		return format, true
+	}
+	a.log(r).Debug("unsupported transfer format", "format", format)
+	a.writeError(w, r, http.StatusBadRequest, codeBadRequest, "format must be ndjson or csv")
+	return "", false
+}
+
+// ExportHandler streams every stored link, ordered by domain and hash, as NDJSON (the default) or CSV with a header
+// row, as selected by the "format" query parameter. The export can be imported with the ImportHandler. It should be
+// wrapped with RequireAdmin. The server's write timeout is replaced with the transfer timeout.
+//
+// The number of exported links is sent in the Export-Count trailer once every link has been written, so an export
+// without the trailer was truncated, i.e. because storage failed part way through.
+func (a API) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	format, ok := a.transferFormat(w, r)
+	if !ok {
+		return
+	}
+	a.extendDeadlines(w, r, false)
+
+	// read the first page before responding, so that storage failures can still be reported
+	entries, next, err := a.storage.ListContext(r.Context(), "", exportPageSize)
+	if err != nil {
+		a.writeStatusError(w, r, a.operationError(r, "failed to list links", err))
+		return
+	}
+
+	var encode func(transferRecord) error
+	var flush func() error
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="links.csv"`)
+		csvWriter := csv.NewWriter(w)
+		encode = func(record transferRecord) error {
+			return csvWriter.Write(record.csvRow())
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+		if err := csvWriter.Write(transferColumns); err != nil {
+			a.log(r).Error("failed to write export", "error", err)
+			return
+		}
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="links.ndjson"`)
+		enc := json.NewEncoder(w)
+		encode = func(record transferRecord) error {
+			return enc.Encode(record)
+		}
+		flush = func() error {
+			return nil
+		}
+	}
+	w.Header().Set("Trailer", exportCountTrailer)
+	w.WriteHeader(http.StatusOK)
+
+	// once the response has started, failures can only truncate it, which is signalled by omitting the trailer
+	var exported int
+	for {
+		for _, entry := range entries {
+			if err := encode(newTransferRecord(entry.Key, entry.Record)); err != nil {
+				a.log(r).Error("failed to write export", "error", err, "exported", exported)
+				return
+			}
+			exported++
+		}
+		if err := flush(); err != nil {
+			a.log(r).Error("failed to write export", "error", err, "exported", exported)
+			return
+		}
+		// flushing is best effort, as not every writer supports it
+		_ = http.NewResponseController(w).Flush()
+
+		if next == "" {
+			break
+		}
+		entries, next, err = a.storage.ListContext(r.Context(), next, exportPageSize)
+		if err != nil {
+			a.log(r).Error("failed to list links, export is truncated", "error", err, "exported", exported)
+			return
+		}
+	}
+	w.Header().Set(exportCountTrailer, strconv.Itoa(exported))
+	a.log(r).Info("exported links", "exported", exported, "format", format)
+}
+
+// importLineError describes why a line of an import was not imported.
+type importLineError struct {
+	// Line is the line number of the link in the imported file, starting from one.
+	Line int `json:"line"`
+	apiError
+}
+
+// importResponse is the payload returned by the ImportHandler.
+type importResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+	// Aborted is set if the import stopped before the end of the file, i.e. on a conflict with the fail policy.
+	Aborted bool `json:"aborted,omitempty"`
+	// Errors describes the first maxImportErrors lines which failed.
+	Errors []importLineError `json:"errors"`
+}
+
+// importReader reads the next link of an imported file and its line number. A failure is returned if only the link's
+// line is invalid, while an error is returned if the file can no longer be read, which is io.EOF once it has been read.
+type importReader func() (int, transferRecord, *statusError, error)
+
+// ImportHandler imports links in the format of the ExportHandler, as selected by the "format" query parameter. Each
+// link is validated as the ShortenHandler would validate it and stored against its hash and domain. Links without a
+// domain are imported into the default domain. The "conflict" query parameter sets the policy for links whose hash
+// already exists: "skip" (the default) leaves the existing link, "overwrite" replaces it and "fail" stops the import,
+// leaving any links already imported.
+//
+// Invalid lines do not stop the import. A 200 OK is returned with the number of links imported, skipped and failed,
+// and the line-level errors of failed links. It should be wrapped with RequireAdmin. The server's read and write
+// timeouts are replaced with the transfer timeout.
+func (a API) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeMethodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	format, ok := a.transferFormat(w, r)
+	if !ok {
+		return
+	}
+	conflict := r.URL.Query().Get("conflict")
+	switch conflict {
+	case "":
+		conflict = conflictSkip
+	case conflictSkip, conflictOverwrite, conflictFail:
+	default:
+		a.log(r).Debug("unsupported conflict policy", "conflict", conflict)
+		a.writeError(w, r, http.StatusBadRequest, codeBadRequest, "conflict must be skip, overwrite or fail")
+		return
+	}
+	a.extendDeadlines(w, r, true)
+
+	var next importReader
+	if format == formatCSV {
+		var err error
+		next, err = newCSVImportReader(r.Body)
+		if err != nil {
+			a.log(r).Debug("invalid CSV import", "error", err)
+			a.writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+	} else {
+		next = newNDJSONImportReader(r.Body)
+	}
+
+	respBody := importResponse{
+		Errors: []importLineError{},
+	}
+	fail := func(line int, failure *statusError) {
+		respBody.Failed++
+		if len(respBody.Errors) < maxImportErrors {
+			respBody.Errors = append(respBody.Errors, importLineError{Line: line, apiError: failure.apiError})
+		}
+	}
+
+	for {
+		line, transfer, failure, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			a.log(r).Debug("failed to read import", "line", line, "error", err)
+			fail(line, newStatusError(http.StatusBadRequest, codeBadRequest, "failed to read line: "+err.Error()))
+			respBody.Aborted = true
+			break
+		}
+		var key string
+		var record store.Record
+		if failure == nil {
+			key, record, failure = a.validateImport(transfer)
+		}
+		if failure != nil {
+			a.log(r).Debug("invalid import line", "line", line, "error", failure.Message)
+			fail(line, failure)
+			continue
+		}
+
+		if conflict == conflictOverwrite {
+			err = a.storage.SetContext(r.Context(), key, record)
+		} else {
+			err = a.storage.SetIfAbsentContext(r.Context(), key, record)
+		}
+		if err == store.ErrKeyExists {
+			if conflict == conflictSkip {
+				respBody.Skipped++
+				continue
+			}
+			a.log(r).Debug("import conflicts with an existing link", "line", line, "key", key)
+			fail(line, newStatusError(http.StatusConflict, codeConflict, "link "+transfer.Hash+" already exists"))
+			respBody.Aborted = true
+			break
+		}
+		if err != nil {
+			fail(line, a.operationError(r, "failed to store imported link", err, "line", line))
+			respBody.Aborted = true
+			break
+		}
+		respBody.Imported++
+	}
+
+	a.log(r).Info("imported links", "imported", respBody.Imported, "skipped", respBody.Skipped, "failed", respBody.Failed,
+		"aborted", respBody.Aborted)
+	a.writeJSON(w, r, http.StatusOK, respBody)
+}
+
+// validateImport validates and normalises an imported link as the ShortenHandler would validate a custom alias,
+// returning the key and record to store it as.
+func (a API) validateImport(transfer transferRecord) (string, store.Record, *statusError) {
+	if err := validateAlias(transfer.Hash); err != nil {
+		return "", store.Record{}, newStatusError(http.StatusBadRequest, codeBadRequest, err.Error())
+	}
+	domain, ok := a.resolveDomain(transfer.Domain)
+	if !ok {
+		return "", store.Record{}, newValidationError(fieldError{Field: "domain", Reason: "is not an allowed domain"})
+	}
+	originalURL, invalid := normaliseURL("url", transfer.URL)
+	if invalid != nil {
+		return "", store.Record{}, newValidationError(*invalid)
+	}
+	if invalid := validateRedirectStatus("redirect_status", transfer.RedirectStatus); invalid != nil {
+		return "", store.Record{}, newValidationError(*invalid)
+	}
+
+	record := store.Record{
+		URL:            originalURL,
+		CreatedAt:      transfer.CreatedAt.UTC(),
+		RedirectStatus: transfer.RedirectStatus,
+		Owner:          transfer.Owner,
+	}
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+	if transfer.ExpiresAt != nil {
+		record.ExpiresAt = transfer.ExpiresAt.UTC()
+	}
+	return storageKey(domain, transfer.Hash), record, nil
+}
+
+// newNDJSONImportReader creates an importReader of newline delimited JSON links. Blank lines are skipped.
+func newNDJSONImportReader(body io.Reader) importReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 4096), maxBatchLineBytes)
+	var line int
+
+	return func() (int, transferRecord, *statusError, error) {
+		for scanner.Scan() {
+			line++
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			transfer := transferRecord{}
+			if err := json.Unmarshal(raw, &transfer); err != nil {
+				return line, transferRecord{}, newStatusError(http.StatusBadRequest, codeBadRequest, "line must be a valid JSON link: "+err.Error()), nil
+			}
+			return line, transfer, nil, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return line + 1, transferRecord{}, nil, err
+		}
+		return line, transferRecord{}, nil, io.EOF
+	}
+}
+
+// newCSVImportReader creates an importReader of CSV links. The first row must be a header naming the columns, which
+// may be in any order; the hash and url columns are required and unknown columns are ignored.
+func newCSVImportReader(body io.Reader) (importReader, error) {
+	csvReader := csv.NewReader(body)
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %s", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, required := range []string{"hash", "url"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+	lastLine := 1
+
+	return func() (int, transferRecord, *statusError, error) {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			return 0, transferRecord{}, nil, io.EOF
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return parseErr.StartLine, transferRecord{}, newStatusError(http.StatusBadRequest, codeBadRequest, "line must be a valid CSV row: "+parseErr.Err.Error()), nil
+			}
+			return lastLine + 1, transferRecord{}, nil, err
+		}
+		line, _ := csvReader.FieldPos(0)
+		lastLine = line
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return row[i]
+			}
+			return ""
+		}
+		transfer := transferRecord{
+			Hash:   field("hash"),
+			Domain: field("domain"),
+			URL:    field("url"),
+			Owner:  field("owner"),
+		}
+		if raw := field("created_at"); raw != "" {
+			if transfer.CreatedAt, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+				return line, transferRecord{}, newValidationError(fieldError{Field: "created_at", Reason: "must be an RFC 3339 time"}), nil
+			}
+		}
+		if raw := field("expires_at"); raw != "" {
+			expiresAt, err := time.Parse(time.RFC3339Nano, raw)
+			if err != nil {
+				return line, transferRecord{}, newValidationError(fieldError{Field: "expires_at", Reason: "must be an RFC 3339 time"}), nil
+			}
+			transfer.ExpiresAt = &expiresAt
+		}
+		if raw := field("redirect_status"); raw != "" {
+			if transfer.RedirectStatus, err = strconv.Atoi(raw); err != nil {
+				return line, transferRecord{}, newValidationError(fieldError{Field: "redirect_status", Reason: "must be an integer"}), nil
+			}
+		}
+		return line, transfer, nil, nil
+	}, nil
+}
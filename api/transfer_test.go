@@ -0,0 +1,346 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/url-shortener/hash"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestAPI_ExportImport(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := map[string]store.Record{
+		"jemgunay.co.uk/blog": {URL: "https://jemgunay.co.uk/blog", CreatedAt: createdAt, Owner: "alice"},
+		"jemgunay.co.uk/cv": {
+			URL:            "https://jemgunay.co.uk/cv?a=1,2",
+			CreatedAt:      createdAt,
+			ExpiresAt:      createdAt.Add(time.Hour),
+			RedirectStatus: http.StatusPermanentRedirect,
+		},
+		"jem.gy/blog": {URL: "https://jemgunay.co.uk/blog", CreatedAt: createdAt, Owner: "bob"},
+	}
+
+	for _, format := range []string{formatNDJSON, formatCSV} {
+		t.Run(format, func(t *testing.T) {
+			source := store.New()
+			for key, record := range records {
+				source.Set(key, record)
+			}
+			domains := WithDomains([]string{"jemgunay.co.uk", "jem.gy"})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/export?format="+format, nil)
+			New(hash.WithContext(hash.Generator{}), store.WithContext(source), domains).ExportHandler(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected export status, expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+			}
+			if count := w.Result().Trailer.Get(exportCountTrailer); count != strconv.Itoa(len(records)) {
+				t.Fatalf("unexpected %s trailer, expected %d, got %q", exportCountTrailer, len(records), count)
+			}
+
+			// importing the export recreates every link
+			destination := store.New()
+			w2 := httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodPost, "/api/v1/admin/import?format="+format, w.Body)
+			New(hash.WithContext(hash.Generator{}), store.WithContext(destination), domains).ImportHandler(w2, r)
+			if w2.Code != http.StatusOK {
+				t.Fatalf("unexpected import status, expected %d, got %d: %s", http.StatusOK, w2.Code, w2.Body)
+			}
+			resp := importResponse{}
+			if err := json.Unmarshal(w2.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to JSON unmarshal response body: %s", err)
+			}
+			if resp.Imported != len(records) || resp.Failed != 0 || resp.Skipped != 0 {
+				t.Fatalf("unexpected import counts: %s", w2.Body)
+			}
+
+			for key, expected := range records {
+				record, err := destination.Get(key)
+				if err != nil {
+					t.Fatalf("failed to get imported link %s: %s", key, err)
+				}
+				if record.URL != expected.URL || !record.CreatedAt.Equal(expected.CreatedAt) || !record.ExpiresAt.Equal(expected.ExpiresAt) ||
+					record.RedirectStatus != expected.RedirectStatus || record.Owner != expected.Owner {
+					t.Fatalf("unexpected imported link %s, expected %+v, got %+v", key, expected, record)
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_ImportHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		reqBody    string
+		respStatus int
+		imported   int
+		skipped    int
+		aborted    bool
+		// errorLines are the lines expected to fail, with their error codes
+		errorLines map[int]string
+		stored     map[string]string
+	}{
+		{
+			name:       "skip_conflicts",
+			target:     "/api/v1/admin/import",
+			reqBody:    "{\"hash\": \"taken\", \"url\": \"https://jemgunay.co.uk/new\"}\n{\"hash\": \"blog\", \"url\": \"https://jemgunay.co.uk/blog\"}\n",
+			respStatus: http.StatusOK,
+			imported:   1,
+			skipped:    1,
+			stored:     map[string]string{"taken": "https://jemgunay.co.uk/taken", "blog": "https://jemgunay.co.uk/blog"},
+		},
+		{
+			name:       "overwrite_conflicts",
+			target:     "/api/v1/admin/import?conflict=overwrite",
+			reqBody:    "{\"hash\": \"taken\", \"url\": \"https://jemgunay.co.uk/new\"}\n",
+			respStatus: http.StatusOK,
+			imported:   1,
+			stored:     map[string]string{"taken": "https://jemgunay.co.uk/new"},
+		},
+		{
+			name:       "fail_on_conflict",
+			target:     "/api/v1/admin/import?conflict=fail",
+			reqBody:    "{\"hash\": \"blog\", \"url\": \"https://jemgunay.co.uk/blog\"}\n{\"hash\": \"taken\", \"url\": \"https://jemgunay.co.uk/new\"}\n{\"hash\": \"cv\", \"url\": \"https://jemgunay.co.uk/cv\"}\n",
+			respStatus: http.StatusOK,
+			imported:   1,
+			aborted:    true,
+			errorLines: map[int]string{2: codeConflict},
+			stored:     map[string]string{"blog": "https://jemgunay.co.uk/blog", "taken": "https://jemgunay.co.uk/taken"},
+		},
+		{
+			name:       "invalid_ndjson_lines",
+			target:     "/api/v1/admin/import?format=ndjson",
+			reqBody:    "{\"hash\": \"blog\", \"url\": \"javascript:alert(1)\"}\n\n{\"hash\": \"api\", \"url\": \"https://jemgunay.co.uk\"}\n{\"hash\": \n{\"hash\": \"cv\", \"url\": \"https://jemgunay.co.uk/cv\", \"redirect_status\": 200}\n{\"hash\": \"cv\", \"url\": \"jemgunay.co.uk/cv\"}\n{\"hash\": \"cv\", \"url\": \"https://jemgunay.co.uk/cv\"}\n",
+			respStatus: http.StatusOK,
+			imported:   1,
+			errorLines: map[int]string{1: codeValidationFailed, 3: codeBadRequest, 4: codeBadRequest, 5: codeValidationFailed, 6: codeValidationFailed},
+			stored:     map[string]string{"cv": "https://jemgunay.co.uk/cv"},
+		},
+		{
+			name:       "invalid_csv_lines",
+			target:     "/api/v1/admin/import?format=csv",
+			reqBody:    "url,hash,unknown\nhttps://jemgunay.co.uk/blog,blog,x\nhttps://jemgunay.co.uk,bad hash,x\nhttps://jemgunay.co.uk/cv\n",
+			respStatus: http.StatusOK,
+			imported:   1,
+			errorLines: map[int]string{3: codeBadRequest, 4: codeBadRequest},
+			stored:     map[string]string{"blog": "https://jemgunay.co.uk/blog"},
+		},
+		{
+			name:       "csv_missing_columns",
+			target:     "/api/v1/admin/import?format=csv",
+			reqBody:    "hash\nblog\n",
+			respStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid_format",
+			target:     "/api/v1/admin/import?format=xml",
+			respStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid_conflict",
+			target:     "/api/v1/admin/import?conflict=merge",
+			respStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeStub := store.New()
+			storeStub.Set("taken", store.Record{URL: "https://jemgunay.co.uk/taken"})
+			handlers := New(hash.WithContext(hash.Generator{}), store.WithContext(storeStub))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.reqBody))
+			handlers.ImportHandler(w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d: %s", tt.respStatus, w.Code, w.Body)
+			}
+			if tt.respStatus != http.StatusOK {
+				return
+			}
+
+			resp := importResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to JSON unmarshal response body: %s", err)
+			}
+			if resp.Imported != tt.imported || resp.Skipped != tt.skipped || resp.Failed != len(tt.errorLines) || resp.Aborted != tt.aborted {
+				t.Fatalf("unexpected import result: %s", w.Body)
+			}
+			for _, lineErr := range resp.Errors {
+				if code, ok := tt.errorLines[lineErr.Line]; !ok || code != lineErr.Code {
+					t.Fatalf("unexpected error for line %d: %+v", lineErr.Line, lineErr)
+				}
+			}
+			for key, url := range tt.stored {
+				record, err := storeStub.Get(key)
+				if err != nil {
+					t.Fatalf("failed to get stored link %s: %s", key, err)
+				}
+				if record.URL != url {
+					t.Fatalf("unexpected URL for %s, expected %s, got %s", key, url, record.URL)
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_RequireAdmin(t *testing.T) {
+	keys := NewKeyStore()
+	_, userKey, err := keys.Create("alice", false)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	_, adminKey, err := keys.Create("admin", true)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		keys       *KeyStore
+		key        string
+		respStatus int
+	}{
+		{name: "auth_disabled", respStatus: http.StatusOK},
+		{name: "missing_key", keys: keys, respStatus: http.StatusUnauthorized},
+		{name: "user_key", keys: keys, key: userKey, respStatus: http.StatusForbidden},
+		{name: "admin_key", keys: keys, key: adminKey, respStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if tt.keys != nil {
+				opts = append(opts, WithKeyStore(tt.keys))
+			}
+			handlers := New(hash.WithContext(hash.Generator{}), store.WithContext(store.New()), opts...)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/export", nil)
+			if tt.key != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			handlers.RequireAdmin(handlers.ExportHandler)(w, r)
+
+			if w.Code != tt.respStatus {
+				t.Fatalf("unexpected status, expected %d, got %d: %s", tt.respStatus, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+// failingListStorage fails to list pages of the wrapped storage after the first.
+type failingListStorage struct {
+	store.ContextStorage
+}
+
+// ListContext lists the first page of the wrapped storage, and fails to list any other page.
+func (s failingListStorage) ListContext(ctx context.Context, cursor string, limit int) ([]store.Entry, string, error) {
+	if cursor != "" {
+		return nil, "", errors.New("storage unavailable")
+	}
+	return s.ContextStorage.ListContext(ctx, cursor, limit)
+}
+
+func TestAPI_ExportHandler_Truncated(t *testing.T) {
+	storage := store.New()
+	for i := 0; i < exportPageSize+1; i++ {
+		storage.Set(fmt.Sprintf("%05d", i), store.Record{URL: "https://jemgunay.co.uk"})
+	}
+	handlers := New(hash.WithContext(hash.Generator{}), failingListStorage{ContextStorage: store.WithContext(storage)})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/export", nil)
+	handlers.ExportHandler(w, r)
+
+	// the response has started by the time the second page fails, so only the trailer reveals the truncation
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusOK, w.Code)
+	}
+	if lines := strings.Count(w.Body.String(), "\n"); lines != exportPageSize {
+		t.Fatalf("unexpected number of exported links, expected %d, got %d", exportPageSize, lines)
+	}
+	resp := w.Result()
+	if declared := resp.Header.Get("Trailer"); declared != exportCountTrailer {
+		t.Fatalf("expected the %s trailer to be declared", exportCountTrailer)
+	}
+	if count := resp.Trailer.Get(exportCountTrailer); count != "" {
+		t.Fatalf("expected no %s trailer for a truncated export, got %q", exportCountTrailer, count)
+	}
+}
+
+// slowListStorage delays each page listed from the wrapped storage.
+type slowListStorage struct {
+	store.ContextStorage
+	delay time.Duration
+}
+
+// ListContext lists a page of the wrapped storage after the delay.
+func (s slowListStorage) ListContext(ctx context.Context, cursor string, limit int) ([]store.Entry, string, error) {
+	time.Sleep(s.delay)
+	return s.ContextStorage.ListContext(ctx, cursor, limit)
+}
+
+func TestAPI_ExportImport_ServerTimeouts(t *testing.T) {
+	storage := store.New()
+	for i := 0; i < exportPageSize*2+1; i++ {
+		storage.Set(fmt.Sprintf("%05d", i), store.Record{URL: "https://jemgunay.co.uk"})
+	}
+	handlers := New(hash.WithContext(hash.Generator{}), slowListStorage{ContextStorage: store.WithContext(storage), delay: time.Millisecond * 100})
+
+	mux := http.NewServeMux()
+	handlers.Routes(mux, RouteConfig{})
+	server := httptest.NewUnstartedServer(mux)
+	// each transfer takes longer than the server's timeouts
+	server.Config.ReadTimeout = time.Millisecond * 150
+	server.Config.WriteTimeout = time.Millisecond * 150
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/admin/export")
+	if err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to read export: %s", err)
+	}
+	if lines := strings.Count(string(body), "\n"); lines != exportPageSize*2+1 {
+		t.Fatalf("unexpected number of exported links, expected %d, got %d", exportPageSize*2+1, lines)
+	}
+
+	// stream the import body slower than the read timeout
+	reqBody, reqWriter := io.Pipe()
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(time.Millisecond * 100)
+			fmt.Fprintf(reqWriter, `{"hash": "slow%d", "url": "https://jemgunay.co.uk"}`+"\n", i)
+		}
+		reqWriter.Close()
+	}()
+	resp, err = http.Post(server.URL+"/api/v1/admin/import", "application/x-ndjson", reqBody)
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+	defer resp.Body.Close()
+	importResp := importResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&importResp); err != nil {
+		t.Fatalf("failed to JSON decode import response: %s", err)
+	}
+	if importResp.Imported != 3 {
+		t.Fatalf("unexpected import response: %+v", importResp)
+	}
+}
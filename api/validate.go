@@ -0,0 +1,132 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxURLLength is the maximum permitted length of an original URL, before and after normalisation.
+const maxURLLength = 2048
+
+// allowedSchemes are the URL schemes which links may redirect to.
+var allowedSchemes = map[string]struct{}{
+	"http":  {},
+	"https": {},
+}
+
+// defaultPorts are the ports implied by each allowed scheme, which are stripped during normalisation.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// redirectStatuses are the HTTP status codes which links may redirect with.
+var redirectStatuses = map[int]struct{}{
+	http.StatusMovedPermanently:  {},
+	http.StatusFound:             {},
+	http.StatusTemporaryRedirect: {},
+	http.StatusPermanentRedirect: {},
+}
+
+// ValidRedirectStatus determines if the given HTTP status code can be used to redirect to a link's original URL, i.e.
+// 301, 302, 307 or 308.
+func ValidRedirectStatus(status int) bool {
+	_, ok := redirectStatuses[status]
+	return ok
+}
+
+// percentEncoding matches percent-encoded octets so that their hex digits can be normalised to upper case.
+var percentEncoding = regexp.MustCompile(`%[0-9a-fA-F]{2}`)
+
+// fieldError describes why a field of a request payload is invalid.
+type fieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// String formats the fieldError for logging.
+func (e fieldError) String() string {
+	return e.Field + " " + e.Reason
+}
+
+// writeValidationError writes a 422 Unprocessable Entity response describing each invalid field.
+func (a API) writeValidationError(w http.ResponseWriter, r *http.Request, errs ...fieldError) {
+	a.writeError(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "request payload failed validation", errs...)
+}
+
+// normaliseURL validates that a URL is suitable to redirect to and returns it in a normalised form. The URL must be
+// absolute, use an allowed scheme, have a host and not embed credentials. Normalisation lower-cases the scheme and
+// host, strips the scheme's default port and upper-cases percent-encoded octets. If the URL is invalid, a fieldError
+// for the given field is returned.
+func normaliseURL(field, rawURL string) (string, *fieldError) {
+	invalid := func(format string, args ...interface{}) (string, *fieldError) {
+		return "", &fieldError{Field: field, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return invalid("must not be empty")
+	}
+	if len(rawURL) > maxURLLength {
+		return invalid("must not exceed %d characters", maxURLLength)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return invalid("must be a valid URL")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "" {
+		return invalid("must be an absolute URL including a scheme")
+	}
+	if _, ok := allowedSchemes[u.Scheme]; !ok {
+		return invalid("scheme %q is not allowed", u.Scheme)
+	}
+	if u.Opaque != "" || u.Host == "" {
+		return invalid("must include a host")
+	}
+	if u.User != nil {
+		return invalid("must not include credentials")
+	}
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if host == "" {
+		return invalid("must include a host")
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 literals must be bracketed
+		host = "[" + host + "]"
+	}
+	if port != "" && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+	u.Host = host
+
+	u.RawPath = upperPercentEncoding(u.RawPath)
+	u.RawQuery = upperPercentEncoding(u.RawQuery)
+	u.RawFragment = upperPercentEncoding(u.RawFragment)
+
+	normalised := u.String()
+	if len(normalised) > maxURLLength {
+		return invalid("must not exceed %d characters", maxURLLength)
+	}
+	return normalised, nil
+}
+
+// upperPercentEncoding upper-cases the hex digits of percent-encoded octets, i.e. "%2f" becomes "%2F".
+func upperPercentEncoding(s string) string {
+	return percentEncoding.ReplaceAllStringFunc(s, strings.ToUpper)
+}
+
+// validateRedirectStatus ensures a requested redirect status is supported. A zero status is valid and indicates the
+// server's default should be used.
+func validateRedirectStatus(field string, status int) *fieldError {
+	if status == 0 || ValidRedirectStatus(status) {
+		return nil
+	}
+	return &fieldError{Field: field, Reason: "must be one of 301, 302, 307 or 308"}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormaliseURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		rawURL     string
+		normalised string
+		reason     string
+	}{
+		{
+			name:       "success_unchanged",
+			rawURL:     "https://jemgunay.co.uk/blog?page=2#top",
+			normalised: "https://jemgunay.co.uk/blog?page=2#top",
+		},
+		{
+			name:       "success_case",
+			rawURL:     "HTTPS://JemGunay.co.UK/Blog",
+			normalised: "https://jemgunay.co.uk/Blog",
+		},
+		{
+			name:       "success_default_port",
+			rawURL:     "http://jemgunay.co.uk:80/blog",
+			normalised: "http://jemgunay.co.uk/blog",
+		},
+		{
+			name:       "success_non_default_port",
+			rawURL:     "https://jemgunay.co.uk:8443/blog",
+			normalised: "https://jemgunay.co.uk:8443/blog",
+		},
+		{
+			name:       "success_percent_encoding",
+			rawURL:     "https://jemgunay.co.uk/a%2fb?q=%e2%9c%93",
+			normalised: "https://jemgunay.co.uk/a%2Fb?q=%E2%9C%93",
+		},
+		{
+			name:       "success_ipv6",
+			rawURL:     "https://[2001:DB8::1]:443/",
+			normalised: "https://[2001:db8::1]/",
+		},
+		{
+			name:       "success_whitespace",
+			rawURL:     "  https://jemgunay.co.uk  ",
+			normalised: "https://jemgunay.co.uk",
+		},
+		{
+			name:   "empty",
+			rawURL: "",
+			reason: "must not be empty",
+		},
+		{
+			name:   "javascript_scheme",
+			rawURL: "javascript:alert(1)",
+			reason: `scheme "javascript" is not allowed`,
+		},
+		{
+			name:   "relative_path",
+			rawURL: "/some/path",
+			reason: "must be an absolute URL including a scheme",
+		},
+		{
+			name:   "missing_host",
+			rawURL: "https:///path",
+			reason: "must include a host",
+		},
+		{
+			name:   "credentials",
+			rawURL: "https://trusted.com@evil.com/",
+			reason: "must not include credentials",
+		},
+		{
+			name:   "too_long",
+			rawURL: "https://jemgunay.co.uk/" + strings.Repeat("a", maxURLLength),
+			reason: "must not exceed 2048 characters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalised, invalid := normaliseURL("original_url", tt.rawURL)
+			if tt.reason != "" {
+				if invalid == nil {
+					t.Fatalf("expected validation error %q, got normalised URL %s", tt.reason, normalised)
+				}
+				if invalid.Field != "original_url" || invalid.Reason != tt.reason {
+					t.Fatalf("unexpected validation error, expected %q, got %+v", tt.reason, invalid)
+				}
+				return
+			}
+
+			if invalid != nil {
+				t.Fatalf("unexpected validation error: %s", invalid)
+			}
+			if normalised != tt.normalised {
+				t.Fatalf("expected: %s, got: %s", tt.normalised, normalised)
+			}
+		})
+	}
+}
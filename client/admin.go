@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Transfer formats are the formats which links can be exported and imported in.
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// Conflict policies determine how an import treats links whose hash already exists.
+const (
+	// ConflictSkip leaves the existing link.
+	ConflictSkip = "skip"
+	// ConflictOverwrite replaces the existing link.
+	ConflictOverwrite = "overwrite"
+	// ConflictFail stops the import, leaving any links already imported.
+	ConflictFail = "fail"
+)
+
+// ErrIncompleteExport is returned by the stream of an Export instead of io.EOF if the server did not finish the
+// export, i.e. because it failed part way through.
+var ErrIncompleteExport = errors.New("export is incomplete")
+
+// exportCountTrailer is the trailer which the server sends once an export is complete.
+const exportCountTrailer = "Export-Count"
+
+// Key is an API key, without the key itself.
+type Key struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	Admin bool   `json:"admin"`
+}
+
+// CreatedKey is a newly created API key, which is the only time the key itself is revealed.
+type CreatedKey struct {
+	Key
+	Secret string `json:"key"`
+}
+
+// CreateKey creates an API key for an owner. It requires an admin API key.
+func (c *Client) CreateKey(ctx context.Context, owner string, admin bool) (CreatedKey, error) {
+	payload := struct {
+		Owner string `json:"owner"`
+		Admin bool   `json:"admin"`
+	}{
+		Owner: owner,
+		Admin: admin,
+	}
+	key := CreatedKey{}
+	if err := c.doJSON(ctx, request{method: http.MethodPost, path: "/api/v1/admin/keys", body: payload}, &key); err != nil {
+		return CreatedKey{}, err
+	}
+	return key, nil
+}
+
+// ListKeys lists every API key. It requires an admin API key.
+func (c *Client) ListKeys(ctx context.Context) ([]Key, error) {
+	var keys []Key
+	if err := c.doJSON(ctx, request{method: http.MethodGet, path: "/api/v1/admin/keys", idempotent: true}, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeKey revokes an API key by its ID. It requires an admin API key.
+func (c *Client) RevokeKey(ctx context.Context, id string) error {
+	return c.doJSON(ctx, request{method: http.MethodDelete, path: "/api/v1/admin/keys/" + id, idempotent: true}, nil)
+}
+
+// Export streams every link in the given format (FormatNDJSON or FormatCSV). The caller must close the returned
+// stream, which can be imported with Import. Reading the stream returns ErrIncompleteExport rather than io.EOF if the
+// export was truncated. It requires an admin API key if the server has API keys enabled.
+func (c *Client) Export(ctx context.Context, format string) (io.ReadCloser, error) {
+	req := request{
+		method:     http.MethodGet,
+		path:       "/api/v1/admin/export",
+		query:      url.Values{"format": {format}},
+		idempotent: true,
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return exportStream{resp: resp}, nil
+}
+
+// exportStream is the body of an export response, which is only complete if the server sent the export count trailer.
+type exportStream struct {
+	resp *http.Response
+}
+
+// Read reads the export, returning ErrIncompleteExport at the end of the body if the trailer is missing. Trailers are
+// only available once the body has been read to the end.
+func (e exportStream) Read(p []byte) (int, error) {
+	n, err := e.resp.Body.Read(p)
+	if err == io.EOF && e.resp.Trailer.Get(exportCountTrailer) == "" {
+		return n, ErrIncompleteExport
+	}
+	return n, err
+}
+
+// Close closes the export response body.
+func (e exportStream) Close() error {
+	return e.resp.Body.Close()
+}
+
+// ImportLineError describes why a line of an import was not imported.
+type ImportLineError struct {
+	// Line is the line number of the link in the imported file, starting from one.
+	Line int `json:"line"`
+	Error
+}
+
+// ImportResponse is the outcome of an import.
+type ImportResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+	// Aborted is set if the import stopped before the end of the file, i.e. on a conflict with ConflictFail.
+	Aborted bool `json:"aborted,omitempty"`
+	// Errors describes the first lines which failed; the server limits how many are reported.
+	Errors []ImportLineError `json:"errors"`
+}
+
+// Import streams links in the given format (FormatNDJSON or FormatCSV) to the server, treating links which already
+// exist with the given conflict policy. Invalid lines do not fail the import, so they are described by the
+// ImportResponse. The request is not retried as the stream can only be read once. It requires an admin API key if the
+// server has API keys enabled.
+func (c *Client) Import(ctx context.Context, format, conflict string, links io.Reader) (ImportResponse, error) {
+	contentType := "application/x-ndjson"
+	if format == FormatCSV {
+		contentType = "text/csv"
+	}
+	req := request{
+		method:      http.MethodPost,
+		path:        "/api/v1/admin/import",
+		query:       url.Values{"format": {format}, "conflict": {conflict}},
+		body:        links,
+		contentType: contentType,
+	}
+
+	resp := ImportResponse{}
+	if err := c.doJSON(ctx, req, &resp); err != nil {
+		return ImportResponse{}, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/url-shortener/api"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestClient_Keys(t *testing.T) {
+	keys := api.NewKeyStore()
+	_, adminKey, err := keys.Create("admin", true)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	c := newTestClient(t, newTestServer(t, store.New(), nil, api.WithKeyStore(keys)), WithAPIKey(adminKey))
+	ctx := context.Background()
+
+	created, err := c.CreateKey(ctx, "alice", false)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	if created.ID == "" || created.Owner != "alice" || created.Admin || created.Secret == "" {
+		t.Fatalf("unexpected created key: %+v", created)
+	}
+
+	// the created key authenticates requests
+	alice := newTestClient(t, newTestServer(t, store.New(), nil, api.WithKeyStore(keys)), WithAPIKey(created.Secret))
+	if _, err := alice.ListLinks(ctx, ListRequest{}); err != nil {
+		t.Fatalf("failed to list links with created key: %s", err)
+	}
+
+	listed, err := c.ListKeys(ctx)
+	if err != nil {
+		t.Fatalf("failed to list keys: %s", err)
+	}
+	if len(listed) != 2 {
+		t.Fatalf("unexpected keys: %+v", listed)
+	}
+
+	if err := c.RevokeKey(ctx, created.ID); err != nil {
+		t.Fatalf("failed to revoke key: %s", err)
+	}
+	if err := c.RevokeKey(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected revoked key to be not found, got %v", err)
+	}
+}
+
+func TestClient_ExportImport(t *testing.T) {
+	source := store.New()
+	source.Set("blog", store.Record{URL: "https://jemgunay.co.uk/blog", CreatedAt: time.Now().UTC()})
+	source.Set("cv", store.Record{URL: "https://jemgunay.co.uk/cv", CreatedAt: time.Now().UTC()})
+	destination := store.New()
+	destination.Set("cv", store.Record{URL: "https://jemgunay.co.uk/old-cv"})
+
+	ctx := context.Background()
+	from := newTestClient(t, newTestServer(t, source, nil))
+	to := newTestClient(t, newTestServer(t, destination, nil))
+
+	for _, format := range []string{FormatNDJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			export, err := from.Export(ctx, format)
+			if err != nil {
+				t.Fatalf("failed to export: %s", err)
+			}
+			defer export.Close()
+
+			resp, err := to.Import(ctx, format, ConflictSkip, export)
+			if err != nil {
+				t.Fatalf("failed to import: %s", err)
+			}
+			// the existing link is skipped, and already imported links are skipped on the second format
+			if resp.Imported+resp.Skipped != 2 || resp.Failed != 0 {
+				t.Fatalf("unexpected import response: %+v", resp)
+			}
+		})
+	}
+
+	if record, err := destination.Get("cv"); err != nil || record.URL != "https://jemgunay.co.uk/old-cv" {
+		t.Fatalf("expected existing link to be skipped, got %+v: %v", record, err)
+	}
+
+	// line errors are reported
+	resp, err := to.Import(ctx, FormatNDJSON, ConflictFail, strings.NewReader("{\"hash\": \"cv\", \"url\": \"https://jemgunay.co.uk/cv\"}\n"))
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+	if !resp.Aborted || len(resp.Errors) != 1 || resp.Errors[0].Line != 1 || resp.Errors[0].Code != "conflict" {
+		t.Fatalf("unexpected import response: %+v", resp)
+	}
+
+	if _, err := from.Export(ctx, "xml"); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("expected bad request for unsupported format, got %v", err)
+	}
+	if _, err := to.Import(ctx, FormatCSV, ConflictSkip, io.LimitReader(strings.NewReader("hash\n"), 5)); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("expected bad request for a CSV file without a url column, got %v", err)
+	}
+}
+
+func TestClient_ExportIncomplete(t *testing.T) {
+	tests := []struct {
+		name    string
+		trailer string
+		err     error
+	}{
+		{
+			name:    "complete",
+			trailer: "1",
+		},
+		{
+			name: "truncated",
+			err:  ErrIncompleteExport,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", exportCountTrailer)
+				w.Write([]byte("{\"hash\": \"blog\", \"url\": \"https://jemgunay.co.uk/blog\"}\n"))
+				if tt.trailer != "" {
+					w.Header().Set(exportCountTrailer, tt.trailer)
+				}
+			}))
+			defer server.Close()
+
+			export, err := newTestClient(t, server).Export(context.Background(), FormatNDJSON)
+			if err != nil {
+				t.Fatalf("failed to export: %s", err)
+			}
+			defer export.Close()
+
+			body, err := io.ReadAll(export)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("unexpected error, expected %v, got %v", tt.err, err)
+			}
+			// the links which were exported are still readable
+			if !strings.Contains(string(body), `"hash": "blog"`) {
+				t.Fatalf("unexpected export: %s", body)
+			}
+		})
+	}
+}
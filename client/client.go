@@ -0,0 +1,264 @@
+// Package client implements a Go client for the URL shortener's HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries    = 2
+	defaultBackoff    = time.Millisecond * 200
+	defaultMaxBackoff = time.Second * 5
+	userAgent         = "url-shortener-client"
+)
+
+// Client performs requests against a URL shortener server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	apiKey     string
+	userAgent  string
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// Option is used to apply optional configuration to a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client which requests are performed with. Defaults to a client without a timeout, so
+// requests should be bounded by their context.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey sets the API key which requests are authenticated with, if the server requires one.
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+// WithUserAgent sets the User-Agent header of requests.
+func WithUserAgent(agent string) Option {
+	return func(c *Client) {
+		c.userAgent = agent
+	}
+}
+
+// WithRetries sets the number of times a failed request is retried, and the initial backoff between attempts which
+// doubles after each attempt up to maxBackoff. Defaults to 2 retries with a 200ms initial and 5s maximum backoff; zero
+// retries disables them.
+func WithRetries(retries int, backoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// New creates a Client for the server at the given base URL, i.e. "https://sho.rt".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q: must be an absolute http or https URL", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    parsed,
+		httpClient: &http.Client{},
+		userAgent:  userAgent,
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// request describes a request to the server.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	// body is JSON encoded unless it is an io.Reader, which is streamed as is.
+	body        interface{}
+	contentType string
+	// anonymous requests are sent without the API key.
+	anonymous bool
+	// idempotent requests may be retried after any failure, as repeating them has no further effect. Other requests
+	// are only retried if the server did not process them.
+	idempotent bool
+	// noRedirect returns redirect responses rather than following them.
+	noRedirect bool
+}
+
+// do performs a request, retrying it if it fails with a retryable error. A response with a status of 400 or above is
+// returned as an *Error. The caller must close the returned response's body.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	target := *c.baseURL
+	target.Path += req.path
+	target.RawQuery = req.query.Encode()
+
+	// buffer JSON bodies so that they can be replayed; streamed bodies can only be sent once
+	var body []byte
+	var stream io.Reader
+	contentType := req.contentType
+	switch reqBody := req.body.(type) {
+	case nil:
+	case io.Reader:
+		stream = reqBody
+	default:
+		var err error
+		if body, err = json.Marshal(reqBody); err != nil {
+			return nil, fmt.Errorf("failed to JSON encode request body: %w", err)
+		}
+		contentType = "application/json"
+	}
+
+	httpClient := c.httpClient
+	if req.noRedirect {
+		noRedirect := *c.httpClient
+		noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		httpClient = &noRedirect
+	}
+
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader = stream
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, target.String(), reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		for name, values := range req.header {
+			httpReq.Header[name] = values
+		}
+		if contentType != "" {
+			httpReq.Header.Set("Content-Type", contentType)
+		}
+		if c.apiKey != "" && !req.anonymous {
+			httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+		}
+		httpReq.Header.Set("User-Agent", c.userAgent)
+
+		resp, err := httpClient.Do(httpReq)
+		if err == nil && resp.StatusCode < 400 {
+			return resp, nil
+		}
+
+		var failure error
+		var retryAfter time.Duration
+		retryable := stream == nil && attempt < c.retries
+		if err != nil {
+			failure = fmt.Errorf("failed to perform HTTP request: %w", err)
+			// the request may have been processed before the connection failed
+			retryable = retryable && req.idempotent && ctx.Err() == nil
+		} else {
+			apiErr := newError(resp)
+			resp.Body.Close()
+			failure, retryAfter = apiErr, apiErr.RetryAfter
+			// waiting longer than the maximum backoff is left to the caller
+			retryable = retryable && apiErr.retryable(req.idempotent) && retryAfter <= c.maxBackoff
+		}
+		if !retryable {
+			return nil, failure
+		}
+
+		if err := sleep(ctx, c.backoffFor(attempt, retryAfter)); err != nil {
+			return nil, failure
+		}
+	}
+}
+
+// doJSON performs a request and JSON decodes the response body into respBody, unless it is nil.
+func (c *Client) doJSON(ctx context.Context, req request, respBody interface{}) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if respBody == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(respBody); err != nil {
+		return fmt.Errorf("failed to JSON decode response body: %w", err)
+	}
+	return nil
+}
+
+// backoffFor returns the duration to wait before retrying the given attempt: the server's Retry-After if it sent one,
+// otherwise an exponential backoff with full jitter.
+func (c *Client) backoffFor(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	backoff := c.backoff << attempt
+	if backoff > c.maxBackoff || backoff <= 0 {
+		backoff = c.maxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	jitter, err := rand.Int(rand.Reader, big.NewInt(int64(backoff)))
+	if err != nil {
+		return backoff
+	}
+	return time.Duration(jitter.Int64())
+}
+
+// sleep waits for the given duration, returning early with an error if the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// newIdempotencyKey generates a random key for the Idempotency-Key header, so that the server processes a retried
+// request only once.
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate idempotency key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parseRetryAfter parses a Retry-After header of delay seconds. Zero is returned if it is missing or not a number of
+// seconds.
+func parseRetryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
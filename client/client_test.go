@@ -0,0 +1,331 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/url-shortener/api"
+	"github.com/jemgunay/url-shortener/hash"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+// newTestServer starts a server routing requests to an api.API as cmd/server does. Requests are passed through the
+// given middleware, if it is not nil.
+func newTestServer(t *testing.T, storage store.Storage, middleware func(http.Handler) http.Handler, opts ...api.Option) *httptest.Server {
+	t.Helper()
+	hasher, err := hash.NewRandom(8)
+	if err != nil {
+		t.Fatalf("failed to create hasher: %s", err)
+	}
+	handlers := api.New(hash.WithContext(hasher), store.WithContext(storage), opts...)
+
+	mux := http.NewServeMux()
+	handlers.Routes(mux, api.RouteConfig{})
+
+	var handler http.Handler = handlers.RequestID(mux.ServeHTTP)
+	if middleware != nil {
+		handler = middleware(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestClient creates a Client for a test server which retries quickly.
+func newTestClient(t *testing.T, server *httptest.Server, opts ...Option) *Client {
+	t.Helper()
+	opts = append([]Option{WithRetries(2, time.Millisecond, time.Millisecond*10)}, opts...)
+	c, err := New(server.URL, opts...)
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+	return c
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		valid   bool
+	}{
+		{name: "http", baseURL: "http://localhost:8080", valid: true},
+		{name: "https_with_path", baseURL: "https://sho.rt/shortener/", valid: true},
+		{name: "relative", baseURL: "/shortener", valid: false},
+		{name: "unsupported_scheme", baseURL: "ftp://sho.rt", valid: false},
+		{name: "malformed", baseURL: "http://[::1", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.baseURL)
+			if (err == nil) != tt.valid {
+				t.Fatalf("unexpected error for %s, expected valid=%t, got %v", tt.baseURL, tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	storage := store.New()
+	storage.Set("taken", store.Record{URL: "https://jemgunay.co.uk/taken"})
+	storage.Set("expired", store.Record{URL: "https://jemgunay.co.uk/expired", ExpiresAt: time.Now().Add(-time.Hour)})
+	server := newTestServer(t, storage, nil)
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		do       func() error
+		sentinel error
+		status   int
+		code     string
+	}{
+		{
+			name: "validation",
+			do: func() error {
+				_, err := c.Shorten(ctx, ShortenRequest{OriginalURL: "javascript:alert(1)"})
+				return err
+			},
+			sentinel: ErrValidation,
+			status:   http.StatusUnprocessableEntity,
+			code:     "validation_failed",
+		},
+		{
+			name: "conflict",
+			do: func() error {
+				_, err := c.Shorten(ctx, ShortenRequest{OriginalURL: "https://jemgunay.co.uk", CustomAlias: "taken"})
+				return err
+			},
+			sentinel: ErrConflict,
+			status:   http.StatusConflict,
+			code:     "conflict",
+		},
+		{
+			name: "not_found",
+			do: func() error {
+				_, err := c.GetLink(ctx, "missing")
+				return err
+			},
+			sentinel: ErrNotFound,
+			status:   http.StatusNotFound,
+			code:     "not_found",
+		},
+		{
+			name: "gone",
+			do: func() error {
+				_, err := c.Lookup(ctx, "expired")
+				return err
+			},
+			sentinel: ErrGone,
+			status:   http.StatusGone,
+		},
+		{
+			name: "bad_request",
+			do: func() error {
+				_, err := c.ListLinks(ctx, ListRequest{Limit: 100000})
+				return err
+			},
+			sentinel: ErrBadRequest,
+			status:   http.StatusBadRequest,
+			code:     "bad_request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.do()
+			if !errors.Is(err, tt.sentinel) {
+				t.Fatalf("expected %v error, got %v", tt.sentinel, err)
+			}
+			apiErr := &Error{}
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an *Error, got %T", err)
+			}
+			if apiErr.StatusCode != tt.status || (tt.code != "" && apiErr.Code != tt.code) || apiErr.RequestID == "" && tt.code != "" {
+				t.Fatalf("unexpected error: %+v", apiErr)
+			}
+		})
+	}
+
+	// validation errors describe the invalid fields
+	_, err := c.Shorten(ctx, ShortenRequest{OriginalURL: "ftp://jemgunay.co.uk"})
+	apiErr := &Error{}
+	if !errors.As(err, &apiErr) || len(apiErr.Details) != 1 || apiErr.Details[0].Field != "original_url" {
+		t.Fatalf("unexpected validation error: %+v", err)
+	}
+}
+
+func TestClient_Auth(t *testing.T) {
+	keys := api.NewKeyStore()
+	_, userKey, err := keys.Create("alice", false)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	server := newTestServer(t, store.New(), nil, api.WithKeyStore(keys))
+	ctx := context.Background()
+
+	if _, err := newTestClient(t, server).Shorten(ctx, ShortenRequest{OriginalURL: "https://jemgunay.co.uk"}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+	if _, err := newTestClient(t, server, WithAPIKey("us_invalid")).ListLinks(ctx, ListRequest{}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+
+	c := newTestClient(t, server, WithAPIKey(userKey))
+	if _, err := c.Shorten(ctx, ShortenRequest{OriginalURL: "https://jemgunay.co.uk"}); err != nil {
+		t.Fatalf("failed to shorten: %s", err)
+	}
+	if _, err := c.ListKeys(ctx); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden error, got %v", err)
+	}
+}
+
+func TestClient_Retries(t *testing.T) {
+	// failRequests fails the first requests to each path with the given status, before passing requests through
+	failRequests := func(status, failures int, header http.Header) (func(http.Handler) http.Handler, *atomic.Int32) {
+		attempts := &atomic.Int32{}
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if attempts.Add(1) <= int32(failures) {
+					for name, values := range header {
+						w.Header()[name] = values
+					}
+					w.WriteHeader(status)
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		}, attempts
+	}
+
+	tests := []struct {
+		name     string
+		status   int
+		failures int
+		header   http.Header
+		do       func(c *Client) error
+		attempts int32
+		success  bool
+	}{
+		{
+			name:     "idempotent_retried",
+			status:   http.StatusServiceUnavailable,
+			failures: 2,
+			do: func(c *Client) error {
+				_, err := c.ListLinks(context.Background(), ListRequest{})
+				return err
+			},
+			attempts: 3,
+			success:  true,
+		},
+		{
+			name:     "retries_exhausted",
+			status:   http.StatusBadGateway,
+			failures: 3,
+			do: func(c *Client) error {
+				_, err := c.ListLinks(context.Background(), ListRequest{})
+				return err
+			},
+			attempts: 3,
+		},
+		{
+			name:     "shorten_retried_with_idempotency_key",
+			status:   http.StatusServiceUnavailable,
+			failures: 1,
+			do: func(c *Client) error {
+				_, err := c.Shorten(context.Background(), ShortenRequest{OriginalURL: "https://jemgunay.co.uk"})
+				return err
+			},
+			attempts: 2,
+			success:  true,
+		},
+		{
+			name:     "rate_limited_retried",
+			status:   http.StatusTooManyRequests,
+			failures: 1,
+			header:   http.Header{"Retry-After": {"0"}},
+			// creating a key is not idempotent, but a rate limited request was not processed; as keys are not enabled,
+			// the retry is not found
+			do: func(c *Client) error {
+				_, err := c.CreateKey(context.Background(), "alice", false)
+				return err
+			},
+			attempts: 2,
+		},
+		{
+			name:     "rate_limited_beyond_max_backoff",
+			status:   http.StatusTooManyRequests,
+			failures: 1,
+			header:   http.Header{"Retry-After": {"60"}},
+			do: func(c *Client) error {
+				_, err := c.ListLinks(context.Background(), ListRequest{})
+				return err
+			},
+			attempts: 1,
+		},
+		{
+			name:     "non_idempotent_not_retried",
+			status:   http.StatusServiceUnavailable,
+			failures: 1,
+			do: func(c *Client) error {
+				_, err := c.Import(context.Background(), FormatNDJSON, ConflictSkip, strings.NewReader(""))
+				return err
+			},
+			attempts: 1,
+		},
+		{
+			name:     "client_error_not_retried",
+			status:   http.StatusBadRequest,
+			failures: 1,
+			do: func(c *Client) error {
+				_, err := c.ListLinks(context.Background(), ListRequest{})
+				return err
+			},
+			attempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middleware, attempts := failRequests(tt.status, tt.failures, tt.header)
+			server := newTestServer(t, store.New(), middleware)
+
+			err := tt.do(newTestClient(t, server))
+			if (err == nil) != tt.success {
+				t.Fatalf("unexpected result, expected success=%t, got %v", tt.success, err)
+			}
+			if got := attempts.Load(); got != tt.attempts {
+				t.Fatalf("unexpected attempts, expected %d, got %d", tt.attempts, got)
+			}
+		})
+	}
+}
+
+func TestClient_ContextCancelled(t *testing.T) {
+	server := newTestServer(t, store.New(), func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+	})
+	c, err := New(server.URL, WithRetries(5, time.Hour, time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+
+	// the backoff is abandoned once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.ListLinks(ctx, ListRequest{}); !errors.Is(err, ErrServer) {
+		t.Fatalf("expected server error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("request was not cancelled, took %s", elapsed)
+	}
+}
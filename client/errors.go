@@ -0,0 +1,126 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Sentinel errors matching the status of a failed response. Test for them with errors.Is, i.e.
+// errors.Is(err, client.ErrNotFound), and use errors.As with an *Error for the server's error details.
+var (
+	// ErrBadRequest is returned if the request was malformed, i.e. an invalid alias or expiry.
+	ErrBadRequest = errors.New("bad request")
+	// ErrUnauthorized is returned if the API key is missing or invalid.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned if the API key may not perform the request, i.e. the link is owned by another key.
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound is returned if the link or API key does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned if the custom alias is already taken.
+	ErrConflict = errors.New("conflict")
+	// ErrGone is returned if the link has expired.
+	ErrGone = errors.New("gone")
+	// ErrTooLarge is returned if the request body is too large.
+	ErrTooLarge = errors.New("payload too large")
+	// ErrValidation is returned if the request failed validation, i.e. the URL is not an allowed http(s) URL. The
+	// invalid fields are described by the Error's Details.
+	ErrValidation = errors.New("validation failed")
+	// ErrRateLimited is returned if the client exceeded its rate limit.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrServer is returned if the server failed to process the request.
+	ErrServer = errors.New("server error")
+)
+
+// statusErrors maps response statuses to their sentinel errors.
+var statusErrors = map[int]error{
+	http.StatusBadRequest:            ErrBadRequest,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusGone:                  ErrGone,
+	http.StatusRequestEntityTooLarge: ErrTooLarge,
+	http.StatusUnprocessableEntity:   ErrValidation,
+	http.StatusTooManyRequests:       ErrRateLimited,
+}
+
+// FieldError describes why a single field of a request failed validation.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Error is a failed response from the server, decoded from its error envelope.
+type Error struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int `json:"-"`
+	// Code is the machine readable error code, i.e. "not_found". It is empty if the response was not an error
+	// envelope, i.e. it was returned by a proxy.
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+	// RetryAfter is the server's requested delay before retrying, if it set one.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// newError creates an Error from a failed response.
+func newError(resp *http.Response) *Error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	envelope := struct {
+		Error *Error `json:"error"`
+	}{
+		Error: apiErr,
+	}
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err == nil {
+		_ = json.Unmarshal(respBody, &envelope)
+	}
+	return apiErr
+}
+
+// Error describes the failed response.
+func (e *Error) Error() string {
+	status := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code == "" {
+		return "unexpected response status: " + status
+	}
+
+	msg := fmt.Sprintf("%s: %s (code: %s", status, e.Message, e.Code)
+	if e.RequestID != "" {
+		msg += ", request ID: " + e.RequestID
+	}
+	msg += ")"
+	for _, detail := range e.Details {
+		msg += fmt.Sprintf("\n  %s %s", detail.Field, detail.Reason)
+	}
+	return msg
+}
+
+// Is reports whether the Error's status matches a sentinel error.
+func (e *Error) Is(target error) bool {
+	if target == ErrServer {
+		return e.StatusCode >= 500
+	}
+	return statusErrors[e.StatusCode] == target
+}
+
+// retryable determines if the request which failed with the Error may be retried. Rate limited requests were not
+// processed so may always be retried, while requests which failed due to a server or gateway error are only retried if
+// they are idempotent.
+func (e *Error) retryable(idempotent bool) bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
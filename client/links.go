@@ -0,0 +1,343 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ShortenRequest describes a link to create.
+type ShortenRequest struct {
+	OriginalURL string `json:"original_url"`
+	// CustomAlias is an optional vanity hash to use in place of a generated one.
+	CustomAlias string `json:"custom_alias,omitempty"`
+	// TTL is an optional duration after which the link expires. It cannot be combined with ExpiresAt.
+	TTL time.Duration `json:"-"`
+	// ExpiresAt is an optional time at which the link expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RedirectStatus is an optional HTTP status code (301, 302, 307 or 308) to redirect with. The server's default is
+	// used if it is zero.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// Domain is the optional domain to create the link on if the server serves multiple domains.
+	Domain string `json:"domain,omitempty"`
+}
+
+// MarshalJSON encodes the request as the server's shorten payload, which expects the TTL as a duration string.
+func (s ShortenRequest) MarshalJSON() ([]byte, error) {
+	type payload ShortenRequest
+	var ttl string
+	if s.TTL != 0 {
+		ttl = s.TTL.String()
+	}
+	return json.Marshal(struct {
+		payload
+		TTL string `json:"ttl,omitempty"`
+	}{
+		payload: payload(s),
+		TTL:     ttl,
+	})
+}
+
+// Link is a short link.
+type Link struct {
+	ShortURL  string `json:"short_url"`
+	ShortHash string `json:"short_hash"`
+	// Domain is only set if the server serves multiple domains.
+	Domain      string `json:"domain,omitempty"`
+	OriginalURL string `json:"original_url"`
+	// CreatedAt is not set on links returned by Shorten.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RedirectStatus is zero if the link redirects with the server's default status.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+}
+
+// LinkOption is used to apply optional parameters to requests for a single link.
+type LinkOption func(url.Values)
+
+// InDomain selects the domain of the link's hash, if the server serves multiple domains. The default domain is used
+// otherwise.
+func InDomain(domain string) LinkOption {
+	return func(query url.Values) {
+		query.Set("domain", domain)
+	}
+}
+
+// linkQuery applies link options to a query.
+func linkQuery(opts []LinkOption) url.Values {
+	query := url.Values{}
+	for _, opt := range opts {
+		opt(query)
+	}
+	return query
+}
+
+// Shorten creates a short link. Retries are sent with the same Idempotency-Key header, so the link is only created
+// once.
+func (c *Client) Shorten(ctx context.Context, shorten ShortenRequest) (Link, error) {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return Link{}, err
+	}
+	req := request{
+		method:     http.MethodPost,
+		path:       "/api/v1/shorten",
+		header:     http.Header{"Idempotency-Key": {key}},
+		body:       shorten,
+		idempotent: true,
+	}
+
+	link := Link{}
+	if err := c.doJSON(ctx, req, &link); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// BatchResult is the outcome of shortening a single item of a batch. Exactly one of Link and Error is set.
+type BatchResult struct {
+	// Index is the position of the item in the batch, starting from zero.
+	Index int `json:"index"`
+	// Status is the HTTP status which the item would have been responded with by Shorten.
+	Status int    `json:"status"`
+	Link   *Link  `json:"link,omitempty"`
+	Error  *Error `json:"error,omitempty"`
+}
+
+// BatchResponse is the outcome of a batch shorten.
+type BatchResponse struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// Results are ordered by index. If the batch could not be read to the end, the final result describes why.
+	Results []BatchResult `json:"results"`
+}
+
+// ShortenBatch creates a short link for each request in a single request. Invalid items do not fail the batch, so
+// the outcome of each item is described by its BatchResult. Retries are sent with the same Idempotency-Key header.
+func (c *Client) ShortenBatch(ctx context.Context, batch []ShortenRequest) (BatchResponse, error) {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return BatchResponse{}, err
+	}
+	req := request{
+		method:     http.MethodPost,
+		path:       "/api/v1/shorten/batch",
+		header:     http.Header{"Idempotency-Key": {key}},
+		body:       batch,
+		idempotent: true,
+	}
+	return c.shortenBatch(ctx, req)
+}
+
+// ShortenBatchStream is ShortenBatch for a stream of newline delimited JSON shorten payloads, so that large batches
+// are not buffered in memory. The request is not retried as the stream can only be read once.
+func (c *Client) ShortenBatchStream(ctx context.Context, ndjson io.Reader) (BatchResponse, error) {
+	req := request{
+		method:      http.MethodPost,
+		path:        "/api/v1/shorten/batch",
+		body:        ndjson,
+		contentType: "application/x-ndjson",
+	}
+	return c.shortenBatch(ctx, req)
+}
+
+// shortenBatch performs a batch shorten request.
+func (c *Client) shortenBatch(ctx context.Context, req request) (BatchResponse, error) {
+	resp := BatchResponse{}
+	if err := c.doJSON(ctx, req, &resp); err != nil {
+		return BatchResponse{}, err
+	}
+	// item errors are not responses of their own, so carry their status over
+	for _, result := range resp.Results {
+		if result.Error != nil {
+			result.Error.StatusCode = result.Status
+		}
+	}
+	return resp, nil
+}
+
+// Redirect is the outcome of looking up a hash.
+type Redirect struct {
+	Hash     string `json:"hash"`
+	Location string `json:"location"`
+	// Status is the redirect's 3xx HTTP status.
+	Status int `json:"status"`
+}
+
+// Lookup resolves the URL which a hash redirects to, as a visitor following the short URL would. The API key is not
+// sent, so the click is recorded as any other visitor's.
+func (c *Client) Lookup(ctx context.Context, hash string) (Redirect, error) {
+	req := request{
+		method:     http.MethodGet,
+		path:       "/" + hash,
+		anonymous:  true,
+		idempotent: true,
+		noRedirect: true,
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return Redirect{}, err
+	}
+	resp.Body.Close()
+
+	// links can be configured to redirect with any 3xx status
+	if resp.StatusCode < 300 || resp.StatusCode > 399 {
+		return Redirect{}, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return Redirect{Hash: hash, Location: resp.Header.Get("Location"), Status: resp.StatusCode}, nil
+}
+
+// ListRequest describes a page of links to list.
+type ListRequest struct {
+	// Limit is the maximum number of links in the page. The server's default is used if it is zero.
+	Limit int
+	// Cursor is the NextCursor of the previous page, or empty for the first page.
+	Cursor string
+	// Domain restricts the page to a single domain, if the server serves multiple domains.
+	Domain string
+}
+
+// LinkPage is a page of links, ordered by domain and hash.
+type LinkPage struct {
+	Links []Link `json:"links"`
+	// NextCursor is the cursor of the next page, which is empty if this is the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListLinks returns a page of the links which the API key may manage.
+func (c *Client) ListLinks(ctx context.Context, list ListRequest) (LinkPage, error) {
+	query := url.Values{}
+	if list.Limit > 0 {
+		query.Set("limit", strconv.Itoa(list.Limit))
+	}
+	if list.Cursor != "" {
+		query.Set("cursor", list.Cursor)
+	}
+	if list.Domain != "" {
+		query.Set("domain", list.Domain)
+	}
+
+	page := LinkPage{}
+	req := request{method: http.MethodGet, path: "/api/v1/links", query: query, idempotent: true}
+	if err := c.doJSON(ctx, req, &page); err != nil {
+		return LinkPage{}, err
+	}
+	return page, nil
+}
+
+// GetLink returns a link.
+func (c *Client) GetLink(ctx context.Context, hash string, opts ...LinkOption) (Link, error) {
+	link := Link{}
+	req := request{method: http.MethodGet, path: "/api/v1/links/" + hash, query: linkQuery(opts), idempotent: true}
+	if err := c.doJSON(ctx, req, &link); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// UpdateRequest describes changes to a link. Only the set fields are changed.
+type UpdateRequest struct {
+	OriginalURL *string
+	// TTL and ExpiresAt replace the link's expiry, as per the ShortenRequest.
+	TTL       time.Duration
+	ExpiresAt *time.Time
+	// ClearExpiry removes the link's expiry, so that it never expires. It cannot be combined with TTL or ExpiresAt.
+	ClearExpiry bool
+	// RedirectStatus replaces the link's redirect status. Zero reverts the link to the server's default.
+	RedirectStatus *int
+}
+
+var (
+	// errNoChanges is returned by UpdateLink if the UpdateRequest sets no fields.
+	errNoChanges = errors.New("update contains no changes")
+	// errClearExpiry is returned by UpdateLink if the UpdateRequest both clears and replaces the expiry.
+	errClearExpiry = errors.New("ClearExpiry cannot be combined with TTL or ExpiresAt")
+)
+
+// UpdateLink changes a link's original URL, expiry and/or redirect status, returning the updated link.
+func (c *Client) UpdateLink(ctx context.Context, hash string, update UpdateRequest, opts ...LinkOption) (Link, error) {
+	if update.OriginalURL == nil && update.TTL == 0 && update.ExpiresAt == nil && !update.ClearExpiry &&
+		update.RedirectStatus == nil {
+		return Link{}, errNoChanges
+	}
+	if update.ClearExpiry && (update.TTL != 0 || update.ExpiresAt != nil) {
+		return Link{}, errClearExpiry
+	}
+	payload := struct {
+		OriginalURL *string `json:"original_url,omitempty"`
+		TTL         string  `json:"ttl,omitempty"`
+		// ExpiresAt is a *time.Time, or a JSON null to clear the expiry
+		ExpiresAt      interface{} `json:"expires_at,omitempty"`
+		RedirectStatus *int        `json:"redirect_status,omitempty"`
+	}{
+		OriginalURL:    update.OriginalURL,
+		RedirectStatus: update.RedirectStatus,
+	}
+	if update.TTL != 0 {
+		payload.TTL = update.TTL.String()
+	}
+	switch {
+	case update.ClearExpiry:
+		payload.ExpiresAt = json.RawMessage("null")
+	case update.ExpiresAt != nil:
+		payload.ExpiresAt = update.ExpiresAt
+	}
+
+	link := Link{}
+	// a TTL is relative to when the update is applied, so retrying it would extend the expiry
+	req := request{
+		method:     http.MethodPatch,
+		path:       "/api/v1/links/" + hash,
+		query:      linkQuery(opts),
+		body:       payload,
+		idempotent: update.TTL == 0,
+	}
+	if err := c.doJSON(ctx, req, &link); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// DeleteLink deletes a link.
+func (c *Client) DeleteLink(ctx context.Context, hash string, opts ...LinkOption) error {
+	req := request{method: http.MethodDelete, path: "/api/v1/links/" + hash, query: linkQuery(opts), idempotent: true}
+	return c.doJSON(ctx, req, nil)
+}
+
+// Stats are the aggregated clicks of a link.
+type Stats struct {
+	Hash          string     `json:"hash"`
+	Clicks        uint64     `json:"clicks"`
+	UniqueClients int        `json:"unique_clients"`
+	FirstClick    *time.Time `json:"first_click,omitempty"`
+	LastClick     *time.Time `json:"last_click,omitempty"`
+	// Referrers counts clicks by referring host, where "direct" counts clicks without a referrer.
+	Referrers map[string]uint64 `json:"referrers"`
+	// UserAgents counts clicks by user agent, where "unknown" counts clicks without one.
+	UserAgents map[string]uint64 `json:"user_agents"`
+	// BucketSeconds is the width of each bucket of the Histogram.
+	BucketSeconds int64    `json:"bucket_seconds"`
+	Histogram     []Bucket `json:"histogram"`
+}
+
+// Bucket counts the clicks of a link within a period starting at Start.
+type Bucket struct {
+	Start  time.Time `json:"start"`
+	Clicks uint64    `json:"clicks"`
+}
+
+// Stats returns the click analytics of a link.
+func (c *Client) Stats(ctx context.Context, hash string, opts ...LinkOption) (Stats, error) {
+	stats := Stats{}
+	req := request{method: http.MethodGet, path: "/api/v1/links/" + hash + "/stats", query: linkQuery(opts), idempotent: true}
+	if err := c.doJSON(ctx, req, &stats); err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/url-shortener/analytics"
+	"github.com/jemgunay/url-shortener/api"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestClient_Links(t *testing.T) {
+	storage := store.New()
+	recorder := analytics.NewRecorder(10, time.Hour)
+	defer recorder.Close()
+	server := newTestServer(t, storage, nil, api.WithRecorder(recorder))
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	// the original URL is JSON encoded, so quotes are preserved
+	link, err := c.Shorten(ctx, ShortenRequest{
+		OriginalURL:    `https://jemgunay.co.uk/?q="blog"`,
+		CustomAlias:    "blog",
+		TTL:            time.Hour,
+		RedirectStatus: http.StatusTemporaryRedirect,
+	})
+	if err != nil {
+		t.Fatalf("failed to shorten: %s", err)
+	}
+	if link.ShortHash != "blog" || link.ShortURL != server.URL+"/blog" || link.OriginalURL != `https://jemgunay.co.uk/?q="blog"` {
+		t.Fatalf("unexpected link: %+v", link)
+	}
+	record, err := storage.Get("blog")
+	if err != nil {
+		t.Fatalf("failed to get stored link: %s", err)
+	}
+	if until := time.Until(record.ExpiresAt); until <= 0 || until > time.Hour {
+		t.Fatalf("unexpected expiry: %s", record.ExpiresAt)
+	}
+
+	redirect, err := c.Lookup(ctx, "blog")
+	if err != nil {
+		t.Fatalf("failed to lookup: %s", err)
+	}
+	if redirect.Location != link.OriginalURL || redirect.Status != http.StatusTemporaryRedirect {
+		t.Fatalf("unexpected redirect: %+v", redirect)
+	}
+
+	newURL := "https://jemgunay.co.uk/blog"
+	updated, err := c.UpdateLink(ctx, "blog", UpdateRequest{OriginalURL: &newURL})
+	if err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+	if updated.OriginalURL != newURL || updated.CreatedAt == nil || updated.ExpiresAt == nil {
+		t.Fatalf("unexpected updated link: %+v", updated)
+	}
+	if _, err := c.UpdateLink(ctx, "blog", UpdateRequest{}); err == nil {
+		t.Fatalf("expected an error for an empty update")
+	}
+	if _, err := c.UpdateLink(ctx, "blog", UpdateRequest{ClearExpiry: true, TTL: time.Hour}); err == nil {
+		t.Fatalf("expected an error for an update which clears and replaces the expiry")
+	}
+	updated, err = c.UpdateLink(ctx, "blog", UpdateRequest{ClearExpiry: true})
+	if err != nil {
+		t.Fatalf("failed to clear expiry: %s", err)
+	}
+	if updated.ExpiresAt != nil {
+		t.Fatalf("expected expiry to be cleared: %+v", updated)
+	}
+	if record, err := storage.Get("blog"); err != nil || !record.ExpiresAt.IsZero() {
+		t.Fatalf("expected stored expiry to be cleared, got %s (%v)", record.ExpiresAt, err)
+	}
+
+	got, err := c.GetLink(ctx, "blog")
+	if err != nil {
+		t.Fatalf("failed to get link: %s", err)
+	}
+	if got.OriginalURL != newURL {
+		t.Fatalf("unexpected link: %+v", got)
+	}
+
+	stats, err := c.Stats(ctx, "blog")
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err)
+	}
+	if stats.Hash != "blog" {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if err := c.DeleteLink(ctx, "blog"); err != nil {
+		t.Fatalf("failed to delete link: %s", err)
+	}
+	if _, err := c.GetLink(ctx, "blog"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted link to be not found, got %v", err)
+	}
+}
+
+func TestClient_ListLinks(t *testing.T) {
+	storage := store.New()
+	for _, hash := range []string{"a", "b", "c", "d", "e"} {
+		storage.Set(hash, store.Record{URL: "https://jemgunay.co.uk/" + hash, CreatedAt: time.Now()})
+	}
+	c := newTestClient(t, newTestServer(t, storage, nil))
+
+	// follow the cursor until every page has been read
+	var hashes []string
+	list := ListRequest{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages listed")
+		}
+		page, err := c.ListLinks(context.Background(), list)
+		if err != nil {
+			t.Fatalf("failed to list links: %s", err)
+		}
+		for _, link := range page.Links {
+			hashes = append(hashes, link.ShortHash)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		list.Cursor = page.NextCursor
+	}
+	if strings.Join(hashes, ",") != "a,b,c,d,e" {
+		t.Fatalf("unexpected hashes: %v", hashes)
+	}
+}
+
+func TestClient_ShortenBatch(t *testing.T) {
+	c := newTestClient(t, newTestServer(t, store.New(), nil))
+	ctx := context.Background()
+
+	resp, err := c.ShortenBatch(ctx, []ShortenRequest{
+		{OriginalURL: "https://jemgunay.co.uk"},
+		{OriginalURL: "ftp://jemgunay.co.uk"},
+	})
+	if err != nil {
+		t.Fatalf("failed to shorten batch: %s", err)
+	}
+	if resp.Succeeded != 1 || resp.Failed != 1 || len(resp.Results) != 2 {
+		t.Fatalf("unexpected batch response: %+v", resp)
+	}
+	if resp.Results[0].Link == nil || !errors.Is(resp.Results[1].Error, ErrValidation) {
+		t.Fatalf("unexpected batch results: %+v", resp.Results)
+	}
+
+	resp, err = c.ShortenBatchStream(ctx, strings.NewReader("{\"original_url\": \"https://jemgunay.co.uk\"}\n{\"original_url\": \"https://jemgunay.co.uk/blog\"}\n"))
+	if err != nil {
+		t.Fatalf("failed to shorten batch stream: %s", err)
+	}
+	if resp.Succeeded != 2 || resp.Failed != 0 {
+		t.Fatalf("unexpected batch response: %+v", resp)
+	}
+}
+
+func TestClient_Domains(t *testing.T) {
+	storage := store.New()
+	server := newTestServer(t, storage, nil, api.WithDomains([]string{"jemgunay.co.uk", "jem.gy"}))
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	link, err := c.Shorten(ctx, ShortenRequest{OriginalURL: "https://jemgunay.co.uk", CustomAlias: "home", Domain: "jem.gy"})
+	if err != nil {
+		t.Fatalf("failed to shorten: %s", err)
+	}
+	if link.Domain != "jem.gy" {
+		t.Fatalf("unexpected link: %+v", link)
+	}
+
+	// the link is only found within its domain
+	if _, err := c.GetLink(ctx, "home"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected link to be not found in the default domain, got %v", err)
+	}
+	if _, err := c.GetLink(ctx, "home", InDomain("jem.gy")); err != nil {
+		t.Fatalf("failed to get link: %s", err)
+	}
+	if err := c.DeleteLink(ctx, "home", InDomain("jem.gy")); err != nil {
+		t.Fatalf("failed to delete link: %s", err)
+	}
+}
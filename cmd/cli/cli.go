@@ -1,97 +1,277 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
+	"io"
 	"os"
-	"strings"
+	"path/filepath"
 	"time"
+
+	"github.com/jemgunay/url-shortener/client"
+)
+
+// Exit codes returned by the CLI, so that scripts can react to the reason a command failed.
+const (
+	exitOK = 0
+	// exitFailure is returned if the server could not be reached or failed to process the request.
+	exitFailure = 1
+	// exitUsage is returned if the command, its flags or the config are invalid.
+	exitUsage = 2
+	// exitNotFound is returned if the requested link does not exist.
+	exitNotFound = 3
+	// exitUnauthorized is returned if the API key is missing, invalid or not permitted to perform the request.
+	exitUnauthorized = 4
+	// exitRejected is returned if the server rejected the request as invalid, i.e. a malformed URL or a taken alias.
+	exitRejected = 5
+	// exitPartial is returned if some items of a batch or import failed.
+	exitPartial = 6
+)
+
+// Environment variables which override the config file.
+const (
+	envConfig = "URL_SHORTENER_CONFIG"
+	envAddr   = "URL_SHORTENER_ADDR"
+	envAPIKey = "URL_SHORTENER_API_KEY"
 )
 
+const defaultAddr = "http://localhost:8080"
+
+const usage = `Usage: cli <command> [flags] [args]
+
+Commands:
+  shorten <url>    shorten a URL
+  batch            shorten every URL of a file (one per line)
+  lookup <hash>    lookup the URL which a hash redirects to
+  list             list links
+  delete <hash>    delete a link
+  stats <hash>     show the click analytics of a link
+  export           export every link to a file
+  import           import links from a file
+
+Run "cli <command> -h" for the flags of a command.
+
+The server address and API key are read from the config file (%s by default, or
+$%s), then the $%s and $%s environment variables, then the -addr and -api-key flags. The
+config file is JSON:
+
+  {"addr": "https://short.example.com", "api_key": "us_..."}
+
+Exit codes: 0 success, 1 failure, 2 usage error, 3 not found, 4 unauthorised, 5 rejected by the server, 6 some items
+of a batch or import failed.
+`
+
 func main() {
-	addr := flag.String("addr", "http://localhost:8080", "the server instance to connect to")
-	operation := flag.String("operation", "", "the target operation (shorten/lookup)")
-	originalURL := flag.String("original_url", "", "the original URL to shorten")
-	hash := flag.String("hash", "", "the hash to lookup")
-	flag.Parse()
-
-	var err error
-	switch *operation {
-	case "shorten":
-		err = shorten(*addr, *originalURL)
-	case "lookup":
-		err = lookup(*addr, *hash)
-	default:
-		err = fmt.Errorf("unsupported operation arg: %s", *operation)
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(exitCode(err))
 	}
+}
 
-	if err != nil {
-		log.Printf("operation %s failed: %s", *operation, err)
-		os.Exit(1)
+// command is a CLI subcommand. It parses its own flags from args and writes its result to out.
+type command func(args []string, out io.Writer) error
+
+var commands = map[string]command{
+	"shorten": shortenCommand,
+	"batch":   batchCommand,
+	"lookup":  lookupCommand,
+	"list":    listCommand,
+	"delete":  deleteCommand,
+	"stats":   statsCommand,
+	"export":  exportCommand,
+	"import":  importCommand,
+}
+
+// run executes the command named by the first arg.
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		fmt.Fprintf(os.Stderr, usage, defaultConfigPath(), envConfig, envAddr, envAPIKey)
+		if len(args) == 0 {
+			return usageError{errors.New("no command given")}
+		}
+		return nil
 	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return usageError{fmt.Errorf("unknown command %q, run \"cli help\" for the list of commands", args[0])}
+	}
+	if err := cmd(args[1:], out); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return nil
 }
 
-// define HTTP client with timeout and redirect disabled
-var httpClient = http.Client{
-	Timeout: time.Second * 5,
-	CheckRedirect: func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
-	},
+// usageError is an error caused by invalid usage of the CLI.
+type usageError struct {
+	err error
 }
 
-// shorten performs a request to the shorten handler to generate a redirect hash for the given URL.
-func shorten(addr, originalURL string) error {
-	req, err := http.NewRequest(http.MethodPost, addr+"/api/v1/shorten", strings.NewReader(`{"original_url": "`+originalURL+`"}`))
-	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %s", err)
+func (u usageError) Error() string {
+	return u.err.Error()
+}
+
+func (u usageError) Unwrap() error {
+	return u.err
+}
+
+// partialError is returned if some items of a batch or import failed.
+type partialError struct {
+	failed, total int
+}
+
+func (p partialError) Error() string {
+	return fmt.Sprintf("%d of %d items failed", p.failed, p.total)
+}
+
+// exitCode maps an error returned by a command to the CLI's exit code.
+func exitCode(err error) int {
+	var (
+		usageErr   usageError
+		partialErr partialError
+		apiErr     *client.Error
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &partialErr):
+		return exitPartial
+	case errors.Is(err, client.ErrNotFound), errors.Is(err, client.ErrGone):
+		return exitNotFound
+	case errors.Is(err, client.ErrUnauthorized), errors.Is(err, client.ErrForbidden):
+		return exitUnauthorized
+	case errors.As(err, &apiErr) && apiErr.StatusCode < 500:
+		return exitRejected
 	}
+	return exitFailure
+}
 
-	resp, err := httpClient.Do(req)
+// config holds the settings shared by every command.
+type config struct {
+	Addr   string `json:"addr"`
+	APIKey string `json:"api_key"`
+	// output is the format which results are written in.
+	output string
+	// timeout is the maximum duration of a request.
+	timeout time.Duration
+}
+
+// defaultConfigPath returns the path of the config file which is read if no other is specified.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
 	if err != nil {
-		return fmt.Errorf("failed to perform HTTP request: %s", err)
+		return ""
 	}
-	defer resp.Body.Close()
+	return filepath.Join(dir, "url-shortener", "config.json")
+}
+
+// commonFlags registers the flags shared by every command. The returned func resolves the config once the flags have
+// been parsed, reading the config file, then the environment, then the flags which were explicitly set, and creates a
+// client for the configured server.
+func commonFlags(fs *flag.FlagSet, defaultTimeout time.Duration) func() (config, *client.Client, error) {
+	configPath := fs.String("config", "", "the config file to read the server address and API key from")
+	addr := fs.String("addr", defaultAddr, "the server instance to connect to")
+	apiKey := fs.String("api-key", "", "the API key to authenticate with, if the server requires one")
+	output := fs.String("output", outputTable, "the output format (table/json/plain)")
+	timeout := fs.Duration("timeout", defaultTimeout, "the maximum duration of the request")
+
+	return func() (config, *client.Client, error) {
+		cfg := config{
+			Addr:    defaultAddr,
+			output:  *output,
+			timeout: *timeout,
+		}
+		switch cfg.output {
+		case outputTable, outputJSON, outputPlain:
+		default:
+			return config{}, nil, usageError{fmt.Errorf("unsupported output format %q", cfg.output)}
+		}
+
+		// the default config file is optional, but one which was explicitly requested must exist
+		path, explicit := *configPath, true
+		if path == "" {
+			path = os.Getenv(envConfig)
+		}
+		if path == "" {
+			path, explicit = defaultConfigPath(), false
+		}
+		if path != "" {
+			if err := cfg.load(path); err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+				return config{}, nil, usageError{err}
+			}
+		}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected response status: %s", resp.Status)
+		if env := os.Getenv(envAddr); env != "" {
+			cfg.Addr = env
+		}
+		if env := os.Getenv(envAPIKey); env != "" {
+			cfg.APIKey = env
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "addr":
+				cfg.Addr = *addr
+			case "api-key":
+				cfg.APIKey = *apiKey
+			}
+		})
+
+		c, err := client.New(cfg.Addr, client.WithAPIKey(cfg.APIKey))
+		if err != nil {
+			return config{}, nil, usageError{err}
+		}
+		return cfg, c, nil
 	}
+}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+// load reads the config file at the given path into the config. Settings missing from the file are left unchanged.
+func (c *config) load(path string) error {
+	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %s", err)
+		return fmt.Errorf("failed to open config file: %w", err)
 	}
+	defer f.Close()
 
-	// print the successful shorten result
-	log.Printf("%s", respBody)
+	if err := json.NewDecoder(f).Decode(c); err != nil {
+		return fmt.Errorf("failed to JSON decode config file %s: %s", path, err)
+	}
 	return nil
 }
 
-// lookup performs a request to the redirect handler and extracts the redirect URL from the response.
-func lookup(addr, hash string) error {
-	req, err := http.NewRequest(http.MethodGet, addr+"/"+hash, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %s", err)
-	}
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to perform HTTP request: %s", err)
+// newFlagSet creates the flag set of a command, which returns parse errors rather than exiting.
+func newFlagSet(name, args, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cli %s [flags] %s\n\n%s\n\nFlags:\n", name, args, description)
+		fs.PrintDefaults()
 	}
-	defer resp.Body.Close()
+	return fs
+}
 
-	if resp.StatusCode != http.StatusMovedPermanently {
-		if resp.StatusCode == http.StatusNotFound {
-			return errors.New("no URL found for the provided hash")
+// parseFlags parses a command's flags, which may be given before or after its positional args, and checks that the
+// expected number of positional args were given.
+func parseFlags(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usageError{err}
 		}
-		return fmt.Errorf("unexpected response status: %s", resp.Status)
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
 	}
 
-	// print resulting original URL
-	locationHeader := resp.Header.Get("Location")
-	log.Printf("%s redirects to %s", hash, locationHeader)
-	return nil
+	if len(rest) != positional {
+		return nil, usageError{fmt.Errorf("%s expects %d argument(s), got %d", fs.Name(), positional, len(rest))}
+	}
+	return rest, nil
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/url-shortener/api"
+	"github.com/jemgunay/url-shortener/client"
+	hashstub "github.com/jemgunay/url-shortener/hash/stub"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+// newTestServer creates a test server serving the API's routes, which generates the given hashes in turn.
+func newTestServer(t *testing.T, hashes []string, opts ...api.Option) *httptest.Server {
+	t.Helper()
+	handlers := api.New(hashstub.NewSequence(hashes...), store.WithContext(store.New()), opts...)
+	mux := http.NewServeMux()
+	handlers.Routes(mux, api.RouteConfig{})
+
+	server := httptest.NewServer(handlers.RequestID(mux.ServeHTTP))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// isolateConfig stops the environment of the test process from affecting the CLI's config, returning the directory
+// which the default config file is read from.
+func isolateConfig(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv(envConfig, "")
+	t.Setenv(envAddr, "")
+	t.Setenv(envAPIKey, "")
+	return filepath.Join(dir, "url-shortener")
+}
+
+// writeConfig writes a config file to the given path.
+func writeConfig(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("failed to create config dir: %s", err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %s", err)
+	}
+}
+
+// runCLI runs the CLI with the given args, returning its output and exit code.
+func runCLI(args ...string) (string, int) {
+	out := &bytes.Buffer{}
+	code := exitCode(run(args, out))
+	return out.String(), code
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{name: "success", err: nil, code: exitOK},
+		{name: "usage", err: usageError{errors.New("bad flag")}, code: exitUsage},
+		{name: "wrapped_usage", err: fmt.Errorf("shorten: %w", usageError{errors.New("bad flag")}), code: exitUsage},
+		{name: "partial", err: partialError{failed: 1, total: 2}, code: exitPartial},
+		{name: "not_found", err: &client.Error{StatusCode: http.StatusNotFound}, code: exitNotFound},
+		{name: "gone", err: &client.Error{StatusCode: http.StatusGone}, code: exitNotFound},
+		{name: "unauthorized", err: &client.Error{StatusCode: http.StatusUnauthorized}, code: exitUnauthorized},
+		{name: "forbidden", err: &client.Error{StatusCode: http.StatusForbidden}, code: exitUnauthorized},
+		{name: "rejected", err: &client.Error{StatusCode: http.StatusConflict}, code: exitRejected},
+		{name: "server_error", err: &client.Error{StatusCode: http.StatusInternalServerError}, code: exitFailure},
+		{name: "other", err: errors.New("connection refused"), code: exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := exitCode(tt.err); code != tt.code {
+				t.Fatalf("unexpected exit code, expected %d, got %d", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestRun_ExitCode(t *testing.T) {
+	isolateConfig(t)
+
+	keys := api.NewKeyStore()
+	_, apiKey, err := keys.Create("alice", false)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	server := newTestServer(t, []string{"abc123", "def456", "ghi789"}, api.WithKeyStore(keys))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":{"code":"internal_error","message":"internal server error"}}`))
+	}))
+	defer failing.Close()
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	// an export which ends without the trailer sent by complete exports
+	truncated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Export-Count")
+		w.Write([]byte("{\"hash\": \"abc123\", \"url\": \"https://jemgunay.co.uk\"}\n"))
+	}))
+	defer truncated.Close()
+
+	batch := filepath.Join(t.TempDir(), "urls.txt")
+	if err := os.WriteFile(batch, []byte("https://jemgunay.co.uk\nnot a url\n"), 0o600); err != nil {
+		t.Fatalf("failed to write batch file: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "success", args: []string{"shorten", "https://jemgunay.co.uk", "-addr", server.URL, "-api-key", apiKey}, code: exitOK},
+		{name: "help", args: []string{"help"}, code: exitOK},
+		{name: "command_help", args: []string{"shorten", "-h"}, code: exitOK},
+		{name: "no_command", args: nil, code: exitUsage},
+		{name: "unknown_command", args: []string{"unknown"}, code: exitUsage},
+		{name: "missing_arg", args: []string{"shorten", "-addr", server.URL}, code: exitUsage},
+		{name: "unknown_flag", args: []string{"list", "-unknown", "-addr", server.URL}, code: exitUsage},
+		{name: "not_found", args: []string{"lookup", "missing", "-addr", server.URL}, code: exitNotFound},
+		{name: "missing_key", args: []string{"list", "-addr", server.URL}, code: exitUnauthorized},
+		{name: "invalid_key", args: []string{"list", "-addr", server.URL, "-api-key", "us_invalid"}, code: exitUnauthorized},
+		{name: "rejected", args: []string{"shorten", "not a url", "-addr", server.URL, "-api-key", apiKey}, code: exitRejected},
+		{name: "partial", args: []string{"batch", "-file", batch, "-addr", server.URL, "-api-key", apiKey}, code: exitPartial},
+		{name: "server_error", args: []string{"list", "-addr", failing.URL, "-timeout", "5s"}, code: exitFailure},
+		{name: "unreachable", args: []string{"list", "-addr", unreachable.URL, "-timeout", "5s"}, code: exitFailure},
+		{name: "export", args: []string{"export", "-addr", newTestServer(t, nil).URL}, code: exitOK},
+		{name: "truncated_export", args: []string{"export", "-addr", truncated.URL}, code: exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, code := runCLI(tt.args...); code != tt.code {
+				t.Fatalf("unexpected exit code, expected %d, got %d", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		positional int
+		respArgs   []string
+		respAlias  string
+		respForce  bool
+		respErr    bool
+		respHelp   bool
+	}{
+		{
+			name:       "flags_before_args",
+			args:       []string{"-alias", "blog", "-force", "https://jemgunay.co.uk"},
+			positional: 1,
+			respArgs:   []string{"https://jemgunay.co.uk"},
+			respAlias:  "blog",
+			respForce:  true,
+		},
+		{
+			name:       "flags_after_args",
+			args:       []string{"https://jemgunay.co.uk", "-alias=blog", "-force"},
+			positional: 1,
+			respArgs:   []string{"https://jemgunay.co.uk"},
+			respAlias:  "blog",
+			respForce:  true,
+		},
+		{
+			name:       "flags_between_args",
+			args:       []string{"a", "-alias", "blog", "b"},
+			positional: 2,
+			respArgs:   []string{"a", "b"},
+			respAlias:  "blog",
+		},
+		{
+			name:       "no_args",
+			args:       []string{"-force"},
+			positional: 0,
+			respForce:  true,
+		},
+		{
+			name:       "too_few_args",
+			args:       []string{"-force"},
+			positional: 1,
+			respErr:    true,
+		},
+		{
+			name:       "too_many_args",
+			args:       []string{"a", "b"},
+			positional: 1,
+			respErr:    true,
+		},
+		{
+			name:       "unknown_flag",
+			args:       []string{"a", "-unknown"},
+			positional: 1,
+			respErr:    true,
+		},
+		{
+			name:       "help",
+			args:       []string{"-h"},
+			positional: 1,
+			respHelp:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newFlagSet("test", "", "")
+			fs.SetOutput(&bytes.Buffer{})
+			alias := fs.String("alias", "", "")
+			force := fs.Bool("force", false, "")
+
+			args, err := parseFlags(fs, tt.args, tt.positional)
+			if tt.respHelp {
+				if !errors.Is(err, flag.ErrHelp) {
+					t.Fatalf("expected help error, got %v", err)
+				}
+				return
+			}
+			if tt.respErr {
+				if exitCode(err) != exitUsage {
+					t.Fatalf("expected usage error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(args, tt.respArgs) {
+				t.Fatalf("unexpected args, expected %q, got %q", tt.respArgs, args)
+			}
+			if *alias != tt.respAlias {
+				t.Fatalf("unexpected alias, expected %q, got %q", tt.respAlias, *alias)
+			}
+			if *force != tt.respForce {
+				t.Fatalf("unexpected force, expected %t, got %t", tt.respForce, *force)
+			}
+		})
+	}
+}
+
+func TestCommonFlags_Config(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultFile string
+		envFile     string
+		env         map[string]string
+		args        []string
+		respAddr    string
+		respAPIKey  string
+		respErr     bool
+	}{
+		{
+			name:     "defaults",
+			respAddr: defaultAddr,
+		},
+		{
+			name:        "default_file",
+			defaultFile: `{"addr": "https://file.example.com", "api_key": "us_file"}`,
+			respAddr:    "https://file.example.com",
+			respAPIKey:  "us_file",
+		},
+		{
+			name:        "partial_file",
+			defaultFile: `{"api_key": "us_file"}`,
+			respAddr:    defaultAddr,
+			respAPIKey:  "us_file",
+		},
+		{
+			name:        "env_file_replaces_default_file",
+			defaultFile: `{"addr": "https://file.example.com", "api_key": "us_file"}`,
+			envFile:     `{"addr": "https://env-file.example.com"}`,
+			respAddr:    "https://env-file.example.com",
+		},
+		{
+			name:        "flag_file_replaces_env_file",
+			envFile:     `{"addr": "https://env-file.example.com"}`,
+			args:        []string{"-config", "flag.json"},
+			defaultFile: `{"addr": "https://file.example.com"}`,
+			respAddr:    "https://flag-file.example.com",
+		},
+		{
+			name:        "env_overrides_file",
+			defaultFile: `{"addr": "https://file.example.com", "api_key": "us_file"}`,
+			env:         map[string]string{envAddr: "https://env.example.com"},
+			respAddr:    "https://env.example.com",
+			respAPIKey:  "us_file",
+		},
+		{
+			name:        "flags_override_env",
+			defaultFile: `{"addr": "https://file.example.com", "api_key": "us_file"}`,
+			env:         map[string]string{envAddr: "https://env.example.com", envAPIKey: "us_env"},
+			args:        []string{"-addr", "https://flag.example.com", "-api-key", "us_flag"},
+			respAddr:    "https://flag.example.com",
+			respAPIKey:  "us_flag",
+		},
+		{
+			name:        "default_flags_do_not_override_env",
+			defaultFile: `{"api_key": "us_file"}`,
+			env:         map[string]string{envAPIKey: "us_env"},
+			args:        []string{"-timeout", "1s"},
+			respAddr:    defaultAddr,
+			respAPIKey:  "us_env",
+		},
+		{
+			name:    "missing_explicit_file",
+			args:    []string{"-config", "missing.json"},
+			respErr: true,
+		},
+		{
+			name:    "missing_env_file",
+			env:     map[string]string{envConfig: "missing.json"},
+			respErr: true,
+		},
+		{
+			name:        "invalid_file",
+			defaultFile: `{"addr": `,
+			respErr:     true,
+		},
+		{
+			name:    "invalid_output",
+			args:    []string{"-output", "yaml"},
+			respErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configDir := isolateConfig(t)
+			if tt.defaultFile != "" {
+				writeConfig(t, filepath.Join(configDir, "config.json"), tt.defaultFile)
+			}
+			if tt.envFile != "" {
+				path := filepath.Join(configDir, "env.json")
+				writeConfig(t, path, tt.envFile)
+				t.Setenv(envConfig, path)
+			}
+			writeConfig(t, filepath.Join(configDir, "flag.json"), `{"addr": "https://flag-file.example.com"}`)
+			for key, val := range tt.env {
+				if key == envConfig {
+					val = filepath.Join(configDir, val)
+				}
+				t.Setenv(key, val)
+			}
+			args := append([]string(nil), tt.args...)
+			for i := range args {
+				if i > 0 && args[i-1] == "-config" {
+					args[i] = filepath.Join(configDir, args[i])
+				}
+			}
+
+			fs := newFlagSet("test", "", "")
+			resolve := commonFlags(fs, time.Second)
+			if _, err := parseFlags(fs, args, 0); err != nil {
+				t.Fatalf("failed to parse flags: %s", err)
+			}
+			cfg, c, err := resolve()
+			if tt.respErr {
+				if exitCode(err) != exitUsage {
+					t.Fatalf("expected usage error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if c == nil {
+				t.Fatalf("expected client to be created")
+			}
+			if cfg.Addr != tt.respAddr {
+				t.Fatalf("unexpected addr, expected %q, got %q", tt.respAddr, cfg.Addr)
+			}
+			if cfg.APIKey != tt.respAPIKey {
+				t.Fatalf("unexpected API key, expected %q, got %q", tt.respAPIKey, cfg.APIKey)
+			}
+		})
+	}
+}
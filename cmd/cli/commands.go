@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jemgunay/url-shortener/client"
+)
+
+const (
+	// defaultTimeout is the default maximum duration of a request for a single link.
+	defaultTimeout = time.Second * 10
+	// defaultTransferTimeout is the default maximum duration of a request which streams many links.
+	defaultTransferTimeout = time.Minute * 5
+)
+
+// shortenCommand shortens a single URL.
+func shortenCommand(args []string, out io.Writer) error {
+	fs := newFlagSet("shorten", "<url>", "Shorten a URL.")
+	resolve := commonFlags(fs, defaultTimeout)
+	alias := fs.String("alias", "", "a custom alias to use as the hash")
+	ttl := fs.Duration("ttl", 0, "the duration after which the link expires, i.e. 36h")
+	expiresAt := fs.String("expires-at", "", "the RFC 3339 time at which the link expires")
+	redirectStatus := fs.Int("redirect-status", 0, "the status to redirect with (301/302/307/308); the server's default if unset")
+	domain := fs.String("domain", "", "the domain to create the link on, if the server serves multiple domains")
+	positional, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, c, err := resolve()
+	if err != nil {
+		return err
+	}
+
+	shorten := client.ShortenRequest{
+		OriginalURL:    positional[0],
+		CustomAlias:    *alias,
+		TTL:            *ttl,
+		RedirectStatus: *redirectStatus,
+		Domain:         *domain,
+	}
+	if *expiresAt != "" {
+		expiry, err := time.Parse(time.RFC3339, *expiresAt)
+		if err != nil {
+			return usageError{fmt.Errorf("expires-at must be an RFC 3339 time: %s", err)}
+		}
+		shorten.ExpiresAt = &expiry
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	result, err := c.Shorten(ctx, shorten)
+	if err != nil {
+		return err
+	}
+
+	return render(cfg, out, result, func(w io.Writer) {
+		fmt.Fprintln(w, result.ShortURL)
+	}, func(w io.Writer) {
+		writeLinks(w, []client.Link{result})
+	})
+}
+
+// batchCommand shortens every URL in a file (one per line) with a single request, streaming the URLs to the server as
+// NDJSON.
+func batchCommand(args []string, out io.Writer) error {
+	fs := newFlagSet("batch", "", "Shorten every URL of a file, one per line.")
+	resolve := commonFlags(fs, defaultTransferTimeout)
+	file := fs.String("file", "-", "the file of URLs to shorten; - reads from stdin")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	cfg, c, err := resolve()
+	if err != nil {
+		return err
+	}
+
+	src, err := openInput(*file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// encode each URL as it is read, so that large files are not buffered in memory
+	body, bodyWriter := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(bodyWriter)
+		scanner := bufio.NewScanner(src)
+		for scanner.Scan() {
+			originalURL := strings.TrimSpace(scanner.Text())
+			if originalURL == "" {
+				continue
+			}
+			if err := enc.Encode(client.ShortenRequest{OriginalURL: originalURL}); err != nil {
+				bodyWriter.CloseWithError(err)
+				return
+			}
+		}
+		bodyWriter.CloseWithError(scanner.Err())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	result, err := c.ShortenBatchStream(ctx, body)
+	if err != nil {
+		return err
+	}
+
+	err = render(cfg, out, result, func(w io.Writer) {
+		for _, item := range result.Results {
+			if item.Link != nil {
+				fmt.Fprintln(w, item.Link.ShortURL)
+			}
+		}
+	}, func(w io.Writer) {
+		fmt.Fprintln(w, "INDEX\tSTATUS\tRESULT")
+		for _, item := range result.Results {
+			switch {
+			case item.Link != nil:
+				fmt.Fprintf(w, "%d\t%d\t%s -> %s\n", item.Index, item.Status, item.Link.ShortURL, item.Link.OriginalURL)
+			case item.Error != nil:
+				fmt.Fprintf(w, "%d\t%d\t%s (code: %s)\n", item.Index, item.Status, item.Error.Message, item.Error.Code)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return partialError{failed: result.Failed, total: result.Failed + result.Succeeded}
+	}
+	return nil
+}
+
+// lookupCommand looks up the URL which a hash redirects to, as any visitor would.
+func lookupCommand(args []string, out io.Writer) error {
+	fs := newFlagSet("lookup", "<hash>", "Lookup the URL which a hash redirects to.")
+	resolve := commonFlags(fs, defaultTimeout)
+	positional, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, c, err := resolve()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	result, err := c.Lookup(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+
+	return render(cfg, out, result, func(w io.Writer) {
+		fmt.Fprintln(w, result.Location)
+	}, func(w io.Writer) {
+		fmt.Fprintln(w, "HASH\tLOCATION\tSTATUS")
+		fmt.Fprintf(w, "%s\t%s\t%d\n", result.Hash, result.Location, result.Status)
+	})
+}
+
+// listCommand lists a page of links, or every link with -all.
+func listCommand(args []string, out io.Writer) error {
+	fs := newFlagSet("list", "", "List the links which the API key may manage, ordered by hash.")
+	resolve := commonFlags(fs, defaultTimeout)
+	limit := fs.Int("limit", 0, "the maximum number of links per page; the server's default if unset")
+	cursor := fs.String("cursor", "", "the next_cursor of the previous page")
+	domain := fs.String("domain", "", "only list the links of this domain, if the server serves multiple domains")
+	all := fs.Bool("all", false, "list every page of links")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	cfg, c, err := resolve()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	list := client.ListRequest{Limit: *limit, Cursor: *cursor, Domain: *domain}
+	result := client.LinkPage{Links: []client.Link{}}
+	for {
+		page, err := c.ListLinks(ctx, list)
+		if err != nil {
+			return err
+		}
+		result.Links = append(result.Links, page.Links...)
+		result.NextCursor, list.Cursor = page.NextCursor, page.NextCursor
+		if !*all || page.NextCursor == "" {
+			break
+		}
+	}
+
+	return render(cfg, out, result, func(w io.Writer) {
+		for _, l := range result.Links {
+			fmt.Fprintln(w, l.ShortURL)
+		}
+	}, func(w io.Writer) {
+		writeLinks(w, result.Links)
+		if result.NextCursor != "" {
+			fmt.Fprintf(os.Stderr, "more links are available: pass -cursor=%s or -all\n", result.NextCursor)
+		}
+	})
+}
+
+// deleteResult is the outcome of deleting a link.
+type deleteResult struct {
+	Deleted string `json:"deleted"`
+}
+
+// deleteCommand deletes a link.
+func deleteCommand(args []string, out io.Writer) error {
+	fs := newFlagSet("delete", "<hash>", "Delete a link.")
+	resolve := commonFlags(fs, defaultTimeout)
+	domain := fs.String("domain", "", "the domain of the link, if the server serves multiple domains")
+	positional, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, c, err := resolve()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	if err := c.DeleteLink(ctx, positional[0], linkOptions(*domain)...); err != nil {
+		return err
+	}
+
+	result := deleteResult{Deleted: positional[0]}
+	return render(cfg, out, result, func(w io.Writer) {
+		fmt.Fprintln(w, result.Deleted)
+	}, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted %s\n", result.Deleted)
+	})
+}
+
+// statsCommand shows the click analytics of a link.
+func statsCommand(args []string, out io.Writer) error {
+	fs := newFlagSet("stats", "<hash>", "Show the click analytics of a link.")
+	resolve := commonFlags(fs, defaultTimeout)
+	domain := fs.String("domain", "", "the domain of the link, if the server serves multiple domains")
+	positional, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, c, err := resolve()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	result, err := c.Stats(ctx, positional[0], linkOptions(*domain)...)
+	if err != nil {
+		return err
+	}
+
+	return render(cfg, out, result, func(w io.Writer) {
+		fmt.Fprintln(w, result.Clicks)
+	}, func(w io.Writer) {
+		fmt.Fprintf(w, "Hash\t%s\n", result.Hash)
+		fmt.Fprintf(w, "Clicks\t%d\n", result.Clicks)
+		fmt.Fprintf(w, "Unique clients\t%d\n", result.UniqueClients)
+		fmt.Fprintf(w, "First click\t%s\n", formatTime(result.FirstClick))
+		fmt.Fprintf(w, "Last click\t%s\n", formatTime(result.LastClick))
+
+		writeCounts(w, "REFERRER", result.Referrers)
+		writeCounts(w, "USER AGENT", result.UserAgents)
+
+		if len(result.Histogram) > 0 {
+			fmt.Fprintln(w, "\nFROM\tCLICKS")
+		}
+		for _, bucket := range result.Histogram {
+			fmt.Fprintf(w, "%s\t%d\n", formatTime(&bucket.Start), bucket.Clicks)
+		}
+	})
+}
+
+// writeCounts writes a table of click counts under the given heading, ordered from the most clicks. Nothing is written
+// if there are no counts.
+func writeCounts(w io.Writer, heading string, counts map[string]uint64) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return counts[keys[i]] > counts[keys[j]]
+	})
+	if len(keys) > 0 {
+		fmt.Fprintf(w, "\n%s\tCLICKS\n", heading)
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%d\n", key, counts[key])
+	}
+}
+
+// exportCommand streams every link to a file.
+func exportCommand(args []string, out io.Writer) error {
+	fs := newFlagSet("export", "", "Export every link to a file (requires an admin API key if keys are enabled).")
+	resolve := commonFlags(fs, defaultTransferTimeout)
+	format := fs.String("format", client.FormatNDJSON, "the file format (ndjson/csv)")
+	file := fs.String("file", "-", "the file to export to; - writes to stdout")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	cfg, c, err := resolve()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	export, err := c.Export(ctx, *format)
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+
+	dst := out
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %s", err)
+		}
+		defer f.Close()
+		dst = f
+	}
+
+	if _, err := io.Copy(dst, export); err != nil {
+		if errors.Is(err, client.ErrIncompleteExport) {
+			return fmt.Errorf("the server stopped part way through, so the export is truncated: %w", err)
+		}
+		return fmt.Errorf("failed to write export: %s", err)
+	}
+	return nil
+}
+
+// importCommand streams the links of a file to the server.
+func importCommand(args []string, out io.Writer) error {
+	fs := newFlagSet("import", "", "Import links from a file (requires an admin API key if keys are enabled).")
+	resolve := commonFlags(fs, defaultTransferTimeout)
+	format := fs.String("format", client.FormatNDJSON, "the file format (ndjson/csv)")
+	conflict := fs.String("conflict", client.ConflictSkip, "how to import links which already exist (skip/overwrite/fail)")
+	file := fs.String("file", "-", "the file to import; - reads from stdin")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	cfg, c, err := resolve()
+	if err != nil {
+		return err
+	}
+
+	src, err := openInput(*file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	result, err := c.Import(ctx, *format, *conflict, src)
+	if err != nil {
+		return err
+	}
+
+	err = render(cfg, out, result, func(w io.Writer) {
+		fmt.Fprintln(w, result.Imported)
+	}, func(w io.Writer) {
+		fmt.Fprintf(w, "imported %d, skipped %d, failed %d\n", result.Imported, result.Skipped, result.Failed)
+		if len(result.Errors) > 0 {
+			fmt.Fprintln(w, "\nLINE\tCODE\tERROR")
+		}
+		for _, lineErr := range result.Errors {
+			msg := lineErr.Message
+			for _, detail := range lineErr.Details {
+				msg += fmt.Sprintf("; %s %s", detail.Field, detail.Reason)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", lineErr.Line, lineErr.Code, msg)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if result.Aborted {
+		fmt.Fprintln(os.Stderr, "import stopped before the end of the file")
+	}
+	if result.Failed > 0 {
+		return partialError{failed: result.Failed, total: result.Imported + result.Skipped + result.Failed}
+	}
+	return nil
+}
+
+// openInput opens the given file for reading, or stdin if it is "-".
+func openInput(file string) (io.ReadCloser, error) {
+	if file == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %s", err)
+	}
+	return f, nil
+}
+
+// linkOptions returns the options selecting a link's domain, which are empty if no domain is given.
+func linkOptions(domain string) []client.LinkOption {
+	if domain == "" {
+		return nil
+	}
+	return []client.LinkOption{client.InDomain(domain)}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jemgunay/url-shortener/client"
+)
+
+// Output formats of command results.
+const (
+	// outputTable writes results as human readable, aligned columns.
+	outputTable = "table"
+	// outputJSON writes results as indented JSON, in the shape returned by the server.
+	outputJSON = "json"
+	// outputPlain writes only the essential value of a result (i.e. the short URL), for use in scripts.
+	outputPlain = "plain"
+)
+
+// render writes a result in the configured output format. result is JSON encoded for the json output, while plain and
+// table write the other formats.
+func render(cfg config, out io.Writer, result interface{}, plain, table func(w io.Writer)) error {
+	switch cfg.output {
+	case outputJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return fmt.Errorf("failed to JSON encode result: %s", err)
+		}
+	case outputPlain:
+		plain(out)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		table(tw)
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("failed to write result: %s", err)
+		}
+	}
+	return nil
+}
+
+// writeLinks writes a table of links.
+func writeLinks(w io.Writer, links []client.Link) {
+	fmt.Fprintln(w, "HASH\tSHORT URL\tORIGINAL URL\tCREATED\tEXPIRES")
+	for _, l := range links {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.ShortHash, l.ShortURL, l.OriginalURL, formatTime(l.CreatedAt), formatTime(l.ExpiresAt))
+	}
+}
+
+// formatTime formats an optional time for a table, using "-" if it is not set.
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/url-shortener/client"
+)
+
+// tableRows splits tabwriter output into the fields of each row, so that tables can be compared without depending on
+// their column widths.
+func tableRows(output string) [][]string {
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		rows = append(rows, strings.Fields(line))
+	}
+	return rows
+}
+
+func TestRender(t *testing.T) {
+	result := deleteResult{Deleted: "abc123"}
+	plain := func(w io.Writer) {
+		fmt.Fprintln(w, result.Deleted)
+	}
+	table := func(w io.Writer) {
+		fmt.Fprintln(w, "A\tLONGER HEADER")
+		fmt.Fprintf(w, "longer value\t%s\n", result.Deleted)
+	}
+
+	tests := []struct {
+		name     string
+		output   string
+		respBody string
+	}{
+		{
+			name:     "table",
+			output:   outputTable,
+			respBody: "A             LONGER HEADER\nlonger value  abc123\n",
+		},
+		{
+			name:     "json",
+			output:   outputJSON,
+			respBody: "{\n  \"deleted\": \"abc123\"\n}\n",
+		},
+		{
+			name:     "plain",
+			output:   outputPlain,
+			respBody: "abc123\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			if err := render(config{output: tt.output}, out, result, plain, table); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if out.String() != tt.respBody {
+				t.Fatalf("unexpected output, expected %q, got %q", tt.respBody, out.String())
+			}
+		})
+	}
+}
+
+func TestWriteLinks(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	links := []client.Link{
+		{ShortHash: "abc123", ShortURL: "http://sho.rt/abc123", OriginalURL: "https://jemgunay.co.uk", CreatedAt: &createdAt},
+		{ShortHash: "def456", ShortURL: "http://sho.rt/def456", OriginalURL: "https://jemgunay.co.uk/blog"},
+	}
+
+	out := &bytes.Buffer{}
+	writeLinks(out, links)
+
+	expected := [][]string{
+		{"HASH", "SHORT", "URL", "ORIGINAL", "URL", "CREATED", "EXPIRES"},
+		{"abc123", "http://sho.rt/abc123", "https://jemgunay.co.uk", "2024-01-02", "03:04:05", "-"},
+		{"def456", "http://sho.rt/def456", "https://jemgunay.co.uk/blog", "-", "-"},
+	}
+	if rows := tableRows(out.String()); !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("unexpected table, expected %q, got %q", expected, rows)
+	}
+}
+
+func TestCommands_Output(t *testing.T) {
+	isolateConfig(t)
+	server := newTestServer(t, []string{"abc123", "def456"})
+
+	if _, code := runCLI("shorten", "https://jemgunay.co.uk", "-addr", server.URL); code != exitOK {
+		t.Fatalf("unexpected exit code for shorten, expected %d, got %d", exitOK, code)
+	}
+
+	tests := []struct {
+		name string
+		// setup is run before each output format, so that the command can be repeated
+		setup     []string
+		args      []string
+		respPlain string
+		respTable [][]string
+		respJSON  interface{}
+	}{
+		{
+			name:      "shorten",
+			setup:     []string{"delete", "blog"},
+			args:      []string{"shorten", "https://jemgunay.co.uk/blog", "-alias", "blog"},
+			respPlain: server.URL + "/blog\n",
+			respTable: [][]string{
+				{"HASH", "SHORT", "URL", "ORIGINAL", "URL", "CREATED", "EXPIRES"},
+				{"blog", server.URL + "/blog", "https://jemgunay.co.uk/blog", "-", "-"},
+			},
+			respJSON: map[string]interface{}{
+				"short_url":    server.URL + "/blog",
+				"short_hash":   "blog",
+				"original_url": "https://jemgunay.co.uk/blog",
+			},
+		},
+		{
+			name:      "lookup",
+			args:      []string{"lookup", "abc123"},
+			respPlain: "https://jemgunay.co.uk\n",
+			respTable: [][]string{
+				{"HASH", "LOCATION", "STATUS"},
+				{"abc123", "https://jemgunay.co.uk", "301"},
+			},
+			respJSON: map[string]interface{}{
+				"hash":     "abc123",
+				"location": "https://jemgunay.co.uk",
+				"status":   float64(301),
+			},
+		},
+		{
+			name:      "delete",
+			setup:     []string{"shorten", "https://jemgunay.co.uk", "-alias", "abc123"},
+			args:      []string{"delete", "abc123"},
+			respPlain: "abc123\n",
+			respTable: [][]string{
+				{"deleted", "abc123"},
+			},
+			respJSON: map[string]interface{}{
+				"deleted": "abc123",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, output := range []string{outputPlain, outputTable, outputJSON} {
+			t.Run(tt.name+"_"+output, func(t *testing.T) {
+				if tt.setup != nil {
+					runCLI(append(tt.setup, "-addr", server.URL)...)
+				}
+
+				out, code := runCLI(append(tt.args, "-addr", server.URL, "-output", output)...)
+				if code != exitOK {
+					t.Fatalf("unexpected exit code, expected %d, got %d", exitOK, code)
+				}
+
+				switch output {
+				case outputPlain:
+					if out != tt.respPlain {
+						t.Fatalf("unexpected output, expected %q, got %q", tt.respPlain, out)
+					}
+				case outputTable:
+					if rows := tableRows(out); !reflect.DeepEqual(rows, tt.respTable) {
+						t.Fatalf("unexpected table, expected %q, got %q", tt.respTable, rows)
+					}
+				case outputJSON:
+					var body interface{}
+					if err := json.Unmarshal([]byte(out), &body); err != nil {
+						t.Fatalf("failed to JSON decode output %q: %s", out, err)
+					}
+					if !reflect.DeepEqual(body, tt.respJSON) {
+						t.Fatalf("unexpected JSON, expected %v, got %v", tt.respJSON, body)
+					}
+				}
+			})
+		}
+	}
+}
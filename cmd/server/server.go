@@ -1,31 +1,365 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/jemgunay/url-shortener/analytics"
 	"github.com/jemgunay/url-shortener/api"
 	"github.com/jemgunay/url-shortener/hash"
+	"github.com/jemgunay/url-shortener/metrics"
 	"github.com/jemgunay/url-shortener/store"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
+	if err := run(); err != nil {
+		slog.Error("server exited with an error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run starts the server and blocks until it fails or is shut down by a SIGINT/SIGTERM signal, at which point in-flight
+// requests are drained and the storage is flushed and closed.
+func run() error {
 	port := flag.Int("port", 8080, "the HTTP server port")
+	storageType := flag.String("storage", "memory", "the storage medium for short URLs (memory/file/sql)")
+	dataDir := flag.String("data-dir", "data", "the directory to persist short URLs in when using file storage")
+	compactInterval := flag.Duration("compact-interval", time.Minute*5, "how often file storage compacts its write-ahead log")
+	reapInterval := flag.Duration("reap-interval", time.Minute, "how often expired short URLs are deleted from storage; 0 disables the reaper")
+	analyticsBuffer := flag.Int("analytics-buffer", 4096, "the number of clicks buffered for analytics before clicks are dropped")
+	analyticsBucket := flag.Duration("analytics-bucket", time.Hour, "the time window of each click analytics histogram bucket")
+	hasherType := flag.String("hasher", "timestamp", "the hash strategy (timestamp/counter/random)")
+	hashLength := flag.Int("hash-length", 8, "the length of random hashes")
+	hashSalt := flag.String("hash-salt", "", "the salt which counter hashes are encoded with")
+	batchWorkers := flag.Int("batch-workers", 8, "the number of items of each batch shorten request which are shortened concurrently")
+	dedup := flag.Bool("dedup", false, "return the existing short URL when shortening a URL which has already been shortened")
+	redirectStatus := flag.Int("redirect-status", http.StatusMovedPermanently, "the default redirect status code for short URLs (301/302/307/308)")
+	dbDriver := flag.String("db-driver", "sqlite3", "the database/sql driver to use for SQL storage")
+	dsn := flag.String("dsn", "", "the data source name of the database to use for SQL storage")
+	storageTimeout := flag.Duration("storage-timeout", time.Second*5, "the maximum duration of each storage operation; 0 disables the timeout")
+	hashTimeout := flag.Duration("hash-timeout", time.Second, "the maximum duration of generating each hash; 0 disables the timeout")
+	shortenRate := flag.Float64("shorten-rate", 1, "the number of shorten requests per second each client regains; 0 disables the limit")
+	shortenBurst := flag.Int("shorten-burst", 10, "the number of shorten requests each client may burst")
+	redirectRate := flag.Float64("redirect-rate", 20, "the number of redirects per second each client regains; 0 disables the limit")
+	redirectBurst := flag.Int("redirect-burst", 100, "the number of redirects each client may burst")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated IPs/CIDRs of proxies whose X-Forwarded-For headers are trusted")
+	baseURL := flag.String("base-url", "", "the public base URL of short URLs, i.e. https://sho.rt; derived from each request if unset")
+	domains := flag.String("domains", "", "comma separated domains which links are namespaced by, the first being the default; links are not namespaced if unset")
+	trustForwarded := flag.Bool("trust-forwarded-headers", false, "derive short URLs from the X-Forwarded-Host/Proto headers set by trusted proxies")
+	keysFile := flag.String("keys-file", "", "the file to store API keys in; API key authentication is disabled if unset")
+	readTimeout := flag.Duration("read-timeout", time.Second*10, "the maximum duration for reading an entire request, including the body")
+	readHeaderTimeout := flag.Duration("read-header-timeout", time.Second*5, "the maximum duration for reading request headers")
+	writeTimeout := flag.Duration("write-timeout", time.Second*15, "the maximum duration before timing out writes of a response")
+	idleTimeout := flag.Duration("idle-timeout", time.Minute*2, "the maximum duration to wait for the next request on a keep-alive connection")
+	transferTimeout := flag.Duration("transfer-timeout", time.Minute*10, "the maximum duration of an export, import or batch shorten, which replaces the read and write timeouts; 0 disables the timeout")
+	maxHeaderBytes := flag.Int("max-header-bytes", 1<<16, "the maximum size of request headers in bytes")
+	shutdownTimeout := flag.Duration("shutdown-timeout", time.Second*20, "the maximum duration to drain in-flight requests for on shutdown")
+	logFormat := flag.String("log-format", "json", "the log output format (json/text)")
+	logLevel := flag.String("log-level", "info", "the minimum level of logs to output (debug/info/warn/error)")
 	flag.Parse()
 
-	// create hasher and handler instances
-	hasher := hash.New()
-	storage := store.New()
-	apiHandlers := api.New(hasher, storage)
+	logger, err := newLogger(*logFormat, *logLevel)
+	if err != nil {
+		return err
+	}
+	// route logs written with the standard log package through the structured logger too
+	slog.SetDefault(logger)
+
+	if !api.ValidRedirectStatus(*redirectStatus) {
+		return fmt.Errorf("unsupported redirect-status arg: %d", *redirectStatus)
+	}
+	if *batchWorkers < 1 {
+		return fmt.Errorf("invalid batch-workers arg: %d, must be at least 1", *batchWorkers)
+	}
+	if *analyticsBuffer < 0 {
+		return fmt.Errorf("invalid analytics-buffer arg: %d, must not be negative", *analyticsBuffer)
+	}
+
+	proxies, err := api.ParseTrustedProxies(*trustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted-proxies arg: %s", err)
+	}
+	var base *url.URL
+	if *baseURL != "" {
+		base, err = api.ParseBaseURL(*baseURL)
+		if err != nil {
+			return fmt.Errorf("invalid base-url arg: %s", err)
+		}
+	}
+	var linkDomains []string
+	if *domains != "" {
+		linkDomains, err = api.ParseDomains(*domains)
+		if err != nil {
+			return fmt.Errorf("invalid domains arg: %s", err)
+		}
+	}
+	if *trustForwarded && len(proxies) == 0 {
+		return errors.New("the trust-forwarded-headers arg requires trusted-proxies to be set")
+	}
+
+	shortenLimiter, err := newRateLimiter("shorten", *shortenRate, *shortenBurst)
+	if err != nil {
+		return err
+	}
+	redirectLimiter, err := newRateLimiter("redirect", *redirectRate, *redirectBurst)
+	if err != nil {
+		return err
+	}
+
+	var keys *api.KeyStore
+	if *keysFile != "" {
+		keys, err = api.LoadKeyStore(*keysFile)
+		if err != nil {
+			return fmt.Errorf("failed to load API keys: %s", err)
+		}
+		// bootstrap an admin key so that further keys can be created via the API
+		adminKey, err := keys.EnsureAdmin()
+		if err != nil {
+			return fmt.Errorf("failed to create admin API key: %s", err)
+		}
+		if adminKey != "" {
+			// the secret is written to stderr directly rather than logged, so that it never reaches a log aggregator
+			logger.Warn("created admin API key, it has been written to stderr and will not be shown again")
+			fmt.Fprintf(os.Stderr, "admin API key: %s\n", adminKey)
+		}
+	}
+
+	// create the configured storage
+	var storage store.Storage
+	switch *storageType {
+	case "memory":
+		storage = store.New()
+	case "file":
+		fileStore, err := store.NewFile(*dataDir, *compactInterval)
+		if err != nil {
+			return fmt.Errorf("failed to create file storage: %s", err)
+		}
+		storage = fileStore
+	case "sql":
+		if *dsn == "" {
+			return errors.New("a dsn arg is required for SQL storage")
+		}
+		sqlStore, err := store.OpenSQL(*dbDriver, *dsn)
+		if err != nil {
+			return fmt.Errorf("failed to create SQL storage: %s", err)
+		}
+		storage = sqlStore
+	default:
+		return fmt.Errorf("unsupported storage arg: %s", *storageType)
+	}
+
+	// flush and close the storage on exit; deferred first so that it runs after everything using the storage has stopped
+	if closer, ok := storage.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				logger.Error("failed to close storage", "error", err)
+				return
+			}
+			logger.Info("storage closed")
+		}()
+	}
+
+	// record clicks in the background so that redirects are not delayed
+	recorder := analytics.NewRecorder(*analyticsBuffer, *analyticsBucket)
+	defer recorder.Close()
+
+	baseHasher, err := newHasher(*hasherType, storage, *hashLength, *hashSalt)
+	if err != nil {
+		return err
+	}
+
+	// bound each hash and storage operation by a timeout, as well as by the request, then instrument them so that their
+	// behaviour is exposed via the metrics endpoint
+	registry := metrics.NewRegistry()
+	httpMetrics := metrics.NewHTTP(registry)
+	hasher := metrics.NewHasher(registry, hash.WithTimeout(hash.WithContext(baseHasher), *hashTimeout))
+	ctxStorage := metrics.NewStorage(registry, store.WithTimeout(store.WithContext(storage), *storageTimeout))
+
+	// create handler instances
+	opts := []api.Option{
+		api.WithRecorder(recorder),
+		api.WithDefaultRedirectStatus(*redirectStatus),
+		api.WithTrustedProxies(proxies),
+		api.WithLogger(logger),
+		api.WithBatchWorkers(*batchWorkers),
+		api.WithTransferTimeout(*transferTimeout),
+	}
+	if base != nil {
+		opts = append(opts, api.WithBaseURL(base))
+	}
+	if *trustForwarded {
+		opts = append(opts, api.WithForwardedHeaders())
+	}
+	if linkDomains != nil {
+		opts = append(opts, api.WithDomains(linkDomains))
+	}
+	if *dedup {
+		opts = append(opts, api.WithDedup())
+	}
+	if keys != nil {
+		opts = append(opts, api.WithKeyStore(keys))
+	}
+	apiHandlers := api.New(hasher, ctxStorage, opts...)
+
+	// periodically delete expired short URLs, along with the click analytics of removed links, so that neither storage
+	// nor analytics grow forever
+	if reaper, ok := storage.(store.Reaper); ok {
+		stopReaper := store.StartReaper(apiHandlers.StatsReaper(reaper), *reapInterval)
+		defer stopReaper()
+	}
 
 	// hook up HTTP handlers
-	http.HandleFunc("/api/v1/shorten", apiHandlers.ShortenHandler)
-	http.HandleFunc("/", apiHandlers.RedirectHandler)
+	mux := http.NewServeMux()
+	apiHandlers.Routes(mux, api.RouteConfig{
+		ShortenLimiter:  shortenLimiter,
+		RedirectLimiter: redirectLimiter,
+		Instrument:      httpMetrics.Instrument,
+		Metrics:         registry.Handler,
+	})
+
+	server := &http.Server{
+		Addr:              ":" + strconv.Itoa(*port),
+		Handler:           apiHandlers.RequestID(apiHandlers.AccessLog(mux.ServeHTTP)),
+		ReadTimeout:       *readTimeout,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
 
 	// start HTTP server
-	log.Printf("HTTP server starting on port %d", *port)
-	err := http.ListenAndServe(":"+strconv.Itoa(*port), nil)
-	log.Printf("HTTP server shut down: %s", err)
+	logger.Info("HTTP server starting", "port", *port)
+	return serve(logger, server, *shutdownTimeout)
+}
+
+// serve runs the server until it fails or a SIGINT/SIGTERM signal is received. On a signal, the server stops accepting
+// connections and in-flight requests are given up to shutdownTimeout to complete before their connections are closed.
+func serve(logger *slog.Logger, server *http.Server, shutdownTimeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("HTTP server failed: %s", err)
+	case <-ctx.Done():
+	}
+
+	// restore default signal handling so that a second signal terminates immediately
+	stop()
+	logger.Info("shutting down, draining in-flight requests", "timeout", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("failed to drain in-flight requests: %s", err)
+	}
+	logger.Info("HTTP server shut down")
+	return nil
+}
+
+// newRateLimiter creates a per-client rate limiter for the named handler, or returns nil if the rate is 0 to disable
+// rate limiting.
+func newRateLimiter(name string, rate float64, burst int) (*api.RateLimiter, error) {
+	if rate == 0 {
+		return nil, nil
+	}
+	if rate < 0 || burst < 1 {
+		return nil, fmt.Errorf("invalid %s rate limit: rate must be positive and burst at least 1", name)
+	}
+	// sweep for idle clients every minute
+	return api.NewRateLimiter(api.RateLimit{Rate: rate, Burst: burst}, time.Minute), nil
+}
+
+// newHasher creates a Hasher of the given type. Counters are started after the highest counter value of the stored
+// hashes, so that they never regenerate the hash of a stored link after a restart, even once links have been deleted.
+func newHasher(hasherType string, storage store.Storage, length int, salt string) (hash.Hasher, error) {
+	switch hasherType {
+	case "timestamp":
+		return hash.New(), nil
+	case "counter":
+		counter, err := hash.NewCounter(salt, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create counter hasher: %s", err)
+		}
+		start, err := counterStart(storage, counter)
+		if err != nil {
+			return nil, err
+		}
+		counter, err = hash.NewCounter(salt, start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create counter hasher: %s", err)
+		}
+		return counter, nil
+	case "random":
+		random, err := hash.NewRandom(length)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash-length arg: %s", err)
+		}
+		return random, nil
+	}
+	return nil, fmt.Errorf("unsupported hasher arg: %s", hasherType)
+}
+
+// counterStartPageSize is the number of stored links read at a time when determining where a counter starts.
+const counterStartPageSize = 1000
+
+// counterStart returns the value after the highest value encoded by the counter's stored hashes. Stored hashes which
+// were not generated by the counter, such as custom aliases, are ignored.
+func counterStart(storage store.Storage, counter *hash.Counter) (int64, error) {
+	var start int64
+	for cursor := ""; ; {
+		entries, next, err := storage.List(cursor, counterStartPageSize)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list stored links: %s", err)
+		}
+		for _, entry := range entries {
+			// keys are prefixed by their domain if links are namespaced, and hashes never contain a slash
+			hashID := entry.Key[strings.LastIndexByte(entry.Key, '/')+1:]
+			if value, ok := counter.Decode(hashID); ok && value >= start {
+				start = value + 1
+			}
+		}
+		if next == "" {
+			return start, nil
+		}
+		cursor = next
+	}
+}
+
+// newLogger creates a structured logger which writes to stderr in the given format, filtering out logs below the given
+// level.
+func newLogger(format, level string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unsupported log-level arg: %s", level)
+	}
+	opts := &slog.HandlerOptions{Level: minLevel}
+
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("unsupported log-format arg: %s", format)
 }
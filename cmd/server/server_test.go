@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jemgunay/url-shortener/hash"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestNewHasher_CounterStart(t *testing.T) {
+	counter, err := hash.NewCounter("salt", 0)
+	if err != nil {
+		t.Fatalf("failed to create counter: %s", err)
+	}
+
+	// store counter hashes, then delete all but the last so that the count of links is below the highest value
+	storage := store.New()
+	var last string
+	for i := 0; i < 10; i++ {
+		last, err = counter.Hash("https://jemgunay.co.uk")
+		if err != nil {
+			t.Fatalf("failed to generate hash: %s", err)
+		}
+		storage.Set("jem.gy/"+last, store.Record{URL: "https://jemgunay.co.uk"})
+		if i < 9 {
+			storage.Delete("jem.gy/" + last)
+		}
+	}
+	storage.Set("blog", store.Record{URL: "https://jemgunay.co.uk/blog"})
+
+	hasher, err := newHasher("counter", storage, 0, "salt")
+	if err != nil {
+		t.Fatalf("failed to create hasher: %s", err)
+	}
+	next, err := hasher.Hash("https://jemgunay.co.uk")
+	if err != nil {
+		t.Fatalf("failed to generate hash: %s", err)
+	}
+	if value, _ := counter.Decode(next); value != 10 {
+		t.Fatalf("expected the counter to start after the highest stored value, got %d", value)
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/jemgunay/url-shortener
 
-go 1.14
+go 1.22
 
-require github.com/speps/go-hashids/v2 v2.0.1
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/speps/go-hashids/v2 v2.0.1
+)
@@ -0,0 +1,59 @@
+package hash
+
+import (
+	"context"
+	"time"
+)
+
+// ContextHasher defines the requirements for a type which can generate hashes, where generation is bounded by a
+// context. HashContext should return promptly with the context's error once it is done, so that a slow hasher (i.e. one
+// which reserves hashes from a remote service) does not hold up a request which has been cancelled or has exceeded its
+// deadline. Hasher is adapted to ContextHasher with WithContext.
+type ContextHasher interface {
+	HashContext(ctx context.Context, val string) (string, error)
+}
+
+// WithContext adapts a Hasher to ContextHasher. If the hasher already satisfies ContextHasher, it is returned as is.
+// Otherwise, the context is checked before each hash is generated; this suits hashers which do not block, such as
+// Generator, Counter and Random.
+func WithContext(hasher Hasher) ContextHasher {
+	if ctxHasher, ok := hasher.(ContextHasher); ok {
+		return ctxHasher
+	}
+	return contextAdapter{hasher: hasher}
+}
+
+// contextAdapter adapts a Hasher to ContextHasher.
+type contextAdapter struct {
+	hasher Hasher
+}
+
+// HashContext generates a hash with the adapted Hasher, unless the context is done.
+func (c contextAdapter) HashContext(ctx context.Context, val string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return c.hasher.Hash(val)
+}
+
+// WithTimeout wraps a ContextHasher so that generating each hash is bounded by the given timeout, in addition to any
+// deadline of the caller's context. A timeout of zero or less disables the timeout, returning the hasher as is.
+func WithTimeout(hasher ContextHasher, timeout time.Duration) ContextHasher {
+	if timeout <= 0 {
+		return hasher
+	}
+	return timeoutHasher{hasher: hasher, timeout: timeout}
+}
+
+// timeoutHasher bounds the generation of each hash by a ContextHasher with a timeout.
+type timeoutHasher struct {
+	hasher  ContextHasher
+	timeout time.Duration
+}
+
+// HashContext generates a hash with the wrapped hasher within the timeout.
+func (t timeoutHasher) HashContext(ctx context.Context, val string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.hasher.HashContext(ctx, val)
+}
@@ -0,0 +1,44 @@
+package hash
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingHasher is a ContextHasher which blocks until its context is done.
+type blockingHasher struct{}
+
+// HashContext blocks until the context is done, then returns its error.
+func (blockingHasher) HashContext(ctx context.Context, _ string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestWithContext(t *testing.T) {
+	hasher := WithContext(Generator{EpochFunc: func() int64 { return 1000000000000000000 }})
+
+	hashID, err := hasher.HashContext(context.Background(), "test")
+	if err != nil || hashID != "1p8Znm3qNwBX" {
+		t.Fatalf("unexpected hash %q: %v", hashID, err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := hasher.HashContext(cancelled, "test"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	hasher := WithTimeout(blockingHasher{}, time.Millisecond*10)
+	if _, err := hasher.HashContext(context.Background(), "test"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// a zero timeout disables the timeout
+	if _, ok := WithTimeout(blockingHasher{}, 0).(blockingHasher); !ok {
+		t.Fatalf("expected a zero timeout to return the hasher as is")
+	}
+}
@@ -0,0 +1,73 @@
+package hash
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/speps/go-hashids/v2"
+)
+
+// counterMinLength is the minimum length of hashes generated by a Counter.
+const counterMinLength = 4
+
+// Counter generates short hashes by encoding a monotonic counter with hashids, so that hashes are 4 characters long
+// for the first ~85,000 values, 5 characters long until ~3.7 million and 6 characters long until ~165 million values
+// have been generated, growing by a character for every ~44x more thereafter. Generating hashes is concurrency safe.
+//
+// Hashids is a bijection, so a Counter never generates the same hash twice: within a process, the collision probability
+// is zero. The counter is not persisted though, so it must be started above every value issued by a previous process
+// (or by another replica sharing the same storage), otherwise it will regenerate their hashes until it overtakes them.
+// Decode recovers the value of a stored hash, so that a counter can be started after the highest value in storage.
+// The hashes are not secret: they are sequential once decoded with the salt, so links can be enumerated.
+type Counter struct {
+	hashID *hashids.HashID
+	next   atomic.Int64
+}
+
+// Ensure Counter satisfies Hasher.
+var _ Hasher = (*Counter)(nil)
+
+// NewCounter creates a Counter which encodes values with the given salt, starting from the provided value.
+func NewCounter(salt string, start int64) (*Counter, error) {
+	if start < 0 {
+		return nil, errors.New("counter start must not be negative")
+	}
+
+	hashData := hashids.NewData()
+	hashData.Salt = salt
+	hashData.MinLength = counterMinLength
+
+	hashID, err := hashids.NewWithData(hashData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new hash ID from hash data: %s", err)
+	}
+
+	c := &Counter{hashID: hashID}
+	c.next.Store(start)
+	return c, nil
+}
+
+// Hash generates the hash of the next counter value. The value being shortened does not contribute to the hash.
+func (c *Counter) Hash(_ string) (string, error) {
+	n := c.next.Add(1) - 1
+	if n < 0 {
+		return "", errors.New("counter overflowed")
+	}
+
+	outputHash, err := c.hashID.EncodeInt64([]int64{n})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash counter value: %s", err)
+	}
+	return outputHash, nil
+}
+
+// Decode returns the counter value which the given hash encodes, and false if the hash was not generated by a Counter
+// with the same salt (i.e. it is a custom alias or was generated by another hasher).
+func (c *Counter) Decode(hashID string) (int64, bool) {
+	values, err := c.hashID.DecodeInt64WithError(hashID)
+	if err != nil || len(values) != 1 {
+		return 0, false
+	}
+	return values[0], true
+}
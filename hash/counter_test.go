@@ -0,0 +1,133 @@
+package hash
+
+import (
+	"sync"
+	"testing"
+	"testing/quick"
+)
+
+func TestCounter_Hash(t *testing.T) {
+	counter, err := NewCounter("test", 0)
+	if err != nil {
+		t.Fatalf("failed to create counter: %s", err)
+	}
+
+	// every hash is unique and 4-6 characters long
+	const total = 100000
+	seen := make(map[string]struct{}, total)
+	for i := 0; i < total; i++ {
+		hashedVal, err := counter.Hash("http://jemgunay.co.uk")
+		if err != nil {
+			t.Fatalf("failed to generate hash: %s", err)
+		}
+		if len(hashedVal) < 4 || len(hashedVal) > 6 {
+			t.Fatalf("unexpected hash length for %s: %d", hashedVal, len(hashedVal))
+		}
+		if _, ok := seen[hashedVal]; ok {
+			t.Fatalf("duplicate hash %s after %d hashes", hashedVal, i)
+		}
+		seen[hashedVal] = struct{}{}
+	}
+
+	if _, err := NewCounter("test", -1); err == nil {
+		t.Fatalf("expected error for negative start")
+	}
+}
+
+func TestCounter_Hash_Concurrent(t *testing.T) {
+	counter, err := NewCounter("test", 0)
+	if err != nil {
+		t.Fatalf("failed to create counter: %s", err)
+	}
+
+	const workers, perWorker = 8, 5000
+	hashes := make(chan string, workers*perWorker)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				hashedVal, err := counter.Hash("")
+				if err != nil {
+					t.Errorf("failed to generate hash: %s", err)
+					return
+				}
+				hashes <- hashedVal
+			}
+		}()
+	}
+	wg.Wait()
+	close(hashes)
+
+	seen := make(map[string]struct{}, workers*perWorker)
+	for hashedVal := range hashes {
+		if _, ok := seen[hashedVal]; ok {
+			t.Fatalf("duplicate hash %s", hashedVal)
+		}
+		seen[hashedVal] = struct{}{}
+	}
+}
+
+func TestCounter_Hash_Property(t *testing.T) {
+	// from any start, consecutive hashes are distinct, decode back to their counter values and are at least 4
+	// characters long
+	property := func(start uint32, salt string) bool {
+		counter, err := NewCounter(salt, int64(start))
+		if err != nil {
+			return false
+		}
+		first, err := counter.Hash("")
+		if err != nil {
+			return false
+		}
+		second, err := counter.Hash("")
+		if err != nil {
+			return false
+		}
+
+		firstVals, err := counter.hashID.DecodeInt64WithError(first)
+		if err != nil || len(firstVals) != 1 || firstVals[0] != int64(start) {
+			return false
+		}
+		secondVals, err := counter.hashID.DecodeInt64WithError(second)
+		if err != nil || len(secondVals) != 1 || secondVals[0] != int64(start)+1 {
+			return false
+		}
+		return first != second && len(first) >= counterMinLength && len(second) >= counterMinLength
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCounter_Decode(t *testing.T) {
+	counter, err := NewCounter("test", 90000)
+	if err != nil {
+		t.Fatalf("failed to create counter: %s", err)
+	}
+	hashedVal, err := counter.Hash("http://jemgunay.co.uk")
+	if err != nil {
+		t.Fatalf("failed to generate hash: %s", err)
+	}
+	if len(hashedVal) != 5 {
+		t.Fatalf("unexpected hash length for %s: %d", hashedVal, len(hashedVal))
+	}
+	if value, ok := counter.Decode(hashedVal); !ok || value != 90000 {
+		t.Fatalf("unexpected decoded value %d (%t)", value, ok)
+	}
+
+	// hashes which the counter did not generate are not decoded
+	other, err := NewCounter("other", 0)
+	if err != nil {
+		t.Fatalf("failed to create counter: %s", err)
+	}
+	for _, hashID := range []string{"", "blog", "not-a-hash", hashedVal + "x"} {
+		if value, ok := counter.Decode(hashID); ok {
+			t.Fatalf("expected %q not to be decoded, got %d", hashID, value)
+		}
+	}
+	if value, ok := other.Decode(hashedVal); ok && value == 90000 {
+		t.Fatalf("expected a differently salted counter not to decode the hash")
+	}
+}
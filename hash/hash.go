@@ -12,7 +12,13 @@ type Hasher interface {
 	Hash(string) (string, error)
 }
 
-// Generator generates hashes. Generating hashes is concurrency safe.
+// Generator generates hashes by encoding the current epoch with hashids, salted by the value being shortened. Hashes
+// are 12-13 characters long for nanosecond epochs. Generating hashes is concurrency safe.
+//
+// Hashes of the same value only collide if they are generated at the same epoch, so the collision probability depends
+// on the clock's resolution and the rate at which a value is shortened: negligible with a nanosecond clock, but likely
+// for concurrent shortens of the same URL on platforms with a coarse clock. Hashes of different values are encoded with
+// different alphabets, so rarely collide. Collisions are detected and retried by the API.
 type Generator struct {
 	// EpochFunc defines how epochs for hash creation are generated.
 	EpochFunc func() int64
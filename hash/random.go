@@ -0,0 +1,70 @@
+package hash
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// base62Alphabet is the alphabet of hashes generated by a Random.
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// maxUnbiasedByte is the exclusive upper bound of random bytes which map uniformly onto the base62 alphabet; bytes at
+// or above it are discarded rather than introduce modulo bias.
+const maxUnbiasedByte = 256 - 256%len(base62Alphabet)
+
+// Random generates hashes of a fixed length from base62 characters read from crypto/rand. Generating hashes is
+// concurrency safe.
+//
+// Every hash is equally likely, so with n links stored, the probability that a new hash collides with an existing one
+// is n/62^Length, and the probability of any collision amongst n generated hashes is approximately n²/(2·62^Length).
+// For example, a Length of 8 (62^8 ≈ 2.2×10^14) gives a ~1 in 218,000 chance of a new hash colliding once a billion
+// links are stored, and 50% odds of some collision after ~17 million hashes. Collisions are detected and retried by the
+// API. Hashes are unguessable, so links cannot be enumerated.
+type Random struct {
+	// Length is the number of characters in each hash.
+	Length int
+	// Rand is the source of randomness. It defaults to crypto/rand.Reader.
+	Rand io.Reader
+}
+
+// Ensure Random satisfies Hasher.
+var _ Hasher = Random{}
+
+// NewRandom creates a Random which generates hashes of the given length.
+func NewRandom(length int) (Random, error) {
+	if length < 1 {
+		return Random{}, errors.New("random hash length must be positive")
+	}
+	return Random{
+		Length: length,
+		Rand:   rand.Reader,
+	}, nil
+}
+
+// Hash generates a random hash. The value being shortened does not contribute to the hash.
+func (g Random) Hash(_ string) (string, error) {
+	source := g.Rand
+	if source == nil {
+		source = rand.Reader
+	}
+
+	outputHash := make([]byte, 0, g.Length)
+	buf := make([]byte, g.Length)
+	for len(outputHash) < g.Length {
+		if _, err := io.ReadFull(source, buf); err != nil {
+			return "", fmt.Errorf("failed to read random bytes: %s", err)
+		}
+		for _, b := range buf {
+			if int(b) >= maxUnbiasedByte {
+				continue
+			}
+			outputHash = append(outputHash, base62Alphabet[int(b)%len(base62Alphabet)])
+			if len(outputHash) == g.Length {
+				break
+			}
+		}
+	}
+	return string(outputHash), nil
+}
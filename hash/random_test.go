@@ -0,0 +1,77 @@
+package hash
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestRandom_Hash(t *testing.T) {
+	hasher, err := NewRandom(8)
+	if err != nil {
+		t.Fatalf("failed to create random hasher: %s", err)
+	}
+
+	// with 62^8 possible hashes, 100,000 hashes collide with a probability of ~2x10^-5
+	const total = 100000
+	seen := make(map[string]struct{}, total)
+	for i := 0; i < total; i++ {
+		hashedVal, err := hasher.Hash("http://jemgunay.co.uk")
+		if err != nil {
+			t.Fatalf("failed to generate hash: %s", err)
+		}
+		if _, ok := seen[hashedVal]; ok {
+			t.Fatalf("duplicate hash %s after %d hashes", hashedVal, i)
+		}
+		seen[hashedVal] = struct{}{}
+	}
+
+	if _, err := NewRandom(0); err == nil {
+		t.Fatalf("expected error for zero length")
+	}
+}
+
+func TestRandom_Hash_Property(t *testing.T) {
+	// hashes of any length consist of exactly that many base62 characters
+	property := func(length uint8) bool {
+		hasher, err := NewRandom(int(length%64) + 1)
+		if err != nil {
+			return false
+		}
+		hashedVal, err := hasher.Hash("")
+		if err != nil {
+			return false
+		}
+		if len(hashedVal) != hasher.Length {
+			return false
+		}
+		for _, c := range hashedVal {
+			if !strings.ContainsRune(base62Alphabet, c) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRandom_Hash_Unbiased(t *testing.T) {
+	// bytes which would bias the alphabet are skipped rather than wrapped
+	hasher := Random{Length: 3, Rand: bytes.NewReader([]byte{255, 248, 0, 61, 62, 0})}
+
+	hashedVal, err := hasher.Hash("")
+	if err != nil {
+		t.Fatalf("failed to generate hash: %s", err)
+	}
+	if hashedVal != "0z0" {
+		t.Fatalf("unexpected hash, expected 0z0, got %s", hashedVal)
+	}
+
+	// exhausted sources fail rather than return a short hash
+	if _, err := (Random{Length: 8, Rand: bytes.NewReader(nil)}).Hash(""); err == nil {
+		t.Fatalf("expected error for exhausted source")
+	}
+}
@@ -1,17 +1,78 @@
 package stub
 
-import "github.com/jemgunay/url-shortener/hash"
+import (
+	"context"
+	"sync"
 
-// Stub satisfies Hasher and is used to stub out the hash Generator.
+	"github.com/jemgunay/url-shortener/hash"
+)
+
+// Stub satisfies Hasher and ContextHasher and is used to stub out the hash Generator.
 type Stub struct {
 	Val string
 	Err error
 }
 
-// Ensure Stub satisfies Hasher.
-var _ hash.Hasher = Stub{}
+// Ensure Stub satisfies Hasher and ContextHasher.
+var (
+	_ hash.Hasher        = Stub{}
+	_ hash.ContextHasher = Stub{}
+)
 
 // Hash returns the Stub's Val and Err fields.
 func (s Stub) Hash(_ string) (string, error) {
 	return s.Val, s.Err
 }
+
+// HashContext returns the Stub's Val and Err fields, unless the context is done.
+func (s Stub) HashContext(ctx context.Context, val string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return s.Hash(val)
+}
+
+// Sequence satisfies Hasher and ContextHasher and returns a predefined sequence of hashes, i.e. to simulate hash
+// collisions. Generating hashes is concurrency safe.
+type Sequence struct {
+	vals []string
+	next int
+	mu   sync.Mutex
+}
+
+// Ensure Sequence satisfies Hasher and ContextHasher.
+var (
+	_ hash.Hasher        = (*Sequence)(nil)
+	_ hash.ContextHasher = (*Sequence)(nil)
+)
+
+// NewSequence creates a Sequence which returns each of the provided values in turn. Once exhausted, the final value is
+// returned indefinitely.
+func NewSequence(vals ...string) *Sequence {
+	return &Sequence{
+		vals: vals,
+	}
+}
+
+// Hash returns the next value in the Sequence.
+func (s *Sequence) Hash(_ string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.vals) == 0 {
+		return "", nil
+	}
+	val := s.vals[s.next]
+	if s.next < len(s.vals)-1 {
+		s.next++
+	}
+	return val, nil
+}
+
+// HashContext returns the next value in the Sequence, unless the context is done.
+func (s *Sequence) HashContext(ctx context.Context, val string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return s.Hash(val)
+}
@@ -0,0 +1,199 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jemgunay/url-shortener/hash"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+// HTTP records the number and latency of requests served by HTTP handlers.
+type HTTP struct {
+	requests *CounterVec
+	duration *HistogramVec
+}
+
+// NewHTTP creates and registers the HTTP request metrics.
+func NewHTTP(registry *Registry) *HTTP {
+	return &HTTP{
+		requests: registry.NewCounterVec("http_requests_total",
+			"Total number of HTTP requests served, by handler and status code.", "handler", "code"),
+		duration: registry.NewHistogramVec("http_request_duration_seconds",
+			"Latency of HTTP requests in seconds, by handler and status code.", DefaultBuckets, "handler", "code"),
+	}
+}
+
+// Instrument records the status code and latency of every request served by the wrapped handler, labelled with the
+// given handler name.
+func (m *HTTP) Instrument(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next(recorder, r)
+
+		code := strconv.Itoa(recorder.status)
+		m.requests.Inc(handler, code)
+		m.duration.Observe(time.Since(start).Seconds(), handler, code)
+	}
+}
+
+// statusRecorder is a http.ResponseWriter which records the response status.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader records the response status and writes it.
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the response body, implicitly writing a 200 OK status if no status has been written.
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, so that a http.ResponseController can flush streamed responses.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Hasher wraps a hash.ContextHasher and counts the hashes which fail to generate.
+type Hasher struct {
+	hasher   hash.ContextHasher
+	failures *CounterVec
+}
+
+// Ensure Hasher satisfies hash.ContextHasher.
+var _ hash.ContextHasher = Hasher{}
+
+// NewHasher creates a Hasher wrapping the given hash.ContextHasher and registers its metrics.
+func NewHasher(registry *Registry, hasher hash.ContextHasher) Hasher {
+	return Hasher{
+		hasher:   hasher,
+		failures: registry.NewCounterVec("hash_generation_failures_total", "Total number of hashes which failed to generate."),
+	}
+}
+
+// HashContext generates a hash with the wrapped hash.ContextHasher, counting any failure.
+func (h Hasher) HashContext(ctx context.Context, val string) (string, error) {
+	hashID, err := h.hasher.HashContext(ctx, val)
+	if err != nil {
+		h.failures.Inc()
+	}
+	return hashID, err
+}
+
+// Storage wraps a store.ContextStorage and records the latency and outcome of each operation.
+type Storage struct {
+	storage  store.ContextStorage
+	duration *HistogramVec
+}
+
+// Ensure Storage satisfies store.ContextStorage.
+var _ store.ContextStorage = Storage{}
+
+// NewStorage creates a Storage wrapping the given store.ContextStorage and registers its metrics. If the storage, or
+// the storage adapted by it, satisfies store.Sizer, the number of stored records is also exposed.
+func NewStorage(registry *Registry, storage store.ContextStorage) Storage {
+	if sizer, ok := store.AsSizer(storage); ok {
+		registry.NewGaugeFunc("store_records", "Number of records in storage.", func() (float64, error) {
+			size, err := sizer.Len()
+			return float64(size), err
+		})
+	}
+
+	return Storage{
+		storage: storage,
+		duration: registry.NewHistogramVec("store_operation_duration_seconds",
+			"Latency of storage operations in seconds, by operation and result.", DefaultBuckets, "operation", "result"),
+	}
+}
+
+// observe records the latency of an operation which started at the given time. Expected errors such as missing keys
+// are distinguished from failures, and operations which timed out or were cancelled from other errors.
+func (s Storage) observe(operation string, start time.Time, err error) {
+	var result string
+	switch {
+	case err == nil:
+		result = "success"
+	case err == store.ErrKeyNotFound:
+		result = "not_found"
+	case err == store.ErrKeyExists:
+		result = "exists"
+	case errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	case errors.Is(err, context.Canceled):
+		result = "cancelled"
+	default:
+		result = "error"
+	}
+	s.duration.Observe(time.Since(start).Seconds(), operation, result)
+}
+
+// SetContext sets the record for a key in the wrapped storage.
+func (s Storage) SetContext(ctx context.Context, key string, record store.Record) error {
+	start := time.Now()
+	err := s.storage.SetContext(ctx, key, record)
+	s.observe("set", start, err)
+	return err
+}
+
+// SetIfAbsentContext sets the record for a key in the wrapped storage if the key does not already exist.
+func (s Storage) SetIfAbsentContext(ctx context.Context, key string, record store.Record) error {
+	start := time.Now()
+	err := s.storage.SetIfAbsentContext(ctx, key, record)
+	s.observe("set_if_absent", start, err)
+	return err
+}
+
+// UpdateContext replaces the record for a key in the wrapped storage.
+func (s Storage) UpdateContext(ctx context.Context, key string, record store.Record) error {
+	start := time.Now()
+	err := s.storage.UpdateContext(ctx, key, record)
+	s.observe("update", start, err)
+	return err
+}
+
+// GetContext returns the record for a key from the wrapped storage.
+func (s Storage) GetContext(ctx context.Context, key string) (store.Record, error) {
+	start := time.Now()
+	record, err := s.storage.GetContext(ctx, key)
+	s.observe("get", start, err)
+	return record, err
+}
+
+// GetByURLContext returns the key and record matching a URL query from the wrapped storage.
+func (s Storage) GetByURLContext(ctx context.Context, query store.URLQuery) (string, store.Record, error) {
+	start := time.Now()
+	key, record, err := s.storage.GetByURLContext(ctx, query)
+	s.observe("get_by_url", start, err)
+	return key, record, err
+}
+
+// DeleteContext removes the record for a key from the wrapped storage.
+func (s Storage) DeleteContext(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.storage.DeleteContext(ctx, key)
+	s.observe("delete", start, err)
+	return err
+}
+
+// ListContext returns a page of entries from the wrapped storage.
+func (s Storage) ListContext(ctx context.Context, cursor string, limit int) ([]store.Entry, string, error) {
+	start := time.Now()
+	entries, next, err := s.storage.ListContext(ctx, cursor, limit)
+	s.observe("list", start, err)
+	return entries, next, err
+}
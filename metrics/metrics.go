@@ -0,0 +1,330 @@
+// Package metrics implements counters, gauges and histograms which are exposed in the Prometheus text exposition
+// format, so that the service can be scraped without depending on a metrics client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram buckets used to observe latencies.
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector is a metric family which can write itself in the text exposition format.
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics to expose. It is concurrency safe.
+type Registry struct {
+	mu         *sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		mu: &sync.Mutex{},
+	}
+}
+
+// register adds a collector to the registry.
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// WriteTo writes every registered metric in the Prometheus text exposition format, in the order they were registered.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes to the underlying writer and counts the bytes written.
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// methodNotAllowedBody is the body of 405 Method Not Allowed responses, matching the API's JSON error format.
+const methodNotAllowedBody = `{"error":{"code":"method_not_allowed","message":"method %s is not allowed"}}`
+
+// Handler serves the registered metrics in the Prometheus text exposition format. Methods other than GET and HEAD are
+// responded to with a 405 Method Not Allowed in the API's JSON error format.
+func (r *Registry) Handler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(w, methodNotAllowedBody, req.Method)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := r.WriteTo(w); err != nil {
+		log.Printf("failed to write metrics: %s", err)
+	}
+}
+
+// family holds the metadata shared by every series of a metric.
+type family struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// writeHeader writes the HELP and TYPE lines of the family.
+func (f family) writeHeader(w *bufio.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, metricType)
+}
+
+// seriesKey joins label values into a map key. The separator cannot appear in valid UTF-8 label values.
+func (f family) seriesKey(labelValues []string) string {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// formatLabels formats label pairs, i.e. `{code="200",handler="shorten"}`, with any extra pairs appended.
+func (f family) formatLabels(labelValues []string, extra ...string) string {
+	pairs := make([]string, 0, len(labelValues)+len(extra)/2)
+	for i, value := range labelValues {
+		pairs = append(pairs, f.labels[i]+`="`+escapeLabelValue(value)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec is a counter partitioned by label values. It is concurrency safe.
+type CounterVec struct {
+	family
+	mu     *sync.Mutex
+	series map[string]*counterSeries
+}
+
+// counterSeries is the value of a single set of label values.
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounterVec creates and registers a counter with the given labels. A counter without labels is exposed as zero
+// until it is first incremented.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		family: family{name: name, help: help, labels: labels},
+		mu:     &sync.Mutex{},
+		series: make(map[string]*counterSeries),
+	}
+	if len(labels) == 0 {
+		c.series[""] = &counterSeries{}
+	}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter for the given label values, which must be provided in the order the labels were defined.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the given non-negative delta to the counter for the given label values.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := c.seriesKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labelValues: append([]string(nil), labelValues...)}
+		c.series[key] = s
+	}
+	s.value += delta
+}
+
+// write writes every series of the counter.
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w, "counter")
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	// series are written in a stable order between scrapes
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.formatLabels(s.labelValues), formatValue(s.value))
+	}
+}
+
+// GaugeFunc is a gauge whose value is read from a function on every scrape.
+type GaugeFunc struct {
+	family
+	fn func() (float64, error)
+}
+
+// NewGaugeFunc creates and registers a gauge which reports the value returned by fn. If fn returns an error, the gauge
+// is omitted from the scrape.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{
+		family: family{name: name, help: help},
+		fn:     fn,
+	}
+	r.register(g)
+	return g
+}
+
+// write writes the current value of the gauge.
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	value, err := g.fn()
+	if err != nil {
+		log.Printf("failed to read %s gauge: %s", g.name, err)
+		return
+	}
+
+	g.writeHeader(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(value))
+}
+
+// HistogramVec is a histogram partitioned by label values. It is concurrency safe.
+type HistogramVec struct {
+	family
+	buckets []float64
+	mu      *sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// histogramSeries holds the observations of a single set of label values.
+type histogramSeries struct {
+	labelValues []string
+	// counts holds the number of observations in each bucket, non-cumulatively
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a histogram with the given bucket upper bounds, which must be sorted in
+// increasing order. A +Inf bucket is always included.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		family:  family{name: name, help: help, labels: labels},
+		buckets: buckets,
+		mu:      &sync.Mutex{},
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram for the given label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.seriesKey(labelValues)
+	bucket := sort.SearchFloat64s(h.buckets, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	if bucket < len(h.buckets) {
+		s.counts[bucket]++
+	}
+	s.count++
+	s.sum += value
+}
+
+// write writes the cumulative buckets, sum and count of every series of the histogram.
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	// series are written in a stable order between scrapes
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(s.labelValues, "le", formatValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.formatLabels(s.labelValues), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.formatLabels(s.labelValues), s.count)
+	}
+}
+
+// formatValue formats a sample value as per the text exposition format.
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// helpEscaper escapes HELP text, where backslashes and line feeds must be escaped.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// escapeHelp escapes HELP text.
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+// labelValueEscaper escapes label values, where backslashes, double quotes and line feeds must be escaped.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes a label value.
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	hashstub "github.com/jemgunay/url-shortener/hash/stub"
+	"github.com/jemgunay/url-shortener/store"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("requests_total", "Total requests.\nSecond line.", "handler", "code")
+	histogram := registry.NewHistogramVec("latency_seconds", "Request latency.", []float64{0.1, 1}, "handler")
+	registry.NewGaugeFunc("records", "Stored records.", func() (float64, error) {
+		return 3, nil
+	})
+	registry.NewGaugeFunc("broken", "Unavailable gauge.", func() (float64, error) {
+		return 0, errors.New("unavailable")
+	})
+
+	counter.Inc("shorten", "200")
+	counter.Inc("redirect", "301")
+	counter.Add(2, "shorten", "200")
+	counter.Inc(`quo"te`, "500")
+	histogram.Observe(0.05, "shorten")
+	histogram.Observe(0.1, "shorten")
+	histogram.Observe(0.5, "shorten")
+	histogram.Observe(5, "shorten")
+
+	expected := `# HELP requests_total Total requests.\nSecond line.
+# TYPE requests_total counter
+requests_total{handler="quo\"te",code="500"} 1
+requests_total{handler="redirect",code="301"} 1
+requests_total{handler="shorten",code="200"} 3
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{handler="shorten",le="0.1"} 2
+latency_seconds_bucket{handler="shorten",le="1"} 3
+latency_seconds_bucket{handler="shorten",le="+Inf"} 4
+latency_seconds_sum{handler="shorten"} 5.65
+latency_seconds_count{handler="shorten"} 4
+# HELP records Stored records.
+# TYPE records gauge
+records 3
+`
+
+	buf := &bytes.Buffer{}
+	n, err := registry.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("failed to write metrics: %s", err)
+	}
+	if buf.String() != expected {
+		t.Fatalf("unexpected exposition, expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("unexpected byte count, expected %d, got %d", buf.Len(), n)
+	}
+}
+
+func TestInstrumentation(t *testing.T) {
+	registry := NewRegistry()
+	httpMetrics := NewHTTP(registry)
+	hasher := NewHasher(registry, hashstub.Stub{Err: errors.New("hash failure")})
+	storage := NewStorage(registry, store.WithContext(store.New()))
+
+	handler := httpMetrics.Instrument("redirect", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := storage.GetContext(r.Context(), "123456"); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/123456", nil))
+
+	storage.SetContext(context.Background(), "123456", store.Record{URL: "https://jemgunay.co.uk"})
+
+	// operations which are cancelled or time out are distinguished from failures
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	storage.DeleteContext(cancelled, "123456")
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	storage.GetContext(expired, "123456")
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/123456", nil))
+
+	if _, err := hasher.HashContext(context.Background(), "https://jemgunay.co.uk"); err == nil {
+		t.Fatalf("expected hash error to be returned")
+	}
+
+	w := httptest.NewRecorder()
+	registry.Handler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type: %s", contentType)
+	}
+
+	for _, line := range []string{
+		`http_requests_total{handler="redirect",code="200"} 1`,
+		`http_requests_total{handler="redirect",code="404"} 1`,
+		`http_request_duration_seconds_count{handler="redirect",code="404"} 1`,
+		`hash_generation_failures_total 1`,
+		`store_records 1`,
+		`store_operation_duration_seconds_count{operation="get",result="not_found"} 1`,
+		`store_operation_duration_seconds_count{operation="get",result="success"} 1`,
+		`store_operation_duration_seconds_count{operation="set",result="success"} 1`,
+		`store_operation_duration_seconds_count{operation="delete",result="cancelled"} 1`,
+		`store_operation_duration_seconds_count{operation="get",result="timeout"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", line, w.Body.String())
+		}
+	}
+}
+
+func TestRegistry_Handler_MethodNotAllowed(t *testing.T) {
+	registry := NewRegistry()
+
+	w := httptest.NewRecorder()
+	registry.Handler(w, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status, expected %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Fatalf("unexpected allow header, expected %q, got %q", "GET, HEAD", allow)
+	}
+	if expected := `{"error":{"code":"method_not_allowed","message":"method POST is not allowed"}}`; w.Body.String() != expected {
+		t.Fatalf("unexpected body, expected %s, got %s", expected, w.Body.String())
+	}
+}
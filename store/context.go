@@ -0,0 +1,188 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// ContextStorage defines the requirements for a type which can persist and retrieve key/record pairs, where each
+// operation is bounded by a context. Operations should return promptly with the context's error once it is done, so
+// that a slow backend does not hold up a request which has been cancelled or has exceeded its deadline.
+//
+// The operations behave as those of Storage. Storage is adapted to ContextStorage with WithContext.
+type ContextStorage interface {
+	SetContext(ctx context.Context, key string, record Record) error
+	SetIfAbsentContext(ctx context.Context, key string, record Record) error
+	UpdateContext(ctx context.Context, key string, record Record) error
+	GetContext(ctx context.Context, key string) (Record, error)
+	GetByURLContext(ctx context.Context, query URLQuery) (string, Record, error)
+	DeleteContext(ctx context.Context, key string) error
+	ListContext(ctx context.Context, cursor string, limit int) ([]Entry, string, error)
+}
+
+// WithContext adapts a Storage to ContextStorage. If the storage already satisfies ContextStorage (i.e. SQL), it is
+// returned as is. Otherwise, the context is checked before each operation, but an operation which has started runs to
+// completion; this suits storage which does not block, such as Store and File.
+func WithContext(storage Storage) ContextStorage {
+	if ctxStorage, ok := storage.(ContextStorage); ok {
+		return ctxStorage
+	}
+	return contextAdapter{storage: storage}
+}
+
+// contextAdapter adapts a Storage to ContextStorage.
+type contextAdapter struct {
+	storage Storage
+}
+
+// Unwrap returns the adapted Storage, so that the optional interfaces it satisfies (i.e. Sizer) can be discovered.
+func (c contextAdapter) Unwrap() Storage {
+	return c.storage
+}
+
+// SetContext sets the record for a key in the adapted Storage, unless the context is done.
+func (c contextAdapter) SetContext(ctx context.Context, key string, record Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.storage.Set(key, record)
+}
+
+// SetIfAbsentContext sets the record for a key in the adapted Storage if the key does not already exist, unless the
+// context is done.
+func (c contextAdapter) SetIfAbsentContext(ctx context.Context, key string, record Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.storage.SetIfAbsent(key, record)
+}
+
+// UpdateContext replaces the record for a key in the adapted Storage, unless the context is done.
+func (c contextAdapter) UpdateContext(ctx context.Context, key string, record Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.storage.Update(key, record)
+}
+
+// GetContext returns the record for a key from the adapted Storage, unless the context is done.
+func (c contextAdapter) GetContext(ctx context.Context, key string) (Record, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, err
+	}
+	return c.storage.Get(key)
+}
+
+// GetByURLContext returns the key and record matching a URL query from the adapted Storage, unless the context is done.
+func (c contextAdapter) GetByURLContext(ctx context.Context, query URLQuery) (string, Record, error) {
+	if err := ctx.Err(); err != nil {
+		return "", Record{}, err
+	}
+	return c.storage.GetByURL(query)
+}
+
+// DeleteContext removes the record for a key from the adapted Storage, unless the context is done.
+func (c contextAdapter) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.storage.Delete(key)
+}
+
+// ListContext returns a page of entries from the adapted Storage, unless the context is done.
+func (c contextAdapter) ListContext(ctx context.Context, cursor string, limit int) ([]Entry, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	return c.storage.List(cursor, limit)
+}
+
+// WithTimeout wraps a ContextStorage so that each operation is bounded by the given timeout, in addition to any
+// deadline of the caller's context. An operation which times out returns an error satisfying
+// errors.Is(err, context.DeadlineExceeded). A timeout of zero or less disables the timeout, returning the storage as
+// is.
+func WithTimeout(storage ContextStorage, timeout time.Duration) ContextStorage {
+	if timeout <= 0 {
+		return storage
+	}
+	return timeoutStorage{storage: storage, timeout: timeout}
+}
+
+// timeoutStorage bounds each operation of a ContextStorage with a timeout.
+type timeoutStorage struct {
+	storage ContextStorage
+	timeout time.Duration
+}
+
+// Unwrap returns the wrapped ContextStorage, so that the optional interfaces it satisfies (i.e. Sizer) can be
+// discovered.
+func (t timeoutStorage) Unwrap() ContextStorage {
+	return t.storage
+}
+
+// SetContext sets the record for a key in the wrapped storage within the timeout.
+func (t timeoutStorage) SetContext(ctx context.Context, key string, record Record) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.storage.SetContext(ctx, key, record)
+}
+
+// SetIfAbsentContext sets the record for a key in the wrapped storage if the key does not already exist, within the
+// timeout.
+func (t timeoutStorage) SetIfAbsentContext(ctx context.Context, key string, record Record) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.storage.SetIfAbsentContext(ctx, key, record)
+}
+
+// UpdateContext replaces the record for a key in the wrapped storage within the timeout.
+func (t timeoutStorage) UpdateContext(ctx context.Context, key string, record Record) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.storage.UpdateContext(ctx, key, record)
+}
+
+// GetContext returns the record for a key from the wrapped storage within the timeout.
+func (t timeoutStorage) GetContext(ctx context.Context, key string) (Record, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.storage.GetContext(ctx, key)
+}
+
+// GetByURLContext returns the key and record matching a URL query from the wrapped storage within the timeout.
+func (t timeoutStorage) GetByURLContext(ctx context.Context, query URLQuery) (string, Record, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.storage.GetByURLContext(ctx, query)
+}
+
+// DeleteContext removes the record for a key from the wrapped storage within the timeout.
+func (t timeoutStorage) DeleteContext(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.storage.DeleteContext(ctx, key)
+}
+
+// ListContext returns a page of entries from the wrapped storage within the timeout.
+func (t timeoutStorage) ListContext(ctx context.Context, cursor string, limit int) ([]Entry, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.storage.ListContext(ctx, cursor, limit)
+}
+
+// AsSizer returns the Sizer satisfied by the given storage, looking through the wrappers created by WithContext and
+// WithTimeout.
+func AsSizer(storage interface{}) (Sizer, bool) {
+	for {
+		switch s := storage.(type) {
+		case Sizer:
+			return s, true
+		case interface{ Unwrap() Storage }:
+			storage = s.Unwrap()
+		case interface{ Unwrap() ContextStorage }:
+			storage = s.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
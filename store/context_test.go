@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWithContext(t *testing.T) {
+	storage := WithContext(New())
+	ctx := context.Background()
+
+	if err := storage.SetContext(ctx, "123456", Record{URL: "https://jemgunay.co.uk"}); err != nil {
+		t.Fatalf("failed to set key: %s", err)
+	}
+	if record, err := storage.GetContext(ctx, "123456"); err != nil || record.URL != "https://jemgunay.co.uk" {
+		t.Fatalf("unexpected record %+v: %v", record, err)
+	}
+
+	// operations are not started once the context is done
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := storage.DeleteContext(cancelled, "123456"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := storage.GetContext(ctx, "123456"); err != nil {
+		t.Fatalf("expected key to remain after a cancelled delete: %s", err)
+	}
+
+	// the adapted storage is discoverable through the wrappers
+	if _, ok := AsSizer(WithTimeout(storage, time.Second)); !ok {
+		t.Fatalf("expected adapted storage to satisfy Sizer")
+	}
+}
+
+// blockingStorage is a ContextStorage whose lookups block until their context is done.
+type blockingStorage struct {
+	ContextStorage
+}
+
+// GetContext blocks until the context is done, then returns its error.
+func (blockingStorage) GetContext(ctx context.Context, _ string) (Record, error) {
+	<-ctx.Done()
+	return Record{}, ctx.Err()
+}
+
+func TestWithTimeout(t *testing.T) {
+	storage := WithTimeout(blockingStorage{WithContext(New())}, time.Millisecond*10)
+
+	start := time.Now()
+	if _, err := storage.GetContext(context.Background(), "123456"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("lookup was not bounded by the timeout, took %s", elapsed)
+	}
+
+	// a zero timeout disables the timeout
+	if _, ok := WithTimeout(WithContext(New()), 0).(timeoutStorage); ok {
+		t.Fatalf("expected a zero timeout to return the storage as is")
+	}
+}
+
+func TestSQL_Context(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sql-store")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sqlStore, err := OpenSQL("sqlite3", filepath.Join(dir, "links.db"))
+	if err != nil {
+		t.Fatalf("failed to open SQL store: %s", err)
+	}
+	defer sqlStore.Close()
+
+	// SQL supports contexts natively so is not adapted
+	if storage := WithContext(sqlStore); storage != ContextStorage(sqlStore) {
+		t.Fatalf("expected SQL store to be returned as is")
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sqlStore.SetContext(cancelled, "123456", Record{URL: "https://jemgunay.co.uk"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, _, err := sqlStore.ListContext(cancelled, "", 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := sqlStore.Get("123456"); err != ErrKeyNotFound {
+		t.Fatalf("expected cancelled set not to be stored, got %v", err)
+	}
+}
@@ -0,0 +1,435 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.log"
+)
+
+// walOp identifies the operation recorded by a walEntry.
+type walOp string
+
+const (
+	opSet    walOp = "set"
+	opDelete walOp = "delete"
+)
+
+// walEntry is a single line of the write-ahead log or snapshot.
+type walEntry struct {
+	Op     walOp   `json:"op"`
+	Key    string  `json:"key"`
+	Record *Record `json:"record,omitempty"`
+	// Value holds the original URL of set entries written before records were introduced.
+	Value string `json:"value,omitempty"`
+}
+
+// record returns the record of a set entry.
+func (e walEntry) record() Record {
+	if e.Record == nil {
+		return Record{URL: e.Value}
+	}
+	return *e.Record
+}
+
+// File is a durable key/value store. Every write is appended to an on-disk write-ahead log before being applied to an
+// in-memory Store, and the log is periodically compacted into a snapshot. On creation, the snapshot and write-ahead log
+// are replayed to restore the previous state. It satisfies the Storage, Reaper and Sizer interfaces.
+type File struct {
+	mem Store
+	dir string
+
+	// mu guards wal and ensures writes are applied to mem in the same order they are appended to the log
+	mu  *sync.Mutex
+	wal *os.File
+
+	stop    chan struct{}
+	done    chan struct{}
+	closing bool
+}
+
+// Ensure File satisfies Storage, Reaper and Sizer.
+var (
+	_ Storage = (*File)(nil)
+	_ Reaper  = (*File)(nil)
+	_ Sizer   = (*File)(nil)
+)
+
+// NewFile creates a File which persists its data in the given directory, creating the directory if it does not exist.
+// Existing data in the directory is replayed into memory. If compactInterval is greater than zero, the write-ahead log
+// is compacted into a snapshot at that interval until Close is called.
+func NewFile(dir string, compactInterval time.Duration) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %s", err)
+	}
+
+	f := &File{
+		mem:  New(),
+		dir:  dir,
+		mu:   &sync.Mutex{},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	// restore the snapshot first, then apply any writes which happened after it was taken
+	if err := f.replay(filepath.Join(dir, snapshotFileName)); err != nil {
+		return nil, fmt.Errorf("failed to replay snapshot: %s", err)
+	}
+	if err := f.replay(filepath.Join(dir, walFileName)); err != nil {
+		return nil, fmt.Errorf("failed to replay write-ahead log: %s", err)
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %s", err)
+	}
+	f.wal = wal
+
+	if compactInterval > 0 {
+		go f.compactLoop(compactInterval)
+	} else {
+		close(f.done)
+	}
+
+	return f, nil
+}
+
+// replay applies each entry in the log file at the given path to the in-memory store. A missing file is not an error.
+// A partially written final line (i.e. from a crash mid-write) is discarded and truncated from the file.
+func (f *File) replay(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("discarding partially written entry at offset %d of %s", offset, path)
+				return file.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := walEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("corrupt entry at offset %d: %s", offset, err)
+		}
+		if err := f.apply(entry); err != nil {
+			return fmt.Errorf("failed to apply entry at offset %d: %s", offset, err)
+		}
+		offset += int64(len(line))
+	}
+}
+
+// apply applies a log entry to the in-memory store.
+func (f *File) apply(entry walEntry) error {
+	switch entry.Op {
+	case opSet:
+		return f.mem.Set(entry.Key, entry.record())
+	case opDelete:
+		// deleting a key which does not exist is a no-op, i.e. if it has already been omitted from a snapshot
+		if err := f.mem.Delete(entry.Key); err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported operation %q", entry.Op)
+	}
+}
+
+// append writes an entry to the write-ahead log and syncs it to disk. The caller must hold mu.
+func (f *File) append(entry walEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal log entry: %s", err)
+	}
+	if _, err := f.wal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write log entry: %s", err)
+	}
+	if err := f.wal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log: %s", err)
+	}
+	return nil
+}
+
+// ErrClosed indicates that an operation was attempted on a storage which has been closed.
+var ErrClosed = errors.New("store is closed")
+
+// Set durably records the record for a given key. If the key exists already, the record will be overwritten.
+func (f *File) Set(key string, record Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.wal == nil {
+		return ErrClosed
+	}
+
+	entry := walEntry{Op: opSet, Key: key, Record: &record}
+	if err := f.append(entry); err != nil {
+		return err
+	}
+	return f.apply(entry)
+}
+
+// SetIfAbsent durably records the record for a given key only if the key does not already exist. If the key exists
+// already, ErrKeyExists is returned and nothing is written to the log.
+func (f *File) SetIfAbsent(key string, record Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.wal == nil {
+		return ErrClosed
+	}
+
+	// writes are serialised by mu, so the key cannot be created between this check and the append below
+	if _, err := f.mem.Get(key); err == nil {
+		return ErrKeyExists
+	}
+
+	entry := walEntry{Op: opSet, Key: key, Record: &record}
+	if err := f.append(entry); err != nil {
+		return err
+	}
+	return f.apply(entry)
+}
+
+// Update durably replaces the record for a given key. If the key is not found, ErrKeyNotFound is returned and nothing
+// is written to the log.
+func (f *File) Update(key string, record Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.wal == nil {
+		return ErrClosed
+	}
+	if _, err := f.mem.Get(key); err != nil {
+		return err
+	}
+
+	entry := walEntry{Op: opSet, Key: key, Record: &record}
+	if err := f.append(entry); err != nil {
+		return err
+	}
+	return f.apply(entry)
+}
+
+// Delete durably removes the record for a given key. If the key is not found, ErrKeyNotFound is returned and nothing
+// is written to the log.
+func (f *File) Delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.wal == nil {
+		return ErrClosed
+	}
+	if _, err := f.mem.Get(key); err != nil {
+		return err
+	}
+
+	entry := walEntry{Op: opDelete, Key: key}
+	if err := f.append(entry); err != nil {
+		return err
+	}
+	return f.apply(entry)
+}
+
+// List returns up to limit entries ordered by key, starting after the given cursor key. The returned cursor is empty
+// once every entry has been listed.
+func (f *File) List(cursor string, limit int) ([]Entry, string, error) {
+	return f.mem.List(cursor, limit)
+}
+
+// Get returns the record for a given key. If the key is not found, ErrKeyNotFound is returned. Expired records are
+// returned until they are reaped.
+func (f *File) Get(key string) (Record, error) {
+	return f.mem.Get(key)
+}
+
+// GetByURL returns the most recently set key and its record which match the query. If no record matches,
+// ErrKeyNotFound is returned.
+func (f *File) GetByURL(query URLQuery) (string, Record, error) {
+	return f.mem.GetByURL(query)
+}
+
+// Len returns the number of records in the store, including expired records which have not been reaped.
+func (f *File) Len() (int, error) {
+	return f.mem.Len()
+}
+
+// Reap durably deletes every record which has expired as of the given time, returning the number of records deleted.
+func (f *File) Reap(now time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.wal == nil {
+		return 0, ErrClosed
+	}
+
+	var reaped int
+	for _, key := range f.mem.expired(now) {
+		entry := walEntry{Op: opDelete, Key: key}
+		if err := f.append(entry); err != nil {
+			return reaped, err
+		}
+		if err := f.apply(entry); err != nil {
+			return reaped, err
+		}
+		reaped++
+	}
+	return reaped, nil
+}
+
+// Compact writes the current state of the store to a new snapshot and truncates the write-ahead log. The snapshot is
+// written to a temporary file and atomically renamed so that a crash mid-compaction leaves the previous snapshot and
+// log intact.
+func (f *File) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.wal == nil {
+		return ErrClosed
+	}
+
+	tmp, err := os.CreateTemp(f.dir, snapshotFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary snapshot: %s", err)
+	}
+	// clean up the temporary file if it was not renamed
+	defer os.Remove(tmp.Name())
+
+	if err := f.writeSnapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary snapshot: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(f.dir, snapshotFileName)); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %s", err)
+	}
+	// the rename must be durable before the log is truncated, otherwise a crash could restore the previous snapshot
+	// without the entries of the truncated log
+	if err := syncDir(f.dir); err != nil {
+		return err
+	}
+
+	// every entry in the log is now captured by the snapshot
+	if err := f.wal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate write-ahead log: %s", err)
+	}
+	if err := f.wal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log: %s", err)
+	}
+	return nil
+}
+
+// writeSnapshot writes every key/record pair in the in-memory store to w as set entries and syncs it to disk. Expired
+// records are omitted.
+func (f *File) writeSnapshot(w *os.File) error {
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+
+	now := time.Now()
+	f.mem.mu.RLock()
+	for key, record := range f.mem.lookup {
+		if record.Expired(now) {
+			continue
+		}
+		record := record
+		if err := encoder.Encode(walEntry{Op: opSet, Key: key, Record: &record}); err != nil {
+			f.mem.mu.RUnlock()
+			return fmt.Errorf("failed to write snapshot entry: %s", err)
+		}
+	}
+	f.mem.mu.RUnlock()
+
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush snapshot: %s", err)
+	}
+	if err := w.Sync(); err != nil {
+		return fmt.Errorf("failed to sync snapshot: %s", err)
+	}
+	return nil
+}
+
+// syncDir syncs the given directory to disk, making the creation, removal and renaming of its files durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open data directory: %s", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync data directory: %s", err)
+	}
+	return nil
+}
+
+// compactLoop compacts the write-ahead log at the given interval until Close is called.
+func (f *File) compactLoop(interval time.Duration) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Compact(); err != nil {
+				log.Printf("failed to compact write-ahead log: %s", err)
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Close stops background compaction, compacts the write-ahead log a final time and closes it. Subsequent writes will
+// return ErrClosed.
+func (f *File) Close() error {
+	f.mu.Lock()
+	if f.closing {
+		f.mu.Unlock()
+		return ErrClosed
+	}
+	f.closing = true
+	f.mu.Unlock()
+
+	close(f.stop)
+	<-f.done
+
+	compactErr := f.Compact()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	closeErr := f.wal.Close()
+	f.wal = nil
+
+	if compactErr != nil {
+		return compactErr
+	}
+	return closeErr
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile_Replay(t *testing.T) {
+	dir, err := os.MkdirTemp("", "file-store")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pairs := map[string]Record{
+		"123456": {URL: "https://jemgunay.co.uk"},
+		"abcdef": {URL: "https://jemgunay.co.uk/blog", ExpiresAt: time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	fileStore, err := NewFile(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to create file store: %s", err)
+	}
+	for k, v := range pairs {
+		if err := fileStore.Set(k, v); err != nil {
+			t.Fatalf("failed to set %s: %s", k, err)
+		}
+	}
+	if err := fileStore.SetIfAbsent("abcdef", Record{URL: "https://jemgunay.co.uk/clobbered"}); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	// deletions must survive replay
+	if err := fileStore.Set("deleted", Record{URL: "https://jemgunay.co.uk/leaked"}); err != nil {
+		t.Fatalf("failed to set key: %s", err)
+	}
+	if err := fileStore.Delete("deleted"); err != nil {
+		t.Fatalf("failed to delete key: %s", err)
+	}
+	// overwrite a key so that replay must apply entries in order
+	pairs["123456"] = Record{URL: "https://jemgunay.co.uk/updated"}
+	if err := fileStore.Set("123456", pairs["123456"]); err != nil {
+		t.Fatalf("failed to overwrite key: %s", err)
+	}
+
+	// simulate a crash by abandoning the store without closing it, then append a partially written entry
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("failed to open write-ahead log: %s", err)
+	}
+	if _, err := wal.WriteString(`{"op":"set","key":"torn`); err != nil {
+		t.Fatalf("failed to write partial entry: %s", err)
+	}
+	wal.Close()
+
+	replayed, err := NewFile(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to replay file store: %s", err)
+	}
+	defer replayed.Close()
+
+	for k, v := range pairs {
+		got, err := replayed.Get(k)
+		if err != nil {
+			t.Fatalf("failed to get %s: %s", k, err)
+		}
+		if got.URL != v.URL || !got.ExpiresAt.Equal(v.ExpiresAt) {
+			t.Fatalf("unexpected record for %s, expected %+v, got %+v", k, v, got)
+		}
+	}
+	if _, err := replayed.Get("torn"); err != ErrKeyNotFound {
+		t.Fatalf("expected partial entry to be discarded, got %v", err)
+	}
+	if _, err := replayed.Get("deleted"); err != ErrKeyNotFound {
+		t.Fatalf("expected deleted key to remain deleted, got %v", err)
+	}
+}
+
+func TestFile_ReplayLegacyEntries(t *testing.T) {
+	dir, err := os.MkdirTemp("", "file-store")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// entries written before records were introduced only carry the original URL
+	legacy := `{"op":"set","key":"123456","value":"https://jemgunay.co.uk"}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, walFileName), []byte(legacy), 0o644); err != nil {
+		t.Fatalf("failed to write legacy write-ahead log: %s", err)
+	}
+
+	fileStore, err := NewFile(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to replay file store: %s", err)
+	}
+	defer fileStore.Close()
+
+	got, err := fileStore.Get("123456")
+	if err != nil {
+		t.Fatalf("failed to get legacy key: %s", err)
+	}
+	if got.URL != "https://jemgunay.co.uk" {
+		t.Fatalf("unexpected URL, expected %s, got %s", "https://jemgunay.co.uk", got.URL)
+	}
+}
+
+func TestFile_Compact(t *testing.T) {
+	dir, err := os.MkdirTemp("", "file-store")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileStore, err := NewFile(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to create file store: %s", err)
+	}
+	if err := fileStore.Set("123456", Record{URL: "https://jemgunay.co.uk"}); err != nil {
+		t.Fatalf("failed to set key: %s", err)
+	}
+	if err := fileStore.Compact(); err != nil {
+		t.Fatalf("failed to compact: %s", err)
+	}
+
+	// the write-ahead log should be empty once captured by the snapshot
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatalf("failed to stat write-ahead log: %s", err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("expected empty write-ahead log after compaction, got %d bytes", info.Size())
+	}
+
+	// writes after compaction should land in the write-ahead log and survive alongside the snapshot
+	if err := fileStore.Set("abcdef", Record{URL: "https://jemgunay.co.uk/blog"}); err != nil {
+		t.Fatalf("failed to set key: %s", err)
+	}
+	if err := fileStore.Close(); err != nil {
+		t.Fatalf("failed to close file store: %s", err)
+	}
+	if err := fileStore.Set("closed", Record{URL: "https://jemgunay.co.uk"}); err != ErrClosed {
+		t.Fatalf("expected ErrClosed after close, got %v", err)
+	}
+
+	replayed, err := NewFile(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to replay file store: %s", err)
+	}
+	defer replayed.Close()
+
+	for _, k := range []string{"123456", "abcdef"} {
+		if _, err := replayed.Get(k); err != nil {
+			t.Fatalf("failed to get %s after compaction: %s", k, err)
+		}
+	}
+}
+
+func TestFile_Reap(t *testing.T) {
+	dir, err := os.MkdirTemp("", "file-store")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	fileStore, err := NewFile(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to create file store: %s", err)
+	}
+	fileStore.Set("expired", Record{URL: "https://jemgunay.co.uk", ExpiresAt: now.Add(-time.Minute)})
+	fileStore.Set("live", Record{URL: "https://jemgunay.co.uk", ExpiresAt: now.Add(time.Hour)})
+
+	reaped, err := fileStore.Reap(now)
+	if err != nil {
+		t.Fatalf("failed to reap: %s", err)
+	}
+	if reaped != 1 {
+		t.Fatalf("unexpected reap count, expected 1, got %d", reaped)
+	}
+	fileStore.Close()
+
+	// reaped records must stay deleted once the log is replayed
+	replayed, err := NewFile(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to replay file store: %s", err)
+	}
+	defer replayed.Close()
+
+	if _, err := replayed.Get("expired"); err != ErrKeyNotFound {
+		t.Fatalf("expected reaped key to be deleted, got %v", err)
+	}
+	if _, err := replayed.Get("live"); err != nil {
+		t.Fatalf("failed to get live key: %s", err)
+	}
+}
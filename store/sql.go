@@ -0,0 +1,396 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrations defines the schema of the SQL store. Each migration is applied once, in order, and recorded in the
+// schema_migrations table. Existing migrations must never be modified; schema changes are made by appending to the
+// list.
+var migrations = []string{
+	`CREATE TABLE links (
+		hash VARCHAR(255) NOT NULL PRIMARY KEY,
+		original_url TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	`ALTER TABLE links ADD COLUMN expires_at TIMESTAMP NULL`,
+	`CREATE INDEX links_original_url ON links (original_url)`,
+	`ALTER TABLE links ADD COLUMN redirect_status INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE links ADD COLUMN owner VARCHAR(255) NOT NULL DEFAULT ''`,
+}
+
+// SQL is a key/value store backed by a database/sql database, allowing multiple server replicas to share a single
+// source of truth. It satisfies the Storage, ContextStorage, Reaper and Sizer interfaces; the ContextStorage operations
+// cancel their queries once the context is done.
+//
+// Queries are written with "?" placeholders and are rewritten for drivers which use numbered placeholders (i.e.
+// postgres).
+type SQL struct {
+	db       *sql.DB
+	numbered bool
+}
+
+// Ensure SQL satisfies Storage, ContextStorage, Reaper and Sizer.
+var (
+	_ Storage        = (*SQL)(nil)
+	_ ContextStorage = (*SQL)(nil)
+	_ Reaper         = (*SQL)(nil)
+	_ Sizer          = (*SQL)(nil)
+)
+
+// OpenSQL opens a database with the given driver and data source name, then creates a SQL store from it. The driver
+// must have been registered by importing it.
+func OpenSQL(driverName, dsn string) (*SQL, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %s", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %s", err)
+	}
+
+	s, err := NewSQL(db, driverName)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSQL creates a SQL store from an open database, migrating the schema to the latest version. The driver name is
+// used to determine the placeholder syntax of the database.
+func NewSQL(db *sql.DB, driverName string) (*SQL, error) {
+	s := &SQL{
+		db:       db,
+		numbered: driverName == "postgres" || driverName == "pgx",
+	}
+	if err := s.migrate(); err != nil {
+		return nil, fmt.Errorf("failed to migrate schema: %s", err)
+	}
+	return s, nil
+}
+
+// migrate applies each migration which has not yet been recorded in the schema_migrations table. Each migration is
+// applied in its own transaction alongside its version record. Replicas started against the same database may race
+// to apply a migration; if applying one fails, the schema version is re-read and migration continues if another
+// replica has since applied it.
+func (s *SQL) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		// some databases fail to create a table which is concurrently being created by another replica
+		if _, versionErr := s.schemaVersion(); versionErr != nil {
+			return fmt.Errorf("failed to create schema_migrations table: %s", err)
+		}
+	}
+
+	for {
+		current, err := s.schemaVersion()
+		if err != nil {
+			return err
+		}
+		if current >= len(migrations) {
+			return nil
+		}
+
+		version := current + 1
+		if err := s.applyMigration(version, migrations[current]); err != nil {
+			if latest, versionErr := s.schemaVersion(); versionErr == nil && latest >= version {
+				continue
+			}
+			return fmt.Errorf("failed to apply migration %d: %s", version, err)
+		}
+	}
+}
+
+// schemaVersion returns the version of the latest migration recorded in the schema_migrations table, or 0 if none
+// have been applied.
+func (s *SQL) schemaVersion() (int, error) {
+	var current sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&current); err != nil {
+		return 0, fmt.Errorf("failed to determine schema version: %s", err)
+	}
+	return int(current.Int64), nil
+}
+
+// applyMigration executes a single migration statement and records its version in one transaction.
+func (s *SQL) applyMigration(version int, statement string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(statement); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), version, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebind rewrites "?" placeholders to "$1", "$2", etc. for drivers which require numbered placeholders.
+func (s *SQL) rebind(query string) string {
+	if !s.numbered {
+		return query
+	}
+
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// nullTime converts a zero time to a NULL column value.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// createdAt returns the record's creation time, defaulting to the current time if it is not set.
+func createdAt(record Record) time.Time {
+	if record.CreatedAt.IsZero() {
+		return time.Now().UTC()
+	}
+	return record.CreatedAt.UTC()
+}
+
+// SetContext sets the given record for a given key in the store. If the key exists already, the record will be
+// overwritten but its creation time is retained.
+func (s *SQL) SetContext(ctx context.Context, key string, record Record) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO links (hash, original_url, created_at, updated_at, expires_at, redirect_status, owner)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET original_url = excluded.original_url, updated_at = excluded.updated_at,
+		expires_at = excluded.expires_at, redirect_status = excluded.redirect_status, owner = excluded.owner`),
+		key, record.URL, createdAt(record), time.Now().UTC(), nullTime(record.ExpiresAt), record.RedirectStatus, record.Owner)
+	if err != nil {
+		return fmt.Errorf("failed to upsert link: %w", err)
+	}
+	return nil
+}
+
+// SetIfAbsentContext sets the given record for a given key only if the key does not already exist. The check and insert
+// are performed atomically by the database. If the key exists already, ErrKeyExists is returned.
+func (s *SQL) SetIfAbsentContext(ctx context.Context, key string, record Record) error {
+	result, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO links (hash, original_url, created_at, updated_at, expires_at, redirect_status, owner)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO NOTHING`),
+		key, record.URL, createdAt(record), time.Now().UTC(), nullTime(record.ExpiresAt), record.RedirectStatus, record.Owner)
+	if err != nil {
+		return fmt.Errorf("failed to insert link: %w", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to determine inserted rows: %s", err)
+	}
+	if inserted == 0 {
+		return ErrKeyExists
+	}
+	return nil
+}
+
+// UpdateContext replaces the record for a given key, retaining its creation time. If the key is not found,
+// ErrKeyNotFound is returned.
+func (s *SQL) UpdateContext(ctx context.Context, key string, record Record) error {
+	result, err := s.db.ExecContext(ctx, s.rebind(`UPDATE links SET original_url = ?, updated_at = ?, expires_at = ?, redirect_status = ?,
+		owner = ? WHERE hash = ?`),
+		record.URL, time.Now().UTC(), nullTime(record.ExpiresAt), record.RedirectStatus, record.Owner, key)
+	if err != nil {
+		return fmt.Errorf("failed to update link: %w", err)
+	}
+	return requireAffected(result)
+}
+
+// DeleteContext removes the record for a given key. If the key is not found, ErrKeyNotFound is returned.
+func (s *SQL) DeleteContext(ctx context.Context, key string) error {
+	result, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM links WHERE hash = ?`), key)
+	if err != nil {
+		return fmt.Errorf("failed to delete link: %w", err)
+	}
+	return requireAffected(result)
+}
+
+// requireAffected returns ErrKeyNotFound if a statement did not affect any rows.
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to determine affected rows: %s", err)
+	}
+	if affected == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// ListContext returns up to limit entries ordered by key, starting after the given cursor key. The returned cursor is
+// empty once every entry has been listed.
+func (s *SQL) ListContext(ctx context.Context, cursor string, limit int) ([]Entry, string, error) {
+	if limit < 1 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	// fetch an extra row to determine if there is another page
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+linkColumns+` FROM links WHERE hash > ? ORDER BY hash LIMIT ?`),
+		cursor, limit+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query links: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]Entry, 0, limit)
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to scan link: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to iterate links: %w", err)
+	}
+
+	var next string
+	if len(entries) > limit {
+		entries = entries[:limit]
+		next = entries[limit-1].Key
+	}
+	return entries, next, nil
+}
+
+// linkColumns are the columns scanned by scanEntry.
+const linkColumns = `hash, original_url, created_at, expires_at, redirect_status, owner`
+
+// scanEntry scans a row of linkColumns into an Entry.
+func scanEntry(row interface{ Scan(...interface{}) error }) (Entry, error) {
+	var (
+		entry     Entry
+		expiresAt sql.NullTime
+	)
+	err := row.Scan(&entry.Key, &entry.Record.URL, &entry.Record.CreatedAt, &expiresAt, &entry.Record.RedirectStatus,
+		&entry.Record.Owner)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Record.ExpiresAt = expiresAt.Time
+	return entry, nil
+}
+
+// GetContext returns the record for a given key. If the key is not found, ErrKeyNotFound is returned. Expired records
+// are returned until they are reaped.
+func (s *SQL) GetContext(ctx context.Context, key string) (Record, error) {
+	entry, err := scanEntry(s.db.QueryRowContext(ctx, s.rebind(`SELECT `+linkColumns+` FROM links WHERE hash = ?`), key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Record{}, ErrKeyNotFound
+		}
+		return Record{}, fmt.Errorf("failed to query link: %w", err)
+	}
+	return entry.Record, nil
+}
+
+// GetByURLContext returns the most recently created key and its record which match the query. If no record matches,
+// ErrKeyNotFound is returned.
+func (s *SQL) GetByURLContext(ctx context.Context, query URLQuery) (string, Record, error) {
+	// keys are matched to the namespace by their prefix, or by the absence of a "/" for the empty namespace
+	namespaceClause, args := `hash NOT LIKE '%/%'`, []interface{}{query.URL, query.Owner, query.RedirectStatus}
+	if query.Namespace != "" {
+		prefix := query.Namespace + "/"
+		namespaceClause, args = `SUBSTR(hash, 1, ?) = ?`, append(args, len(prefix), prefix)
+	}
+
+	entry, err := scanEntry(s.db.QueryRowContext(ctx, s.rebind(`SELECT `+linkColumns+` FROM links WHERE original_url = ?
+		AND owner = ? AND redirect_status = ? AND expires_at IS NULL AND `+namespaceClause+`
+		ORDER BY created_at DESC LIMIT 1`), args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", Record{}, ErrKeyNotFound
+		}
+		return "", Record{}, fmt.Errorf("failed to query link by URL: %w", err)
+	}
+	return entry.Key, entry.Record, nil
+}
+
+// Set sets the given record for a given key in the store, as SetContext does without a deadline.
+func (s *SQL) Set(key string, record Record) error {
+	return s.SetContext(context.Background(), key, record)
+}
+
+// SetIfAbsent sets the given record for a given key only if the key does not already exist, as SetIfAbsentContext does
+// without a deadline.
+func (s *SQL) SetIfAbsent(key string, record Record) error {
+	return s.SetIfAbsentContext(context.Background(), key, record)
+}
+
+// Update replaces the record for a given key, as UpdateContext does without a deadline.
+func (s *SQL) Update(key string, record Record) error {
+	return s.UpdateContext(context.Background(), key, record)
+}
+
+// Delete removes the record for a given key, as DeleteContext does without a deadline.
+func (s *SQL) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// List returns up to limit entries ordered by key, as ListContext does without a deadline.
+func (s *SQL) List(cursor string, limit int) ([]Entry, string, error) {
+	return s.ListContext(context.Background(), cursor, limit)
+}
+
+// Get returns the record for a given key, as GetContext does without a deadline.
+func (s *SQL) Get(key string) (Record, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+// GetByURL returns the most recently created key and its record which match the query, as GetByURLContext does
+// without a deadline.
+func (s *SQL) GetByURL(query URLQuery) (string, Record, error) {
+	return s.GetByURLContext(context.Background(), query)
+}
+
+// Len returns the number of links in the database, including expired links which have not been reaped.
+func (s *SQL) Len() (int, error) {
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM links`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count links: %s", err)
+	}
+	return count, nil
+}
+
+// Reap deletes every record which has expired as of the given time, returning the number of records deleted.
+func (s *SQL) Reap(now time.Time) (int, error) {
+	result, err := s.db.Exec(s.rebind(`DELETE FROM links WHERE expires_at IS NOT NULL AND expires_at <= ?`), now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired links: %s", err)
+	}
+
+	reaped, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine deleted rows: %s", err)
+	}
+	return int(reaped), nil
+}
+
+// Close closes the underlying database.
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
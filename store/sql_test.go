@@ -0,0 +1,209 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQL_SetGet(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sql-store")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "links.db")
+
+	sqlStore, err := OpenSQL("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("failed to open SQL store: %s", err)
+	}
+
+	if _, err := sqlStore.Get("123456"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := sqlStore.Set("123456", Record{URL: "https://jemgunay.co.uk"}); err != nil {
+		t.Fatalf("failed to set key: %s", err)
+	}
+	if err := sqlStore.Set("123456", Record{URL: "https://jemgunay.co.uk/updated", RedirectStatus: 302, Owner: "jem"}); err != nil {
+		t.Fatalf("failed to overwrite key: %s", err)
+	}
+	if err := sqlStore.SetIfAbsent("123456", Record{URL: "https://jemgunay.co.uk/clobbered"}); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if err := sqlStore.SetIfAbsent("abcdef", Record{URL: "https://jemgunay.co.uk/blog", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("failed to set absent key: %s", err)
+	}
+	if err := sqlStore.Close(); err != nil {
+		t.Fatalf("failed to close SQL store: %s", err)
+	}
+
+	// reopening the database should not re-apply migrations and should retain the data
+	reopened, err := OpenSQL("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("failed to reopen SQL store: %s", err)
+	}
+	defer reopened.Close()
+
+	got, err := reopened.Get("123456")
+	if err != nil {
+		t.Fatalf("failed to get key: %s", err)
+	}
+	if got.URL != "https://jemgunay.co.uk/updated" {
+		t.Fatalf("unexpected URL, expected %s, got %s", "https://jemgunay.co.uk/updated", got.URL)
+	}
+	if got.CreatedAt.IsZero() || !got.ExpiresAt.IsZero() || got.RedirectStatus != 302 || got.Owner != "jem" {
+		t.Fatalf("unexpected record: %+v", got)
+	}
+
+	key, _, err := reopened.GetByURL(URLQuery{URL: "https://jemgunay.co.uk/updated", Owner: "jem", RedirectStatus: 302})
+	if err != nil || key != "123456" {
+		t.Fatalf("unexpected key for URL: %s, %v", key, err)
+	}
+
+	if err := reopened.Update("123456", Record{URL: "https://jemgunay.co.uk/retargeted"}); err != nil {
+		t.Fatalf("failed to update key: %s", err)
+	}
+	if err := reopened.Update("missing", Record{URL: "https://jemgunay.co.uk"}); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound when updating missing key, got %v", err)
+	}
+
+	entries, next, err := reopened.List("", 1)
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Key != "123456" || entries[0].Record.URL != "https://jemgunay.co.uk/retargeted" || next != "123456" {
+		t.Fatalf("unexpected first page: %+v, next %s", entries, next)
+	}
+	entries, next, err = reopened.List(next, 1)
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Key != "abcdef" || next != "" {
+		t.Fatalf("unexpected final page: %+v, next %s", entries, next)
+	}
+	for _, limit := range []int{0, -1} {
+		if _, _, err := reopened.List("", limit); err != ErrInvalidLimit {
+			t.Fatalf("expected ErrInvalidLimit for limit %d, got %v", limit, err)
+		}
+	}
+
+	// only the expired record should be reaped
+	reaped, err := reopened.Reap(time.Now())
+	if err != nil {
+		t.Fatalf("failed to reap: %s", err)
+	}
+	if reaped != 1 {
+		t.Fatalf("unexpected reap count, expected 1, got %d", reaped)
+	}
+	if _, err := reopened.Get("abcdef"); err != ErrKeyNotFound {
+		t.Fatalf("expected reaped key to be deleted, got %v", err)
+	}
+	if size, err := reopened.Len(); err != nil || size != 1 {
+		t.Fatalf("unexpected store size, expected 1, got %d (%v)", size, err)
+	}
+
+	if err := reopened.Delete("123456"); err != nil {
+		t.Fatalf("failed to delete key: %s", err)
+	}
+	if err := reopened.Delete("123456"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound when deleting missing key, got %v", err)
+	}
+
+	var version int
+	if err := reopened.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatalf("failed to query schema version: %s", err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("unexpected schema version, expected %d, got %d", len(migrations), version)
+	}
+}
+
+func TestSQL_GetByURL(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sql-store")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sqlStore, err := OpenSQL("sqlite3", filepath.Join(dir, "links.db"))
+	if err != nil {
+		t.Fatalf("failed to open SQL store: %s", err)
+	}
+	defer sqlStore.Close()
+
+	testGetByURL(t, sqlStore)
+}
+
+func TestSQL_ConcurrentMigrations(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sql-store")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	// writers wait for the database lock rather than failing immediately, as a server database would
+	dsn := filepath.Join(dir, "links.db") + "?_busy_timeout=5000"
+
+	// replicas started together against an empty database race to apply the same migrations
+	const replicas = 8
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, replicas)
+	)
+	for i := 0; i < replicas; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := sql.Open("sqlite3", dsn)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer db.Close()
+			_, err = NewSQL(db, "sqlite3")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to start replica: %s", err)
+		}
+	}
+
+	sqlStore, err := OpenSQL("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("failed to open SQL store: %s", err)
+	}
+	defer sqlStore.Close()
+
+	version, err := sqlStore.schemaVersion()
+	if err != nil {
+		t.Fatalf("failed to query schema version: %s", err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("unexpected schema version, expected %d, got %d", len(migrations), version)
+	}
+}
+
+func TestSQL_Rebind(t *testing.T) {
+	query := `INSERT INTO links (hash, original_url) VALUES (?, ?)`
+
+	s := &SQL{}
+	if got := s.rebind(query); got != query {
+		t.Fatalf("unexpected query, expected %s, got %s", query, got)
+	}
+
+	s.numbered = true
+	expected := `INSERT INTO links (hash, original_url) VALUES ($1, $2)`
+	if got := s.rebind(query); got != expected {
+		t.Fatalf("unexpected query, expected %s, got %s", expected, got)
+	}
+}
@@ -2,51 +2,321 @@ package store
 
 import (
 	"errors"
+	"log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
-// Storage defines the requirements for a type which can persist and retrieve key/value pairs.
+// Record is a short link persisted against a key in a Storage.
+type Record struct {
+	// URL is the original URL which the short link redirects to.
+	URL string `json:"url"`
+	// CreatedAt is the time the link was created.
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is the time after which the link is no longer valid. A zero value indicates the link never expires.
+	ExpiresAt time.Time `json:"expires_at"`
+	// RedirectStatus is the HTTP status code used to redirect to the URL. A zero value indicates the server's default.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// Owner identifies who created the link. It is empty for links created without authentication.
+	Owner string `json:"owner,omitempty"`
+}
+
+// Expired determines if the record has expired as of the given time.
+func (r Record) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// URLQuery selects the records matched by GetByURL: records with the URL, owner and redirect status which never expire
+// and whose keys are in the namespace. Keys of the form "namespace/key" are namespaced, while an empty namespace
+// matches keys without a "/".
+type URLQuery struct {
+	URL            string
+	Owner          string
+	RedirectStatus int
+	Namespace      string
+}
+
+// query returns the URLQuery which matches the given key and record.
+func (r Record) query(key string) URLQuery {
+	return URLQuery{URL: r.URL, Owner: r.Owner, RedirectStatus: r.RedirectStatus, Namespace: keyNamespace(key)}
+}
+
+// keyNamespace returns the namespace of a key, which is empty if the key is not namespaced.
+func keyNamespace(key string) string {
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// Entry is a key/record pair returned when listing a Storage.
+type Entry struct {
+	Key    string
+	Record Record
+}
+
+// Storage defines the requirements for a type which can persist and retrieve key/record pairs.
+//
+// List returns up to limit entries ordered by key, starting after the provided cursor key (an empty cursor starts from
+// the first key). It also returns the cursor to resume listing from, which is empty once every entry has been listed.
+// ErrInvalidLimit is returned if the limit is not greater than zero.
+//
+// GetByURL returns the most recently set key and its record which match the query, or ErrKeyNotFound if none do.
 type Storage interface {
-	Set(key, value string) error
-	Get(key string) (string, error)
+	Set(key string, record Record) error
+	SetIfAbsent(key string, record Record) error
+	Update(key string, record Record) error
+	Get(key string) (Record, error)
+	GetByURL(query URLQuery) (string, Record, error)
+	Delete(key string) error
+	List(cursor string, limit int) ([]Entry, string, error)
+}
+
+// Reaper defines the requirements for a Storage which can delete its expired records.
+type Reaper interface {
+	Reap(now time.Time) (int, error)
+}
+
+// Sizer defines the requirements for a Storage which can count its records.
+type Sizer interface {
+	Len() (int, error)
+}
+
+// StartReaper calls Reap on the given Reaper at the provided interval in a background goroutine. The returned func
+// stops the reaper and blocks until it has exited. The reaper is disabled if the interval is not positive.
+func StartReaper(reaper Reaper, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+
+	go func() {
+		defer close(doneCh)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				reaped, err := reaper.Reap(now)
+				if err != nil {
+					log.Printf("failed to reap expired records: %s", err)
+					continue
+				}
+				if reaped > 0 {
+					log.Printf("reaped %d expired records", reaped)
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stopCh)
+		<-doneCh
+	}
 }
 
-// Store is a concurrency safe map-driven key/value store. It satisfies the Storage interface.
+// Store is a concurrency safe map-driven key/value store. It satisfies the Storage, Reaper and Sizer interfaces.
 type Store struct {
-	lookup map[string]string
-	mu     *sync.RWMutex
+	lookup map[string]Record
+	// reverse indexes the most recently set key of the non-expiring records matched by each URLQuery
+	reverse map[URLQuery]string
+	mu      *sync.RWMutex
 }
 
-// Ensure Store satisfies Storage.
-var _ Storage = Store{}
+// Ensure Store satisfies Storage, Reaper and Sizer.
+var (
+	_ Storage = Store{}
+	_ Reaper  = Store{}
+	_ Sizer   = Store{}
+)
 
 // New creates an initialised Store.
 func New() Store {
 	return Store{
-		lookup: make(map[string]string),
-		mu:     &sync.RWMutex{},
+		lookup:  make(map[string]Record),
+		reverse: make(map[URLQuery]string),
+		mu:      &sync.RWMutex{},
 	}
 }
 
-// Set sets the given value for a given key in the store. If the key exists already, the value will be overwritten.
-func (s Store) Set(key, value string) error {
+// Set sets the given record for a given key in the store. If the key exists already, the record will be overwritten.
+func (s Store) Set(key string, record Record) error {
 	s.mu.Lock()
-	s.lookup[key] = value
+	s.set(key, record)
 	s.mu.Unlock()
 	return nil
 }
 
-// ErrKeyNotFound indicates that a value could not be found in the store for the provided key.
+// set sets the record for a key and maintains the reverse index. The caller must hold the write lock.
+func (s Store) set(key string, record Record) {
+	if existing, ok := s.lookup[key]; ok {
+		s.unindex(key, existing)
+	}
+	s.lookup[key] = record
+	if record.ExpiresAt.IsZero() {
+		s.reverse[record.query(key)] = key
+	}
+}
+
+// remove deletes a key and its reverse index entry. The caller must hold the write lock.
+func (s Store) remove(key string) {
+	if existing, ok := s.lookup[key]; ok {
+		s.unindex(key, existing)
+	}
+	delete(s.lookup, key)
+}
+
+// unindex removes the reverse index entry of a record if it points at the given key. The caller must hold the write
+// lock.
+func (s Store) unindex(key string, record Record) {
+	if query := record.query(key); s.reverse[query] == key {
+		delete(s.reverse, query)
+	}
+}
+
+// ErrKeyExists indicates that a record could not be set for the provided key as the key already exists in the store.
+var ErrKeyExists = errors.New("key already exists in store")
+
+// SetIfAbsent sets the given record for a given key in the store only if the key does not already exist. If the key
+// exists already, ErrKeyExists is returned and the existing record is left untouched.
+func (s Store) SetIfAbsent(key string, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup[key]; ok {
+		return ErrKeyExists
+	}
+	s.set(key, record)
+	return nil
+}
+
+// ErrKeyNotFound indicates that a record could not be found in the store for the provided key.
 var ErrKeyNotFound = errors.New("key not found in store")
 
-// Get returns the value for a given key. If the key is not found, ErrKeyNotFound is returned.
-func (s Store) Get(key string) (string, error) {
+// ErrInvalidLimit indicates that entries could not be listed as the provided limit is not greater than zero.
+var ErrInvalidLimit = errors.New("list limit must be greater than zero")
+
+// Update replaces the record for a given key in the store. If the key is not found, ErrKeyNotFound is returned.
+func (s Store) Update(key string, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup[key]; !ok {
+		return ErrKeyNotFound
+	}
+	s.set(key, record)
+	return nil
+}
+
+// Get returns the record for a given key. If the key is not found, ErrKeyNotFound is returned. Expired records are
+// returned until they are reaped.
+func (s Store) Get(key string) (Record, error) {
 	s.mu.RLock()
-	val, ok := s.lookup[key]
+	record, ok := s.lookup[key]
 	s.mu.RUnlock()
 
 	if !ok {
-		return "", ErrKeyNotFound
+		return Record{}, ErrKeyNotFound
+	}
+	return record, nil
+}
+
+// GetByURL returns the most recently set key and its record which match the query. If no record matches,
+// ErrKeyNotFound is returned.
+func (s Store) GetByURL(query URLQuery) (string, Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.reverse[query]
+	if !ok {
+		return "", Record{}, ErrKeyNotFound
+	}
+	return key, s.lookup[key], nil
+}
+
+// Delete removes the record for a given key from the store. If the key is not found, ErrKeyNotFound is returned.
+func (s Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup[key]; !ok {
+		return ErrKeyNotFound
+	}
+	s.remove(key)
+	return nil
+}
+
+// List returns up to limit entries ordered by key, starting after the given cursor key. The returned cursor is empty
+// once every entry has been listed.
+func (s Store) List(cursor string, limit int) ([]Entry, string, error) {
+	if limit < 1 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.lookup))
+	for key := range s.lookup {
+		if key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var next string
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+
+	entries := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, Entry{Key: key, Record: s.lookup[key]})
+	}
+	return entries, next, nil
+}
+
+// Len returns the number of records in the store, including expired records which have not been reaped.
+func (s Store) Len() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.lookup), nil
+}
+
+// Reap deletes every record which has expired as of the given time, returning the number of records deleted.
+func (s Store) Reap(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reaped int
+	for key, record := range s.lookup {
+		if record.Expired(now) {
+			s.remove(key)
+			reaped++
+		}
+	}
+	return reaped, nil
+}
+
+// expired returns the keys of every record which has expired as of the given time.
+func (s Store) expired(now time.Time) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for key, record := range s.lookup {
+		if record.Expired(now) {
+			keys = append(keys, key)
+		}
 	}
-	return val, nil
+	return keys
 }
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStore_Reap(t *testing.T) {
+	now := time.Now()
+
+	s := New()
+	s.Set("expired", Record{URL: "https://jemgunay.co.uk", ExpiresAt: now.Add(-time.Minute)})
+	s.Set("expires_now", Record{URL: "https://jemgunay.co.uk", ExpiresAt: now})
+	s.Set("live", Record{URL: "https://jemgunay.co.uk", ExpiresAt: now.Add(time.Minute)})
+	s.Set("never_expires", Record{URL: "https://jemgunay.co.uk"})
+
+	reaped, err := s.Reap(now)
+	if err != nil {
+		t.Fatalf("failed to reap: %s", err)
+	}
+	if reaped != 2 {
+		t.Fatalf("unexpected reap count, expected 2, got %d", reaped)
+	}
+
+	for _, key := range []string{"expired", "expires_now"} {
+		if _, err := s.Get(key); err != ErrKeyNotFound {
+			t.Fatalf("expected %s to be reaped, got %v", key, err)
+		}
+	}
+	for _, key := range []string{"live", "never_expires"} {
+		if _, err := s.Get(key); err != nil {
+			t.Fatalf("expected %s to remain, got %v", key, err)
+		}
+	}
+	if size, err := s.Len(); err != nil || size != 2 {
+		t.Fatalf("unexpected store size, expected 2, got %d (%v)", size, err)
+	}
+}
+
+// signalReaper signals each call to Reap, without blocking if a previous call has not been received.
+type signalReaper struct {
+	calls chan time.Time
+}
+
+func (s signalReaper) Reap(now time.Time) (int, error) {
+	select {
+	case s.calls <- now:
+	default:
+	}
+	return 0, nil
+}
+
+func TestStartReaper(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		respReap bool
+	}{
+		{name: "enabled", interval: time.Millisecond, respReap: true},
+		{name: "zero_interval_disabled", interval: 0},
+		{name: "negative_interval_disabled", interval: -time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reaper := signalReaper{calls: make(chan time.Time, 1)}
+			stop := StartReaper(reaper, tt.interval)
+
+			select {
+			case <-reaper.calls:
+				if !tt.respReap {
+					t.Fatalf("expected reaper to be disabled")
+				}
+			case <-time.After(time.Millisecond * 50):
+				if tt.respReap {
+					t.Fatalf("expected reaper to reap")
+				}
+			}
+			stop()
+		})
+	}
+}
+
+// testGetByURL checks that GetByURL only matches the non-expiring records with the URL, owner, redirect status and
+// namespace of the query.
+func testGetByURL(t *testing.T, storage Storage) {
+	url := "https://jemgunay.co.uk"
+	if _, _, err := storage.GetByURL(URLQuery{URL: url}); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	createdAt := time.Now().Add(-time.Hour)
+	records := map[string]Record{
+		"alice1":         {URL: url, Owner: "alice"},
+		"bob1":           {URL: url, Owner: "bob"},
+		"alice2":         {URL: url, Owner: "alice", RedirectStatus: 302},
+		"sho.rt/alice3":  {URL: url, Owner: "alice"},
+		"alice4":         {URL: url, Owner: "alice", ExpiresAt: time.Now().Add(time.Hour)},
+		"alice5":         {URL: url + "/blog", Owner: "alice"},
+		"sho.rt/anon1":   {URL: url},
+		"other.io/anon2": {URL: url},
+	}
+	for key, record := range records {
+		record.CreatedAt = createdAt
+		if err := storage.Set(key, record); err != nil {
+			t.Fatalf("failed to set key %s: %s", key, err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query URLQuery
+		key   string
+	}{
+		{name: "owner", query: URLQuery{URL: url, Owner: "alice"}, key: "alice1"},
+		{name: "other_owner", query: URLQuery{URL: url, Owner: "bob"}, key: "bob1"},
+		{name: "redirect_status", query: URLQuery{URL: url, Owner: "alice", RedirectStatus: 302}, key: "alice2"},
+		{name: "namespace", query: URLQuery{URL: url, Owner: "alice", Namespace: "sho.rt"}, key: "sho.rt/alice3"},
+		{name: "anonymous", query: URLQuery{URL: url, Namespace: "sho.rt"}, key: "sho.rt/anon1"},
+		{name: "unknown_owner", query: URLQuery{URL: url, Owner: "carol"}},
+		{name: "unknown_namespace", query: URLQuery{URL: url, Owner: "alice", Namespace: "sho"}},
+		{name: "unknown_redirect_status", query: URLQuery{URL: url, Owner: "bob", RedirectStatus: 307}},
+		{name: "anonymous_without_namespace", query: URLQuery{URL: url}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, record, err := storage.GetByURL(tt.query)
+			if tt.key == "" {
+				if err != ErrKeyNotFound {
+					t.Fatalf("expected ErrKeyNotFound, got key %s (%v)", key, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to get by URL: %s", err)
+			}
+			if key != tt.key || record.URL != tt.query.URL || record.Owner != tt.query.Owner {
+				t.Fatalf("unexpected key/record, expected %s, got %s: %+v", tt.key, key, record)
+			}
+		})
+	}
+}
+
+func TestStore_GetByURL(t *testing.T) {
+	testGetByURL(t, New())
+
+	s := New()
+	s.Set("123456", Record{URL: "https://jemgunay.co.uk"})
+
+	// retargeting the key should remove it from the index of its previous URL
+	s.Set("123456", Record{URL: "https://jemgunay.co.uk/blog"})
+	if _, _, err := s.GetByURL(URLQuery{URL: "https://jemgunay.co.uk"}); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound after retarget, got %v", err)
+	}
+	if key, _, err := s.GetByURL(URLQuery{URL: "https://jemgunay.co.uk/blog"}); err != nil || key != "123456" {
+		t.Fatalf("unexpected key for retargeted URL: %s, %v", key, err)
+	}
+
+	// giving the record an expiry should remove it from the index
+	s.Set("123456", Record{URL: "https://jemgunay.co.uk/blog", ExpiresAt: time.Now().Add(time.Hour)})
+	if _, _, err := s.GetByURL(URLQuery{URL: "https://jemgunay.co.uk/blog"}); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound after setting an expiry, got %v", err)
+	}
+
+	// deleted records should be removed from the index
+	s.Set("abcdef", Record{URL: "https://jemgunay.co.uk/deleted"})
+	s.Delete("abcdef")
+	if _, _, err := s.GetByURL(URLQuery{URL: "https://jemgunay.co.uk/deleted"}); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound after delete, got %v", err)
+	}
+}
+
+func TestStore_UpdateDeleteList(t *testing.T) {
+	s := New()
+	if err := s.Update("123456", Record{URL: "https://jemgunay.co.uk"}); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound when updating missing key, got %v", err)
+	}
+	if err := s.Delete("123456"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound when deleting missing key, got %v", err)
+	}
+
+	for _, key := range []string{"c", "a", "b"} {
+		s.Set(key, Record{URL: "https://jemgunay.co.uk/" + key})
+	}
+	if err := s.Update("b", Record{URL: "https://jemgunay.co.uk/updated"}); err != nil {
+		t.Fatalf("failed to update key: %s", err)
+	}
+	if err := s.Delete("c"); err != nil {
+		t.Fatalf("failed to delete key: %s", err)
+	}
+
+	entries, next, err := s.List("", 1)
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Key != "a" || next != "a" {
+		t.Fatalf("unexpected first page: %+v, next %s", entries, next)
+	}
+
+	entries, next, err = s.List(next, 5)
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Key != "b" || entries[0].Record.URL != "https://jemgunay.co.uk/updated" || next != "" {
+		t.Fatalf("unexpected final page: %+v, next %s", entries, next)
+	}
+
+	for _, limit := range []int{0, -1} {
+		if _, _, err := s.List("", limit); err != ErrInvalidLimit {
+			t.Fatalf("expected ErrInvalidLimit for limit %d, got %v", limit, err)
+		}
+	}
+}